@@ -0,0 +1,219 @@
+// Package audit records who changed which metadata key and rolled out which
+// instance group.
+package audit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fresh8/rollerderby/diff"
+	"github.com/fresh8/rollerderby/errors"
+	"github.com/fresh8/rollerderby/logger"
+)
+
+const (
+	// ActionUpdate is recorded for a common metadata key change.
+	ActionUpdate = "update"
+
+	// ActionReplace is recorded for a rolling replace of an instance group.
+	ActionReplace = "replace"
+
+	// ActionReveal is recorded when unmasked secret values are shown.
+	ActionReveal = "reveal"
+
+	// ActionDelete is recorded when a common metadata key is removed.
+	ActionDelete = "delete"
+
+	// OutcomeSuccess is recorded when an action completes without error.
+	OutcomeSuccess = "success"
+
+	// OutcomeFailure is recorded when an action returns an error.
+	OutcomeFailure = "failure"
+
+	// OverrideForce is recorded when a value was written without schema
+	// validation.
+	OverrideForce = "force"
+
+	// OverrideAllowDowngrade is recorded when a semver key was allowed to move
+	// to a lower version.
+	OverrideAllowDowngrade = "allow-downgrade"
+
+	// OverrideAllowProtected is recorded when a reserved or protected key was
+	// allowed to change.
+	OverrideAllowProtected = "allow-protected"
+
+	// OverrideAllowEmpty is recorded when a blank value was written.
+	OverrideAllowEmpty = "allow-empty"
+)
+
+// Entry is a single audit record.
+type Entry struct {
+	// ID identifies the entry across sinks, Multi.Record sets it when blank.
+	ID string `json:"id,omitempty"`
+
+	Time     time.Time     `json:"time"`
+	User     string        `json:"user"`
+	Host     string        `json:"host"`
+	Action   string        `json:"action"`
+	Project  string        `json:"project"`
+	Zone     string        `json:"zone,omitempty"`
+	Group    string        `json:"group,omitempty"`
+	Key      string        `json:"key,omitempty"`
+	OldValue string        `json:"old_value,omitempty"`
+	NewValue string        `json:"new_value,omitempty"`
+	Version  string        `json:"version,omitempty"`
+	Outcome  string        `json:"outcome"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+	TraceID  string        `json:"trace_id,omitempty"`
+
+	// Overrides lists the safety checks the operator explicitly bypassed,
+	// such as OverrideForce.
+	Overrides []string `json:"overrides,omitempty"`
+}
+
+// Filter selects entries in a query, blank fields match everything.
+type Filter struct {
+	Key   string
+	Group string
+	Since time.Time
+	Until time.Time
+}
+
+// Match reports whether e satisfies the filter.
+func (f Filter) Match(e Entry) bool {
+	if f.Key != "" && f.Key != e.Key {
+		return false
+	}
+
+	if f.Group != "" && f.Group != e.Group {
+		return false
+	}
+
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+// Sink stores and queries audit entries.
+type Sink interface {
+	Record(ctx context.Context, e Entry) error
+	Query(ctx context.Context, f Filter) ([]Entry, error)
+}
+
+// key returns the ID of e, or its identifying fields for entries recorded
+// before entries had an ID.
+func (e Entry) key() string {
+	if e.ID != "" {
+		return e.ID
+	}
+
+	return strings.Join([]string{e.Time.UTC().Format(time.RFC3339Nano), e.User, e.Host, e.Action, e.Project, e.Zone, e.Group, e.Key, e.Outcome}, "\x00")
+}
+
+// NewID returns a random entry ID.
+func NewID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// Multi records entries to every sink it contains.
+type Multi []Sink
+
+// Record writes e to all sinks with the same ID returning the combined
+// errors.
+func (m Multi) Record(ctx context.Context, e Entry) error {
+	if e.ID == "" {
+		e.ID = NewID()
+	}
+
+	var errs errors.Errors
+	for _, s := range m {
+		err := s.Record(ctx, e)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if errs != nil {
+		return errs
+	}
+
+	return nil
+}
+
+// Query returns the matching entries of every sink oldest first, an entry
+// recorded to several sinks is returned once. A sink that fails is logged
+// unless every sink fails, when the combined errors are returned.
+func (m Multi) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	var errs errors.Errors
+	var result []Entry
+	seen := make(map[string]bool)
+	for _, s := range m {
+		entries, err := s.Query(ctx, f)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, e := range entries {
+			if seen[e.key()] {
+				continue
+			}
+			seen[e.key()] = true
+			result = append(result, e)
+		}
+	}
+
+	if errs != nil && len(errs) == len(m) {
+		return nil, errs
+	}
+	for _, err := range errs {
+		logger.Warnf(ctx, "unable to query audit entries: %v", err)
+	}
+	sort.Stable(ByTime(result))
+
+	return result, nil
+}
+
+// ByTime is a sortable interface for entries ordered oldest first.
+type ByTime []Entry
+
+func (a ByTime) Len() int           { return len(a) }
+func (a ByTime) Less(i, j int) bool { return a[i].Time.Before(a[j].Time) }
+func (a ByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+
+func filter(entries []Entry, f Filter) []Entry {
+	var result []Entry
+	for _, e := range entries {
+		if f.Match(e) {
+			result = append(result, e)
+		}
+	}
+	sort.Stable(ByTime(result))
+
+	return result
+}
+
+// Print outputs a table of entries.
+func Print(entries []Entry) {
+	fmt.Printf("%-20.20s | %-25.25s | %-7.7s | %-7.7s | %-20.20s | %-20.20s | %-35.35s | %s\n", "time", "user", "action", "outcome", "group", "key", "change", "duration")
+	fmt.Printf("%s\n", strings.Repeat("=", 20+25+7+7+20+20+35+10+7*3))
+	for _, e := range entries {
+		change := fmt.Sprintf("%s -> %s", diff.Summary(e.OldValue), diff.Summary(e.NewValue))
+		fmt.Printf("%-20.20s | %-25.25s | %-7.7s | %-7.7s | %-20.20s | %-20.20s | %-35.35s | %v\n",
+			e.Time.Format(time.RFC3339), e.User, e.Action, e.Outcome, e.Group, e.Key, change, e.Duration.Round(time.Millisecond))
+	}
+}
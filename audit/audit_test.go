@@ -0,0 +1,85 @@
+package audit
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMultiQueryMergesSinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	home := &File{Path: filepath.Join(dir, "home.jsonl")}
+	ring := &File{Path: filepath.Join(dir, "ring.jsonl")}
+	m := Multi{home, ring}
+
+	now := time.Now().UTC()
+	// recorded to both sinks, returned once.
+	err = m.Record(ctx, Entry{Time: now.Add(-time.Hour), User: "a", Action: ActionUpdate, Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// only in the shared ring, from another operator.
+	err = ring.Record(ctx, Entry{ID: "other", Time: now.Add(-2 * time.Hour), User: "b", Action: ActionUpdate, Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// recorded before entries had IDs, the same entry in both files.
+	old := Entry{Time: now.Add(-3 * time.Hour), User: "c", Action: ActionReplace, Key: "k"}
+	home.Record(ctx, old)
+	ring.Record(ctx, old)
+
+	entries, err := m.Query(ctx, Filter{Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var users []string
+	for _, e := range entries {
+		users = append(users, e.User)
+	}
+	if len(users) != 3 || users[0] != "c" || users[1] != "b" || users[2] != "a" {
+		t.Errorf("Query users = %v, want [c b a]", users)
+	}
+	if entries[2].ID == "" {
+		t.Errorf("Record did not set an ID")
+	}
+}
+
+type failSink struct{}
+
+func (failSink) Record(ctx context.Context, e Entry) error { return os.ErrPermission }
+
+func (failSink) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	return nil, os.ErrPermission
+}
+
+func TestMultiQueryFailingSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ctx := context.Background()
+	home := &File{Path: filepath.Join(dir, "home.jsonl")}
+	home.Record(ctx, Entry{ID: "1", Time: time.Now(), Key: "k"})
+
+	entries, err := Multi{failSink{}, home}.Query(ctx, Filter{})
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Query with one failing sink = %v, %v, want 1 entry", entries, err)
+	}
+
+	_, err = Multi{failSink{}, failSink{}}.Query(ctx, Filter{})
+	if err == nil {
+		t.Errorf("Query with every sink failing returned no error")
+	}
+}
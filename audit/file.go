@@ -0,0 +1,67 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// File is a Sink that appends entries to a local JSONL file.
+type File struct {
+	Path string
+}
+
+// Record appends e as a single JSON line.
+func (s *File) Record(ctx context.Context, e Entry) error {
+	err := os.MkdirAll(filepath.Dir(s.Path), 0700)
+	if err != nil {
+		return err
+	}
+
+	w, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(w).Encode(e)
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	return w.Close()
+}
+
+// Query reads the file returning the entries that match f. A missing file
+// has no entries.
+func (s *File) Query(ctx context.Context, f Filter) ([]Entry, error) {
+	r, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		err = json.Unmarshal(scanner.Bytes(), &e)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	err = scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return filter(entries, f), nil
+}
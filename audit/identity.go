@@ -0,0 +1,87 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/user"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// tokenInfoURL resolves an access token to its email, tests replace it.
+var tokenInfoURL = "https://www.googleapis.com/oauth2/v3/tokeninfo"
+
+// client bounds the token info lookup made by every deploy.
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Identity returns the identity of the default Google credentials. Service
+// account keys provide their client email directly, user credentials are
+// resolved through the token info endpoint. When neither is available the
+// local user name is returned.
+func Identity(ctx context.Context) string {
+	creds, err := google.FindDefaultCredentials(ctx)
+	if err == nil {
+		var key struct {
+			ClientEmail string `json:"client_email"`
+		}
+		if json.Unmarshal(creds.JSON, &key) == nil && key.ClientEmail != "" {
+			return key.ClientEmail
+		}
+
+		email, err := tokenEmail(creds)
+		if err == nil && email != "" {
+			return email
+		}
+	}
+
+	u, err := user.Current()
+	if err == nil {
+		return u.Username
+	}
+
+	return os.Getenv("USER")
+}
+
+// Hostname returns the local host name or blank if it cannot be determined.
+func Hostname() string {
+	host, _ := os.Hostname()
+	return host
+}
+
+func tokenEmail(creds *google.Credentials) (string, error) {
+	token, err := creds.TokenSource.Token()
+	if err != nil {
+		return "", err
+	}
+
+	return tokenInfoEmail(token.AccessToken)
+}
+
+// tokenInfoEmail returns the email of accessToken, which is posted in the
+// body so it stays out of request logs.
+func tokenInfoEmail(accessToken string) (string, error) {
+	resp, err := client.PostForm(tokenInfoURL, url.Values{"access_token": {accessToken}})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("token info got status %v, want 2xx", resp.Status)
+	}
+
+	var info struct {
+		Email string `json:"email"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&info)
+	if err != nil {
+		return "", err
+	}
+
+	return info.Email, nil
+}
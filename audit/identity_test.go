@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTokenInfoEmail(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.RawQuery != "" {
+			t.Errorf("token info request %v %v, want a POST without a query", r.Method, r.URL)
+		}
+
+		switch r.PostFormValue("access_token") {
+		case "good":
+			w.Write([]byte(`{"email": "dev@example.com"}`))
+		default:
+			http.Error(w, `{"error": "invalid_token"}`, http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	u := tokenInfoURL
+	tokenInfoURL = srv.URL
+	defer func() { tokenInfoURL = u }()
+
+	email, err := tokenInfoEmail("good")
+	if err != nil || email != "dev@example.com" {
+		t.Errorf("tokenInfoEmail = %q, %v, want dev@example.com", email, err)
+	}
+
+	email, err = tokenInfoEmail("bad")
+	if err == nil {
+		t.Errorf("tokenInfoEmail of a bad token = %q, want an error", email)
+	}
+
+	if client.Timeout == 0 {
+		t.Errorf("token info client has no timeout")
+	}
+}
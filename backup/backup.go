@@ -0,0 +1,207 @@
+// Package backup stores snapshots of the project metadata taken before each
+// write in a local directory or an object store.
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fresh8/rollerderby/logger"
+)
+
+// nameFormat is the time layout of snapshot names, it sorts chronologically.
+const nameFormat = "20060102T150405.000Z"
+
+// Snapshot is the metadata of a project before a write.
+type Snapshot struct {
+	// Name is where the snapshot is stored, it is not part of the document.
+	Name string `json:"-"`
+
+	Project     string    `json:"project"`
+	Time        time.Time `json:"time"`
+	Fingerprint string    `json:"fingerprint"`
+	Operator    string    `json:"operator"`
+	Reason      string    `json:"reason"`
+	Items       []Item    `json:"items"`
+}
+
+// Item is a metadata key value.
+type Item struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Object describes a stored object.
+type Object struct {
+	Name    string
+	Size    int64
+	Updated time.Time
+}
+
+// Store is an object store such as a local directory or a Cloud Storage
+// bucket. Names are slash separated.
+type Store interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+
+	// List returns the objects whose name starts with prefix.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	Delete(ctx context.Context, name string) error
+}
+
+// Backups saves snapshots to a Store and removes old ones.
+type Backups struct {
+	Store Store
+
+	// Keep is the number of snapshots kept per project, 0 keeps every one.
+	Keep int
+
+	// MaxAge removes snapshots older than it, 0 keeps them regardless of age.
+	MaxAge time.Duration
+}
+
+// Save stores s as <project>/<time>.json returning the name, then removes
+// the snapshots of the project beyond the retention. A failure to remove old
+// snapshots is logged and does not fail the save.
+func (b *Backups) Save(ctx context.Context, s Snapshot) (string, error) {
+	if s.Project == "" {
+		return "", fmt.Errorf("Save project cannot be blank")
+	}
+	if s.Time.IsZero() {
+		s.Time = time.Now()
+	}
+	s.Time = s.Time.UTC()
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	name := path.Join(s.Project, s.Time.Format(nameFormat)+".json")
+	err = b.Store.Put(ctx, name, append(data, '\n'))
+	if err != nil {
+		return "", fmt.Errorf("Save %v: %v", name, err)
+	}
+
+	err = b.prune(ctx, s.Project, name, s.Time)
+	if err != nil {
+		logger.Warnf(ctx, "unable to remove old backups of %v: %v", s.Project, err)
+	}
+
+	return name, nil
+}
+
+// prune removes the snapshots of project beyond Keep or older than MaxAge,
+// never removing the snapshot just saved as current.
+func (b *Backups) prune(ctx context.Context, project, current string, now time.Time) error {
+	if b.Keep <= 0 && b.MaxAge <= 0 {
+		return nil
+	}
+
+	objects, err := b.objects(ctx, project)
+	if err != nil {
+		return err
+	}
+
+	for i, o := range objects {
+		if o.Name == current {
+			continue
+		}
+
+		expired := b.MaxAge > 0 && now.Sub(snapshotTime(o)) > b.MaxAge
+		if (b.Keep > 0 && i >= b.Keep) || expired {
+			logger.Verbosef(ctx, "removing backup %v", o.Name)
+			err = b.Store.Delete(ctx, o.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// objects returns the snapshots of project newest first.
+func (b *Backups) objects(ctx context.Context, project string) ([]Object, error) {
+	all, err := b.Store.List(ctx, project+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []Object
+	for _, o := range all {
+		rest := strings.TrimPrefix(o.Name, project+"/")
+		if strings.HasSuffix(rest, ".json") && !strings.Contains(rest, "/") {
+			objects = append(objects, o)
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name > objects[j].Name })
+
+	return objects, nil
+}
+
+// snapshotTime returns the time in the name of o or when it was stored if
+// the name is not a snapshot time.
+func snapshotTime(o Object) time.Time {
+	t, err := time.Parse(nameFormat, strings.TrimSuffix(path.Base(o.Name), ".json"))
+	if err != nil {
+		return o.Updated
+	}
+
+	return t
+}
+
+// List returns the snapshots of project newest first.
+func (b *Backups) List(ctx context.Context, project string) ([]Snapshot, error) {
+	if project == "" {
+		return nil, fmt.Errorf("List project cannot be blank")
+	}
+
+	objects, err := b.objects(ctx, project)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []Snapshot
+	for _, o := range objects {
+		s, err := b.Get(ctx, o.Name)
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+
+	return snapshots, nil
+}
+
+// Get returns the snapshot stored as name.
+func (b *Backups) Get(ctx context.Context, name string) (Snapshot, error) {
+	data, err := b.Store.Get(ctx, name)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	var s Snapshot
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("backup %v is not a snapshot: %v", name, err)
+	}
+	s.Name = name
+
+	return s, nil
+}
+
+// Print outputs snapshots as a table.
+func Print(snapshots []Snapshot) {
+	fmt.Printf("%-20.20s | %-25.25s | %-12.12s | %-4.4s | %-30.30s | %s\n", "time", "operator", "fingerprint", "keys", "reason", "name")
+	fmt.Printf("%s\n", strings.Repeat("=", 20+25+12+4+30+30+3*5))
+	for _, s := range snapshots {
+		fmt.Printf("%-20.20s | %-25.25s | %-12.12s | %-4d | %-30.30s | %s\n",
+			s.Time.Format(time.RFC3339), s.Operator, s.Fingerprint, len(s.Items), s.Reason, s.Name)
+	}
+}
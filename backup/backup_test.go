@@ -0,0 +1,218 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// stores returns a new empty Store of each kind by name.
+func stores(t *testing.T) map[string]func() Store {
+	return map[string]func() Store{
+		"Dir": func() Store { return &Dir{Path: t.TempDir()} },
+		"GCS": func() Store { return newFakeGCS(t).store(t, "rollerderby/") },
+	}
+}
+
+var start = time.Date(2018, 8, 16, 20, 12, 19, 0, time.UTC)
+
+// save stores a snapshot of project at start plus minutes.
+func save(t *testing.T, b *Backups, project string, minutes int) string {
+	name, err := b.Save(context.Background(), Snapshot{
+		Project: project,
+		Time:    start.Add(time.Duration(minutes) * time.Minute),
+		Reason:  fmt.Sprint(minutes),
+		Items:   []Item{{Key: "app_version", Value: fmt.Sprintf("1.0.%d", minutes)}},
+	})
+	if err != nil {
+		t.Fatalf("Save %v at %d: %v", project, minutes, err)
+	}
+
+	return name
+}
+
+// reasons returns the reason of each snapshot of project in List order.
+func reasons(t *testing.T, b *Backups, project string) []string {
+	snapshots, err := b.List(context.Background(), project)
+	if err != nil {
+		t.Fatalf("List %v: %v", project, err)
+	}
+
+	var result []string
+	for _, s := range snapshots {
+		result = append(result, s.Reason)
+	}
+
+	return result
+}
+
+func TestSaveGet(t *testing.T) {
+	for kind, newStore := range stores(t) {
+		b := &Backups{Store: newStore()}
+		name := save(t, b, "project-a", 0)
+		if name != "project-a/20180816T201219.000Z.json" {
+			t.Errorf("%v Save name = %v", kind, name)
+		}
+
+		s, err := b.Get(context.Background(), name)
+		if err != nil {
+			t.Fatalf("%v Get: %v", kind, err)
+		}
+		want := Snapshot{
+			Name:    name,
+			Project: "project-a",
+			Time:    start,
+			Reason:  "0",
+			Items:   []Item{{Key: "app_version", Value: "1.0.0"}},
+		}
+		if !reflect.DeepEqual(s, want) {
+			t.Errorf("%v Get = %+v, want %+v", kind, s, want)
+		}
+
+		_, err = b.Get(context.Background(), "project-a/missing.json")
+		if err == nil {
+			t.Errorf("%v Get missing error = nil, want an error", kind)
+		}
+	}
+}
+
+func TestListOrder(t *testing.T) {
+	for kind, newStore := range stores(t) {
+		b := &Backups{Store: newStore()}
+		for _, minutes := range []int{5, 1, 3, 2, 4} {
+			save(t, b, "project-a", minutes)
+		}
+		save(t, b, "project-a-staging", 6)
+
+		// only snapshots directly below the project are listed.
+		ctx := context.Background()
+		b.Store.Put(ctx, "project-a/notes.txt", []byte("notes"))
+		b.Store.Put(ctx, "project-a/old/20180816T201219.000Z.json", []byte("{}"))
+
+		got := reasons(t, b, "project-a")
+		want := []string{"5", "4", "3", "2", "1"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%v List = %v, want %v newest first", kind, got, want)
+		}
+
+		if got := reasons(t, b, "project-b"); len(got) != 0 {
+			t.Errorf("%v List of an empty project = %v, want none", kind, got)
+		}
+	}
+}
+
+func TestKeep(t *testing.T) {
+	for kind, newStore := range stores(t) {
+		b := &Backups{Store: newStore(), Keep: 2}
+		for minutes := 0; minutes < 3; minutes++ {
+			save(t, b, "project-b", minutes)
+		}
+		for minutes := 0; minutes < 5; minutes++ {
+			save(t, b, "project-a", minutes)
+		}
+
+		if got, want := reasons(t, b, "project-a"), []string{"4", "3"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v kept %v of project-a, want %v", kind, got, want)
+		}
+		if got, want := reasons(t, b, "project-b"), []string{"2", "1"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v kept %v of project-b, want %v", kind, got, want)
+		}
+
+		// a snapshot saved with an older time than the others is kept.
+		save(t, b, "project-a", -1)
+		if got, want := reasons(t, b, "project-a"), []string{"4", "3", "-1"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v kept %v of project-a, want %v", kind, got, want)
+		}
+	}
+}
+
+func TestMaxAge(t *testing.T) {
+	for kind, newStore := range stores(t) {
+		b := &Backups{Store: newStore()}
+		for minutes := 0; minutes < 3; minutes++ {
+			save(t, b, "project-a", minutes)
+		}
+
+		b.MaxAge = 99 * time.Minute
+		save(t, b, "project-a", 100)
+
+		if got, want := reasons(t, b, "project-a"), []string{"100", "2", "1"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v kept %v, want %v", kind, got, want)
+		}
+	}
+}
+
+// failDelete is a Store that cannot delete.
+type failDelete struct {
+	Store
+}
+
+func (failDelete) Delete(ctx context.Context, name string) error {
+	return fmt.Errorf("permission denied")
+}
+
+func TestPruneError(t *testing.T) {
+	for kind, newStore := range stores(t) {
+		b := &Backups{Store: failDelete{newStore()}, Keep: 1}
+		save(t, b, "project-a", 0)
+
+		name, err := b.Save(context.Background(), Snapshot{Project: "project-a", Time: start.Add(time.Minute)})
+		if err != nil {
+			t.Errorf("%v Save error = %v, want a failed prune ignored", kind, err)
+		}
+		if name != "project-a/20180816T201319.000Z.json" {
+			t.Errorf("%v Save name = %q after a failed prune", kind, name)
+		}
+		if got, want := reasons(t, b, "project-a"), []string{"", "0"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("%v kept %v, want %v", kind, got, want)
+		}
+	}
+}
+
+func TestSaveBlankProject(t *testing.T) {
+	b := &Backups{Store: &Dir{Path: t.TempDir()}}
+	_, err := b.Save(context.Background(), Snapshot{})
+	if err == nil {
+		t.Errorf("Save error = nil, want an error for a blank project")
+	}
+}
+
+func TestDir(t *testing.T) {
+	d := &Dir{Path: filepath.Join(t.TempDir(), "backups")}
+	ctx := context.Background()
+
+	objects, err := d.List(ctx, "")
+	if err != nil || len(objects) != 0 {
+		t.Errorf("List of a missing directory = %v %v, want none", objects, err)
+	}
+
+	err = d.Put(ctx, "project-a/snapshot.json", []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(filepath.Join(d.Path, "project-a", "snapshot.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("snapshot mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	objects, err = d.List(ctx, "project-a/")
+	if err != nil || len(objects) != 1 || objects[0].Name != "project-a/snapshot.json" || objects[0].Size != 2 {
+		t.Errorf("List = %+v %v, want project-a/snapshot.json", objects, err)
+	}
+
+	err = d.Delete(ctx, "project-a/snapshot.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get(ctx, "project-a/snapshot.json"); !os.IsNotExist(err) {
+		t.Errorf("Get after Delete error = %v, want not exist", err)
+	}
+}
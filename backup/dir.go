@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Dir is a Store of files below a local directory. Snapshots hold every
+// metadata value so the files are only readable by the owner.
+type Dir struct {
+	Path string
+}
+
+func (d *Dir) path(name string) string {
+	return filepath.Join(d.Path, filepath.FromSlash(name))
+}
+
+// Put implements Store.
+func (d *Dir) Put(ctx context.Context, name string, data []byte) error {
+	p := d.path(name)
+	err := os.MkdirAll(filepath.Dir(p), 0700)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(p, data, 0600)
+}
+
+// Get implements Store.
+func (d *Dir) Get(ctx context.Context, name string) ([]byte, error) {
+	return ioutil.ReadFile(d.path(name))
+}
+
+// List implements Store, a missing directory has no objects.
+func (d *Dir) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.Walk(d.Path, func(p string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(d.Path, p)
+		if err != nil {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			objects = append(objects, Object{Name: name, Size: info.Size(), Updated: info.ModTime()})
+		}
+
+		return nil
+	})
+
+	return objects, err
+}
+
+// Delete implements Store.
+func (d *Dir) Delete(ctx context.Context, name string) error {
+	return os.Remove(d.path(name))
+}
@@ -0,0 +1,175 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2/google"
+)
+
+// StorageEndpoint when set replaces the Cloud Storage base URL, for example
+// with a local fake, and requests are sent without Google credentials. It
+// defaults to the ROLLERDERBY_STORAGE_ENDPOINT environment variable.
+var StorageEndpoint = os.Getenv("ROLLERDERBY_STORAGE_ENDPOINT")
+
+const (
+	defaultStorageEndpoint = "https://storage.googleapis.com"
+	storageScope           = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// GCS is a Store of objects in a Cloud Storage bucket using the JSON API.
+type GCS struct {
+	Bucket string
+
+	// Prefix is prepended to every object name, e.g. rollerderby/.
+	Prefix string
+
+	client *http.Client
+}
+
+// NewGCS returns a Store for bucket using the default credentials, or no
+// credentials when StorageEndpoint is set.
+func NewGCS(bucket, prefix string) (*GCS, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("NewGCS bucket cannot be blank")
+	}
+
+	client := http.DefaultClient
+	if StorageEndpoint == "" {
+		var err error
+		client, err = google.DefaultClient(context.Background(), storageScope)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &GCS{Bucket: bucket, Prefix: prefix, client: client}, nil
+}
+
+func (g *GCS) endpoint() string {
+	if StorageEndpoint != "" {
+		return strings.TrimRight(StorageEndpoint, "/")
+	}
+
+	return defaultStorageEndpoint
+}
+
+// objectURL returns the URL of the object name below the API path.
+func (g *GCS) objectURL(name string) string {
+	return fmt.Sprintf("%v/storage/v1/b/%v/o/%v", g.endpoint(), url.PathEscape(g.Bucket), url.PathEscape(g.Prefix+name))
+}
+
+// do sends req returning the response body, or an error for any status
+// other than 2xx.
+func (g *GCS) do(ctx context.Context, req *http.Request) ([]byte, error) {
+	res, err := g.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 64<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("%v %v: %v %s", req.Method, req.URL.Path, res.Status, bytes.TrimSpace(body))
+	}
+
+	return body, nil
+}
+
+// Put implements Store.
+func (g *GCS) Put(ctx context.Context, name string, data []byte) error {
+	u := fmt.Sprintf("%v/upload/storage/v1/b/%v/o?uploadType=media&name=%v", g.endpoint(), url.PathEscape(g.Bucket), url.QueryEscape(g.Prefix+name))
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err = g.do(ctx, req)
+	return err
+}
+
+// Get implements Store.
+func (g *GCS) Get(ctx context.Context, name string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, g.objectURL(name)+"?alt=media", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return g.do(ctx, req)
+}
+
+// List implements Store following every page of results.
+func (g *GCS) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	pageToken := ""
+	for {
+		q := url.Values{"prefix": {g.Prefix + prefix}}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+
+		u := fmt.Sprintf("%v/storage/v1/b/%v/o?%v", g.endpoint(), url.PathEscape(g.Bucket), q.Encode())
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := g.do(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+
+		var page struct {
+			Items []struct {
+				Name    string    `json:"name"`
+				Size    string    `json:"size"`
+				Updated time.Time `json:"updated"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.Unmarshal(body, &page)
+		if err != nil {
+			return nil, fmt.Errorf("List %v: %v", g.Bucket, err)
+		}
+
+		for _, item := range page.Items {
+			size, _ := strconv.ParseInt(item.Size, 10, 64)
+			objects = append(objects, Object{
+				Name:    strings.TrimPrefix(item.Name, g.Prefix),
+				Size:    size,
+				Updated: item.Updated,
+			})
+		}
+
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// Delete implements Store.
+func (g *GCS) Delete(ctx context.Context, name string) error {
+	req, err := http.NewRequest(http.MethodDelete, g.objectURL(name), nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = g.do(ctx, req)
+	return err
+}
@@ -0,0 +1,169 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGCS serves the Cloud Storage JSON API calls of GCS from memory for the
+// bucket bk, listing two objects per page.
+type fakeGCS struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	url     string
+}
+
+// newFakeGCS starts a fakeGCS closed when the test ends.
+func newFakeGCS(t *testing.T) *fakeGCS {
+	f := &fakeGCS{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	f.url = srv.URL
+
+	return f
+}
+
+// store returns a GCS store of the fake bucket below prefix, sending
+// requests to the fake until the test ends.
+func (f *fakeGCS) store(t *testing.T, prefix string) *GCS {
+	endpoint := StorageEndpoint
+	StorageEndpoint = f.url
+	t.Cleanup(func() { StorageEndpoint = endpoint })
+
+	g, err := NewGCS("bk", prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return g
+}
+
+func (f *fakeGCS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/upload/storage/v1/b/bk/o":
+		data, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[r.URL.Query().Get("name")] = data
+		json.NewEncoder(w).Encode(map[string]string{"name": r.URL.Query().Get("name")})
+	case r.Method == http.MethodGet && r.URL.Path == "/storage/v1/b/bk/o":
+		f.list(w, r)
+	case strings.HasPrefix(r.URL.Path, "/storage/v1/b/bk/o/"):
+		name := strings.TrimPrefix(r.URL.Path, "/storage/v1/b/bk/o/")
+		data, ok := f.objects[name]
+		if !ok {
+			http.Error(w, `{"error":{"code":404,"message":"No such object"}}`, http.StatusNotFound)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("alt") == "media":
+			w.Write(data)
+		case r.Method == http.MethodDelete:
+			delete(f.objects, name)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, r.Method, http.StatusMethodNotAllowed)
+		}
+	default:
+		http.Error(w, "unknown path "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+func (f *fakeGCS) list(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	for name := range f.objects {
+		if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	from, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+	to := from + 2
+	page := map[string]interface{}{}
+	if to < len(names) {
+		page["nextPageToken"] = strconv.Itoa(to)
+	} else {
+		to = len(names)
+	}
+
+	var items []map[string]interface{}
+	for _, name := range names[from:to] {
+		items = append(items, map[string]interface{}{
+			"name":    name,
+			"size":    strconv.Itoa(len(f.objects[name])),
+			"updated": time.Now().UTC(),
+		})
+	}
+	page["items"] = items
+
+	json.NewEncoder(w).Encode(page)
+}
+
+func TestGCS(t *testing.T) {
+	f := newFakeGCS(t)
+	g := f.store(t, "rollerderby/")
+	ctx := context.Background()
+
+	for _, name := range []string{"project-a/1.json", "project-a/2.json", "project-a/3.json", "project-b/1.json"} {
+		err := g.Put(ctx, name, []byte(name))
+		if err != nil {
+			t.Fatalf("Put %v: %v", name, err)
+		}
+	}
+
+	if _, ok := f.objects["rollerderby/project-a/1.json"]; !ok {
+		t.Errorf("stored %v, want the names below the prefix", f.objects)
+	}
+
+	objects, err := g.List(ctx, "project-a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+	if want := []string{"project-a/1.json", "project-a/2.json", "project-a/3.json"}; strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("List = %v, want %v across pages", names, want)
+	}
+	if objects[0].Size != int64(len("project-a/1.json")) {
+		t.Errorf("List size = %v, want %v", objects[0].Size, len("project-a/1.json"))
+	}
+
+	data, err := g.Get(ctx, "project-a/2.json")
+	if err != nil || string(data) != "project-a/2.json" {
+		t.Errorf("Get = %q %v, want project-a/2.json", data, err)
+	}
+
+	err = g.Delete(ctx, "project-a/2.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = g.Get(ctx, "project-a/2.json")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Get after Delete error = %v, want a 404", err)
+	}
+}
+
+func TestNewGCSBlankBucket(t *testing.T) {
+	_, err := NewGCS("", "")
+	if err == nil {
+		t.Errorf("NewGCS error = nil, want an error for a blank bucket")
+	}
+}
@@ -0,0 +1,129 @@
+package compute
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/fresh8/rollerderby/audit"
+	"google.golang.org/api/compute/v1"
+)
+
+// MetadataAudit is an audit.Sink that keeps the most recent entries as a JSON
+// array in a common metadata key of the project.
+type MetadataAudit struct {
+	ProjectID string
+	Key       string
+	Size      int
+}
+
+// Record appends e to the ring dropping the oldest entries beyond Size.
+func (m *MetadataAudit) Record(ctx context.Context, e audit.Entry) error {
+	computeService, err := v1ComputeClient()
+	if err != nil {
+		return err
+	}
+
+	defer lockProject(m.ProjectID)()
+	project, err := getProject(ctx, computeService, m.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	entries, err := m.decode(project.CommonInstanceMetadata)
+	if err != nil {
+		return err
+	}
+
+	entries = append(entries, e)
+	if m.Size > 0 && len(entries) > m.Size {
+		entries = entries[len(entries)-m.Size:]
+	}
+
+	// large values such as scripts can exceed the metadata limits before the
+	// ring is full, the oldest entries are dropped until it fits.
+	meta := project.CommonInstanceMetadata
+	var value string
+	for {
+		b, err := json.Marshal(entries)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+
+		err = checkLimits(meta, Change{Key: m.Key, NewValue: value})
+		if err == nil {
+			break
+		}
+		if len(entries) == 1 {
+			return fmt.Errorf("MetadataAudit.Record %v", err)
+		}
+		entries = entries[1:]
+	}
+
+	item := findItem(meta, m.Key)
+	if item == nil {
+		item = &compute.MetadataItems{Key: m.Key}
+		meta.Items = append(meta.Items, item)
+	}
+	item.Value = &value
+
+	err = setCommonInstanceMetadata(ctx, computeService, project.Name, meta)
+	if err != nil {
+		return fmt.Errorf("MetadataAudit.Record %v", err)
+	}
+
+	return nil
+}
+
+// Query returns the entries in the ring that match f.
+func (m *MetadataAudit) Query(ctx context.Context, f audit.Filter) ([]audit.Entry, error) {
+	computeService, err := v1ComputeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	project, err := getProject(ctx, computeService, m.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := m.decode(project.CommonInstanceMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []audit.Entry
+	for _, e := range entries {
+		if f.Match(e) {
+			result = append(result, e)
+		}
+	}
+
+	return result, nil
+}
+
+func (m *MetadataAudit) decode(meta *compute.Metadata) ([]audit.Entry, error) {
+	item := findItem(meta, m.Key)
+	if item == nil || item.Value == nil || *item.Value == "" {
+		return nil, nil
+	}
+
+	var entries []audit.Entry
+	err := json.Unmarshal([]byte(*item.Value), &entries)
+	if err != nil {
+		return nil, fmt.Errorf("MetadataAudit key %v is not an audit ring: %v", m.Key, err)
+	}
+
+	return entries, nil
+}
+
+func findItem(meta *compute.Metadata, key string) *compute.MetadataItems {
+	for _, item := range meta.Items {
+		if item.Key == key {
+			return item
+		}
+	}
+
+	return nil
+}
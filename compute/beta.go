@@ -3,15 +3,17 @@ package compute
 import (
 	"context"
 	"fmt"
-	"log"
 	"time"
 
-	"golang.org/x/oauth2/google"
+	"github.com/fresh8/rollerderby/errors"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/tracing"
 	"google.golang.org/api/compute/v0.beta"
 )
 
-// RollingReplace replaces all of the instances rolling style.
-func RollingReplace(projectID, zone, groupName string, minReadySec int64) error {
+// RollingReplace replaces all of the instances rolling style with a new
+// version called versionName.
+func RollingReplace(ctx context.Context, projectID, zone, groupName, versionName string, minReadySec int64) error {
 	computeService, err := betaComputeClient()
 	if err != nil {
 		return err
@@ -19,14 +21,14 @@ func RollingReplace(projectID, zone, groupName string, minReadySec int64) error
 
 	igms := computeService.InstanceGroupManagers
 
-	policy, err := igms.Get(projectID, zone, groupName).Do()
+	policy, err := getGroup(ctx, igms, projectID, zone, groupName)
 	if err != nil {
 		return err
 	}
 
 	// force a rolling replace by bumping the version.
 	nextVer := &compute.InstanceGroupManagerVersion{
-		Name:             fmt.Sprintf("0-%v", time.Now().Unix()),
+		Name:             versionName,
 		InstanceTemplate: policy.InstanceTemplate,
 	}
 
@@ -34,25 +36,69 @@ func RollingReplace(projectID, zone, groupName string, minReadySec int64) error
 	policy.UpdatePolicy.MinimalAction = "REPLACE"
 	policy.UpdatePolicy.MinReadySec = minReadySec
 
-	op, err := igms.Patch(projectID, zone, groupName, policy).Do()
+	patchCtx, span := apiSpan(ctx, "instanceGroupManagers.patch", "project", projectID, "zone", zone, "group", groupName, "version", versionName)
+	op, err := igms.Patch(projectID, zone, groupName, policy).Context(patchCtx).Do()
+	span.Finish(err)
 	if err != nil {
 		return err
 	}
 
+	err = waitZoneOperation(ctx, computeService, projectID, zone, op)
+	if err != nil {
+		return fmt.Errorf("RollingReplace igms.Patch %v", err)
+	}
+
+	logger.Infof(ctx, "replacing group %v", groupName)
+	return nil
+}
+
+func getGroup(ctx context.Context, igms *compute.InstanceGroupManagersService, projectID, zone, groupName string) (*compute.InstanceGroupManager, error) {
+	ctx, span := apiSpan(ctx, "instanceGroupManagers.get", "project", projectID, "zone", zone, "group", groupName)
+	group, err := igms.Get(projectID, zone, groupName).Context(ctx).Do()
+	span.Finish(err)
+	return group, err
+}
+
+// waitZoneOperation polls op until it is done returning its error if any.
+func waitZoneOperation(ctx context.Context, computeService *compute.Service, projectID, zone string, op *compute.Operation) error {
+	ctx, span := tracing.Start(ctx, "compute.operations.wait", "project", projectID, "zone", zone, "operation", op.Name)
+	err := pollZoneOperation(ctx, computeService, projectID, zone, op)
+	span.Finish(err)
+	return err
+}
+
+func pollZoneOperation(ctx context.Context, computeService *compute.Service, projectID, zone string, op *compute.Operation) error {
+	deadline := time.Now().Add(operationTimeout)
+	for op.Status != "DONE" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("operation %v not done after %v", op.Name, operationTimeout)
+		}
+		err := sleep(ctx, operationPoll)
+		if err != nil {
+			return err
+		}
+
+		pollCtx, span := apiSpan(ctx, "zoneOperations.get", "operation", op.Name)
+		op, err = computeService.ZoneOperations.Get(projectID, zone, op.Name).Context(pollCtx).Do()
+		span.Finish(err)
+		if err != nil {
+			return err
+		}
+	}
+
 	if op.Error != nil {
+		var errs errors.Errors
 		for _, e := range op.Error.Errors {
-			log.Println(e.Code, e.Message, e.Location)
+			errs = append(errs, fmt.Errorf("%v %v %v", e.Code, e.Message, e.Location))
 		}
-		return fmt.Errorf("RollingReplace igms.Patch got op.Error != nil, want nil")
+		return fmt.Errorf("operation %v: %v", op.Name, errs)
 	}
 
-	log.Printf("replacing group %v", groupName)
 	return nil
 }
 
 func betaComputeClient() (*compute.Service, error) {
-	ctx := context.Background()
-	client, err := google.DefaultClient(ctx, compute.ComputeScope)
+	client, err := httpClient()
 	if err != nil {
 		return nil, err
 	}
@@ -61,5 +107,10 @@ func betaComputeClient() (*compute.Service, error) {
 		return nil, err
 	}
 
+	path := basePath("beta")
+	if path != "" {
+		service.BasePath = path
+	}
+
 	return service, nil
 }
@@ -0,0 +1,53 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fresh8/rollerderby/semver"
+)
+
+// BumpKey increments part of the semantic version held in key, see
+// semver.Increment, and writes it back in a single read and write so a
+// concurrent change fails the fingerprint check. The previous and new values
+// are returned.
+func BumpKey(ctx context.Context, projectID, key, part, preID string, opts WriteOptions) (string, string, error) {
+	if projectID == "" {
+		return "", "", fmt.Errorf("BumpKey projectID cannot be blank")
+	}
+
+	if key == "" {
+		return "", "", fmt.Errorf("BumpKey key cannot be blank")
+	}
+
+	computeService, err := v1ComputeClient()
+	if err != nil {
+		return "", "", err
+	}
+
+	defer lockProject(projectID)()
+	project, err := getProject(ctx, computeService, projectID)
+	if err != nil {
+		return "", "", err
+	}
+
+	item := findItem(project.CommonInstanceMetadata, key)
+	if item == nil || item.Value == nil {
+		return "", "", fmt.Errorf("BumpKey %v has no value in %v", key, projectID)
+	}
+
+	v, err := semver.Parse(*item.Value)
+	if err != nil {
+		return *item.Value, "", fmt.Errorf("BumpKey %v", err)
+	}
+
+	next, err := semver.Increment(v, part, preID)
+	if err != nil {
+		return *item.Value, "", err
+	}
+
+	newValue := next.String()
+	oldValue, err := writeKey(ctx, computeService, project, key, newValue, opts)
+
+	return oldValue, newValue, err
+}
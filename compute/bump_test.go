@@ -0,0 +1,64 @@
+package compute
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fresh8/rollerderby/backup"
+	"github.com/fresh8/rollerderby/semver"
+)
+
+func TestBumpKey(t *testing.T) {
+	tests := []struct {
+		value string
+		part  string
+		preID string
+		want  string
+		err   bool
+	}{
+		{value: "1.2.3", part: semver.BumpMajor, want: "2.0.0"},
+		{value: "1.2.3", part: semver.BumpMinor, want: "1.3.0"},
+		{value: "1.2.3", part: semver.BumpPatch, want: "1.2.4"},
+		{value: "v1.2.3", part: semver.BumpPatch, want: "v1.2.4"},
+		{value: "1.2.4-rc.1", part: semver.BumpPrerelease, want: "1.2.4-rc.2"},
+		{value: "v1.2.3", part: semver.BumpPrerelease, preID: "rc", want: "v1.2.4-rc.0"},
+		{value: "latest", part: semver.BumpPatch, err: true},
+		{value: "1.2", part: semver.BumpMinor, err: true},
+		{value: "1.2.3", part: "build", err: true},
+	}
+
+	for _, tt := range tests {
+		f := newFakeCompute(t, map[string]map[string]string{"p": {"app_version": tt.value}})
+		opts := WriteOptions{Backups: &backup.Backups{Store: &backup.Dir{Path: t.TempDir()}}}
+
+		oldValue, newValue, err := BumpKey(context.Background(), "p", "app_version", tt.part, tt.preID, opts)
+		if (err != nil) != tt.err {
+			t.Errorf("BumpKey(%v, %v) error %v, want error %v", tt.value, tt.part, err, tt.err)
+			continue
+		}
+
+		want := tt.want
+		if err != nil {
+			want = tt.value
+		}
+		if got := f.value("p", "app_version"); got != want {
+			t.Errorf("BumpKey(%v, %v) wrote %q, want %q", tt.value, tt.part, got, want)
+		}
+		if err != nil {
+			continue
+		}
+
+		if oldValue != tt.value || newValue != tt.want {
+			t.Errorf("BumpKey(%v, %v) = %q, %q, want %q, %q", tt.value, tt.part, oldValue, newValue, tt.value, tt.want)
+		}
+	}
+}
+
+func TestBumpKeyMissing(t *testing.T) {
+	newFakeCompute(t, map[string]map[string]string{"p": {}})
+
+	_, _, err := BumpKey(context.Background(), "p", "app_version", semver.BumpPatch, "", WriteOptions{})
+	if err == nil {
+		t.Errorf("BumpKey of a missing key succeeded")
+	}
+}
@@ -0,0 +1,36 @@
+package compute
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+)
+
+// Endpoint when set replaces the Compute API base URL, for example with a
+// local fake backend, and requests are sent without Google credentials. It
+// defaults to the ROLLERDERBY_COMPUTE_ENDPOINT environment variable.
+var Endpoint = os.Getenv("ROLLERDERBY_COMPUTE_ENDPOINT")
+
+// computeScope is the same for every API version.
+const computeScope = "https://www.googleapis.com/auth/compute"
+
+func httpClient() (*http.Client, error) {
+	if Endpoint != "" {
+		return http.DefaultClient, nil
+	}
+
+	return google.DefaultClient(context.Background(), computeScope)
+}
+
+// basePath returns the base path for version under Endpoint or blank when
+// the default should be used.
+func basePath(version string) string {
+	if Endpoint == "" {
+		return ""
+	}
+
+	return strings.TrimRight(Endpoint, "/") + "/compute/" + version + "/projects/"
+}
@@ -0,0 +1,209 @@
+package compute
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	v1 "google.golang.org/api/compute/v1"
+)
+
+// fakeCompute serves the project metadata calls of the Compute API from
+// memory, and the managed instances and backend health of a single group.
+type fakeCompute struct {
+	mu           sync.Mutex
+	metadata     map[string]map[string]string
+	fingerprints map[string]int
+
+	// instances are the names of the managed instances of every group, all
+	// running version.
+	instances []string
+	version   string
+
+	// backend is set when a backend service uses the group.
+	backend bool
+
+	// health holds the health state of each instance for each getHealth
+	// call in order, the last is repeated.
+	health      [][]string
+	healthCalls int
+}
+
+// newFakeCompute serves metadata, a project name to its keys, and points
+// Endpoint at the fake until the test ends.
+func newFakeCompute(t *testing.T, metadata map[string]map[string]string) *fakeCompute {
+	f := &fakeCompute{metadata: metadata, fingerprints: make(map[string]int)}
+
+	srv := httptest.NewServer(f)
+	endpoint := Endpoint
+	Endpoint = srv.URL
+	t.Cleanup(func() {
+		Endpoint = endpoint
+		srv.Close()
+	})
+
+	return f
+}
+
+func (f *fakeCompute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// /compute/{version}/projects/{project}/{rest}
+	parts := strings.SplitN(r.URL.Path, "/", 6)
+	if len(parts) < 5 || parts[1] != "compute" || parts[3] != "projects" {
+		f.error(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+		return
+	}
+	project, rest := parts[4], ""
+	if len(parts) == 6 {
+		rest = parts[5]
+	}
+
+	f.mu.Lock()
+	_, ok := f.metadata[project]
+	f.mu.Unlock()
+	if !ok {
+		f.error(w, http.StatusNotFound, "project "+project+" not found")
+		return
+	}
+
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		f.getProject(w, project)
+	case rest == "setCommonInstanceMetadata" && r.Method == http.MethodPost:
+		f.setMetadata(w, r, project)
+	case strings.HasPrefix(rest, "global/operations/"):
+		f.writeJSON(w, map[string]string{"name": "op", "status": "DONE"})
+	case strings.Contains(rest, "/instanceGroupManagers/"):
+		f.group(w, r, project, rest)
+	case rest == "aggregated/backendServices":
+		f.backendServices(w, project)
+	case strings.HasPrefix(rest, "global/backendServices/") && strings.HasSuffix(rest, "/getHealth"):
+		f.getHealth(w, project)
+	default:
+		f.error(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+	}
+}
+
+func (f *fakeCompute) getProject(w http.ResponseWriter, project string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	meta := &v1.Metadata{Fingerprint: fmt.Sprint(f.fingerprints[project])}
+	for k, v := range f.metadata[project] {
+		v := v
+		meta.Items = append(meta.Items, &v1.MetadataItems{Key: k, Value: &v})
+	}
+	sort.Slice(meta.Items, func(i, j int) bool { return meta.Items[i].Key < meta.Items[j].Key })
+
+	f.writeJSON(w, v1.Project{Name: project, CommonInstanceMetadata: meta})
+}
+
+func (f *fakeCompute) setMetadata(w http.ResponseWriter, r *http.Request, project string) {
+	var meta v1.Metadata
+	err := json.NewDecoder(r.Body).Decode(&meta)
+	if err != nil {
+		f.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if meta.Fingerprint != fmt.Sprint(f.fingerprints[project]) {
+		f.error(w, http.StatusPreconditionFailed, "Supplied fingerprint does not match current metadata fingerprint.")
+		return
+	}
+
+	values := make(map[string]string)
+	for _, item := range meta.Items {
+		if item.Value != nil {
+			values[item.Key] = *item.Value
+		}
+	}
+	f.metadata[project] = values
+	f.fingerprints[project]++
+
+	f.writeJSON(w, v1.Operation{Name: "op", Status: "DONE"})
+}
+
+// instanceGroup returns the URL of the instance group of the group manager.
+func instanceGroup(project, zone, group string) string {
+	return "https://www.googleapis.com/compute/v1/projects/" + project + "/zones/" + zone + "/instanceGroups/" + group
+}
+
+func (f *fakeCompute) group(w http.ResponseWriter, r *http.Request, project, rest string) {
+	// zones/{zone}/instanceGroupManagers/{group}[/listManagedInstances]
+	parts := strings.Split(rest, "/")
+	zone, group := parts[1], parts[3]
+
+	switch {
+	case len(parts) == 4 && r.Method == http.MethodGet:
+		f.writeJSON(w, map[string]string{"name": group, "zone": zone, "instanceGroup": instanceGroup(project, zone, group)})
+	case len(parts) == 5 && parts[4] == "listManagedInstances":
+		var instances []map[string]interface{}
+		for _, name := range f.instances {
+			instances = append(instances, map[string]interface{}{
+				"instance":      "https://www.googleapis.com/compute/v1/projects/" + project + "/zones/" + zone + "/instances/" + name,
+				"currentAction": "NONE",
+				"version":       map[string]string{"name": f.version},
+			})
+		}
+		f.writeJSON(w, map[string]interface{}{"managedInstances": instances})
+	default:
+		f.error(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+	}
+}
+
+func (f *fakeCompute) backendServices(w http.ResponseWriter, project string) {
+	var services []map[string]interface{}
+	if f.backend {
+		services = append(services, map[string]interface{}{
+			"name":     "web",
+			"backends": []map[string]string{{"group": instanceGroup(project, "z1", "app")}},
+		})
+	}
+
+	f.writeJSON(w, map[string]interface{}{"items": map[string]interface{}{"global": map[string]interface{}{"backendServices": services}}})
+}
+
+func (f *fakeCompute) getHealth(w http.ResponseWriter, project string) {
+	f.mu.Lock()
+	states := f.health[len(f.health)-1]
+	if f.healthCalls < len(f.health) {
+		states = f.health[f.healthCalls]
+	}
+	f.healthCalls++
+	f.mu.Unlock()
+
+	var health []map[string]string
+	for i, name := range f.instances {
+		health = append(health, map[string]string{
+			"instance":    "https://www.googleapis.com/compute/v1/projects/" + project + "/zones/z1/instances/" + name,
+			"healthState": states[i],
+		})
+	}
+	f.writeJSON(w, map[string]interface{}{"healthStatus": health})
+}
+
+// value returns the current value of key in project.
+func (f *fakeCompute) value(project, key string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.metadata[project][key]
+}
+
+func (f *fakeCompute) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeCompute) error(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": status, "message": msg}})
+}
@@ -0,0 +1,81 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/fresh8/rollerderby/logger"
+	"google.golang.org/api/compute/v0.beta"
+)
+
+// GroupStatus summarises an instance group for monitoring.
+type GroupStatus struct {
+	Zone       string
+	Name       string
+	TargetSize int64
+
+	// Versions counts the managed instances on each version name.
+	Versions map[string]int
+
+	// Actions counts the managed instances by their current action.
+	Actions map[string]int
+}
+
+// Fleet returns the status of every zonal instance group in projectID.
+func Fleet(ctx context.Context, projectID string) ([]GroupStatus, error) {
+	if projectID == "" {
+		return nil, fmt.Errorf("Fleet projectID cannot be blank")
+	}
+
+	computeService, err := betaComputeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	igms := computeService.InstanceGroupManagers
+
+	list, err := aggregatedList(ctx, igms, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []GroupStatus
+	for _, item := range list.Items {
+		for _, group := range item.InstanceGroupManagers {
+			if group.Zone == "" {
+				logger.Verbosef(ctx, "skipping regional group %v", group.Name)
+				continue
+			}
+
+			status, err := groupStatus(ctx, igms, projectID, group)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, status)
+		}
+	}
+
+	return result, nil
+}
+
+func groupStatus(ctx context.Context, igms *compute.InstanceGroupManagersService, projectID string, group *compute.InstanceGroupManager) (GroupStatus, error) {
+	status := GroupStatus{
+		Zone:       lastPart(group.Zone),
+		Name:       group.Name,
+		TargetSize: group.TargetSize,
+		Versions:   make(map[string]int),
+		Actions:    make(map[string]int),
+	}
+
+	instances, err := managedInstances(ctx, igms, projectID, status.Zone, group.Name)
+	if err != nil {
+		return status, err
+	}
+
+	for _, instance := range instances {
+		status.Versions[instanceVersion(instance)]++
+		status.Actions[instance.CurrentAction]++
+	}
+
+	return status, nil
+}
@@ -0,0 +1,232 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fresh8/rollerderby/logger"
+	"google.golang.org/api/compute/v0.beta"
+)
+
+// ListInstanceGroups prints the instance groups for the given projectID picked
+// by s along with the version each managed instance belongs to.
+func ListInstanceGroups(ctx context.Context, projectID string, s Selector) error {
+	if projectID == "" {
+		return fmt.Errorf("ListInstanceGroups projectID cannot be blank")
+	}
+
+	groups, err := SelectGroups(ctx, projectID, s)
+	if err != nil {
+		return err
+	}
+
+	computeService, err := betaComputeClient()
+	if err != nil {
+		return err
+	}
+
+	igms := computeService.InstanceGroupManagers
+
+	zone := ""
+	for _, group := range groups {
+		if group.Zone != zone {
+			zone = group.Zone
+			fmt.Println("zones/" + zone) // print zone
+		}
+
+		fmt.Println("    ", group.Name, group.Versions) // instance group
+		instances, err := ListManagedInstances(ctx, igms, projectID, group.Zone, group.Name)
+		if err != nil {
+			return err
+		}
+
+		for _, instance := range instances {
+			fmt.Println("        ", instance) // instances
+		}
+	}
+
+	return nil
+}
+
+// DescribeInstanceGroup prints the template, update policy, versions and
+// managed instances of a single instance group.
+func DescribeInstanceGroup(ctx context.Context, projectID, zone, groupName string) error {
+	if projectID == "" {
+		return fmt.Errorf("DescribeInstanceGroup projectID cannot be blank")
+	}
+
+	computeService, err := betaComputeClient()
+	if err != nil {
+		return err
+	}
+
+	igms := computeService.InstanceGroupManagers
+
+	group, err := getGroup(ctx, igms, projectID, zone, groupName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("group:", group.Name)
+	fmt.Println("zone:", lastPart(group.Zone))
+	fmt.Println("template:", lastPart(group.InstanceTemplate))
+	fmt.Println("target size:", group.TargetSize)
+	if group.UpdatePolicy != nil {
+		fmt.Printf("update policy: %v %v min ready %vs\n", group.UpdatePolicy.Type, group.UpdatePolicy.MinimalAction, group.UpdatePolicy.MinReadySec)
+	}
+
+	fmt.Println("versions:")
+	for _, v := range group.Versions {
+		fmt.Println("    ", v.Name, lastPart(v.InstanceTemplate))
+	}
+
+	instances, err := ListManagedInstances(ctx, igms, projectID, zone, groupName)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("instances:")
+	for _, instance := range instances {
+		fmt.Println("    ", instance)
+	}
+
+	return nil
+}
+
+// pollInterval is the delay between checks while waiting on a group.
+var pollInterval = 10 * time.Second
+
+// sleep waits for d returning early with the error of ctx when it is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// WaitForVersion polls the group until every managed instance runs version
+// with no current action, returning the paths of those instances. An error is
+// returned if the deadline passes first.
+func WaitForVersion(ctx context.Context, projectID, zone, groupName, version string, deadline time.Time) ([]string, error) {
+	computeService, err := betaComputeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	igms := computeService.InstanceGroupManagers
+
+	for {
+		instances, err := managedInstances(ctx, igms, projectID, zone, groupName)
+		if err != nil {
+			return nil, err
+		}
+
+		var ready []string
+		var pending []string
+		for _, instance := range instances {
+			path := instancePath(instance.Instance)
+			if instanceVersion(instance) == version && instance.CurrentAction == "NONE" {
+				ready = append(ready, path)
+				continue
+			}
+			pending = append(pending, fmt.Sprintf("%v (%v %v)", path, instanceVersion(instance), instance.CurrentAction))
+		}
+
+		if len(pending) == 0 {
+			return ready, nil
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("WaitForVersion group %v has %d instances not on version %v: %v", groupName, len(pending), version, strings.Join(pending, ", "))
+		}
+
+		logger.Verbosef(ctx, "waiting on %d of %d instances in %v", len(pending), len(instances), groupName)
+		err = sleep(ctx, pollInterval)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func aggregatedList(ctx context.Context, igms *compute.InstanceGroupManagersService, projectID string) (*compute.InstanceGroupManagerAggregatedList, error) {
+	ctx, span := apiSpan(ctx, "instanceGroupManagers.aggregatedList", "project", projectID)
+	list, err := igms.AggregatedList(projectID).Context(ctx).Do()
+	span.Finish(err)
+	return list, err
+}
+
+// ListManagedInstances lists the managed instances for the given projectID,
+// zone, and groupName with the version and current action of each.
+func ListManagedInstances(ctx context.Context, igms *compute.InstanceGroupManagersService, projectID, zone, groupName string) ([]string, error) {
+	instances, err := managedInstances(ctx, igms, projectID, zone, groupName)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+	for _, instance := range instances {
+		result = append(result, fmt.Sprintf("%v %v %v", instancePath(instance.Instance), instanceVersion(instance), instance.CurrentAction))
+	}
+
+	return result, nil
+}
+
+func managedInstances(ctx context.Context, igms *compute.InstanceGroupManagersService, projectID, zone, groupName string) ([]*compute.ManagedInstance, error) {
+	ctx, span := apiSpan(ctx, "instanceGroupManagers.listManagedInstances", "project", projectID, "zone", zone, "group", groupName)
+	list, err := igms.ListManagedInstances(projectID, zone, groupName).Context(ctx).Do()
+	span.Finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*compute.ManagedInstance
+	for _, instance := range list.ManagedInstances {
+		if instance == nil {
+			continue
+		}
+		result = append(result, instance)
+	}
+
+	return result, nil
+}
+
+func instanceVersion(instance *compute.ManagedInstance) string {
+	if instance.Version == nil {
+		return "<none>"
+	}
+
+	return instance.Version.Name
+}
+
+// instancePath returns the zones/<zone>/instances/<name> suffix of an
+// instance URL so URLs from different API versions can be compared.
+func instancePath(url string) string {
+	a := strings.Split(url, "/")
+	if len(a) < 4 {
+		return url
+	}
+
+	return strings.Join(a[len(a)-4:], "/")
+}
+
+func versionNames(group *compute.InstanceGroupManager) []string {
+	var names []string
+	for _, v := range group.Versions {
+		names = append(names, v.Name)
+	}
+
+	return names
+}
+
+// lastPart returns the final path segment of a resource URL.
+func lastPart(url string) string {
+	a := strings.Split(url, "/")
+	return a[len(a)-1]
+}
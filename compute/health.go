@@ -0,0 +1,169 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fresh8/rollerderby/logger"
+	"google.golang.org/api/compute/v1"
+)
+
+// UnhealthyError is returned when instances of a group do not report HEALTHY
+// on every backend service before the timeout.
+type UnhealthyError struct {
+	Group string
+
+	// Instances maps each unhealthy instance path to its health state.
+	Instances map[string]string
+}
+
+func (e *UnhealthyError) Error() string {
+	var names []string
+	for k := range e.Instances {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var s []string
+	for _, k := range names {
+		s = append(s, fmt.Sprintf("%v (%v)", k, e.Instances[k]))
+	}
+
+	return fmt.Sprintf("group %v has %d unhealthy instances: %v", e.Group, len(s), strings.Join(s, ", "))
+}
+
+// WaitHealthy waits for every instance of the group to run version and then
+// polls the health of the backend services that use the group until all of
+// those instances report HEALTHY. An *UnhealthyError is returned listing the
+// instances that are not healthy when timeout passes.
+func WaitHealthy(ctx context.Context, projectID, zone, groupName, version string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	instances, err := WaitForVersion(ctx, projectID, zone, groupName, version, deadline)
+	if err != nil {
+		return err
+	}
+
+	computeService, err := v1ComputeClient()
+	if err != nil {
+		return err
+	}
+
+	groupCtx, span := apiSpan(ctx, "instanceGroupManagers.get", "project", projectID, "zone", zone, "group", groupName)
+	group, err := computeService.InstanceGroupManagers.Get(projectID, zone, groupName).Context(groupCtx).Do()
+	span.Finish(err)
+	if err != nil {
+		return err
+	}
+
+	services, err := backendServices(ctx, computeService, projectID, group.InstanceGroup)
+	if err != nil {
+		return err
+	}
+
+	if len(services) == 0 {
+		return fmt.Errorf("WaitHealthy no backend services use group %v", groupName)
+	}
+
+	for {
+		unhealthy, err := groupHealth(ctx, computeService, projectID, group.InstanceGroup, services, instances)
+		if err != nil {
+			return err
+		}
+
+		if len(unhealthy) == 0 {
+			logger.Infof(ctx, "all %d instances in %v are healthy", len(instances), groupName)
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return &UnhealthyError{Group: groupName, Instances: unhealthy}
+		}
+
+		logger.Verbosef(ctx, "waiting on %d of %d unhealthy instances in %v", len(unhealthy), len(instances), groupName)
+		err = sleep(ctx, pollInterval)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// backendServices returns the global and regional backend services that have
+// instanceGroup as a backend.
+func backendServices(ctx context.Context, computeService *compute.Service, projectID, instanceGroup string) ([]*compute.BackendService, error) {
+	ctx, span := apiSpan(ctx, "backendServices.aggregatedList", "project", projectID)
+	list, err := computeService.BackendServices.AggregatedList(projectID).Context(ctx).Do()
+	span.Finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*compute.BackendService
+	for _, scope := range list.Items {
+		for _, service := range scope.BackendServices {
+			for _, backend := range service.Backends {
+				if resourcePath(backend.Group) == resourcePath(instanceGroup) {
+					result = append(result, service)
+					break
+				}
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// groupHealth returns the health state of each instance that is not HEALTHY
+// on every service.
+func groupHealth(ctx context.Context, computeService *compute.Service, projectID, instanceGroup string, services []*compute.BackendService, instances []string) (map[string]string, error) {
+	ref := &compute.ResourceGroupReference{Group: instanceGroup}
+	states := make(map[string]string)
+	for _, service := range services {
+		var health *compute.BackendServiceGroupHealth
+		var err error
+		healthCtx, span := apiSpan(ctx, "backendServices.getHealth", "project", projectID, "backendService", service.Name)
+		if service.Region != "" {
+			health, err = computeService.RegionBackendServices.GetHealth(projectID, lastPart(service.Region), service.Name, ref).Context(healthCtx).Do()
+		} else {
+			health, err = computeService.BackendServices.GetHealth(projectID, service.Name, ref).Context(healthCtx).Do()
+		}
+		span.Finish(err)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, status := range health.HealthStatus {
+			path := instancePath(status.Instance)
+			if states[path] == "" || states[path] == "HEALTHY" {
+				states[path] = status.HealthState
+			}
+		}
+	}
+
+	unhealthy := make(map[string]string)
+	for _, path := range instances {
+		state := states[path]
+		if state == "" {
+			state = "UNKNOWN"
+		}
+		if state != "HEALTHY" {
+			unhealthy[path] = state
+		}
+	}
+
+	return unhealthy, nil
+}
+
+// resourcePath returns the part of a resource URL following projects/ so URLs
+// from different API versions can be compared.
+func resourcePath(url string) string {
+	i := strings.Index(url, "projects/")
+	if i == -1 {
+		return url
+	}
+
+	return url[i:]
+}
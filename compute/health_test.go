@@ -0,0 +1,96 @@
+package compute
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitHealthy(t *testing.T) {
+	interval := pollInterval
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = interval }()
+
+	tests := []struct {
+		name      string
+		backend   bool
+		health    [][]string
+		timeout   time.Duration
+		unhealthy map[string]string
+		err       string
+	}{
+		{
+			name:    "no backend service",
+			backend: false,
+			health:  [][]string{{"HEALTHY", "HEALTHY"}},
+			timeout: time.Second,
+			err:     "no backend services use group app",
+		},
+		{
+			name:    "healthy",
+			backend: true,
+			health:  [][]string{{"HEALTHY", "HEALTHY"}},
+			timeout: time.Second,
+		},
+		{
+			name:    "becomes healthy",
+			backend: true,
+			health:  [][]string{{"UNHEALTHY", "UNKNOWN"}, {"HEALTHY", "UNHEALTHY"}, {"HEALTHY", "HEALTHY"}},
+			timeout: time.Second,
+		},
+		{
+			name:      "times out",
+			backend:   true,
+			health:    [][]string{{"HEALTHY", "UNHEALTHY"}},
+			timeout:   20 * time.Millisecond,
+			unhealthy: map[string]string{"zones/z1/instances/app-2": "UNHEALTHY"},
+			err:       "group app has 1 unhealthy instances: zones/z1/instances/app-2 (UNHEALTHY)",
+		},
+	}
+
+	for _, tt := range tests {
+		f := newFakeCompute(t, map[string]map[string]string{"p": {}})
+		f.instances = []string{"app-1", "app-2"}
+		f.version = "v2"
+		f.backend = tt.backend
+		f.health = tt.health
+
+		err := WaitHealthy(context.Background(), "p", "z1", "app", "v2", tt.timeout)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%v: WaitHealthy: %v", tt.name, err)
+			}
+			if tt.backend && f.healthCalls != len(tt.health) {
+				t.Errorf("%v: WaitHealthy checked health %d times, want %d", tt.name, f.healthCalls, len(tt.health))
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%v: WaitHealthy error %v, want %q", tt.name, err, tt.err)
+			continue
+		}
+
+		unhealthy, ok := err.(*UnhealthyError)
+		if ok != (tt.unhealthy != nil) {
+			t.Errorf("%v: WaitHealthy error %T, want an *UnhealthyError %v", tt.name, err, tt.unhealthy != nil)
+			continue
+		}
+		if ok && (unhealthy.Group != "app" || len(unhealthy.Instances) != len(tt.unhealthy) || unhealthy.Instances["zones/z1/instances/app-2"] != "UNHEALTHY") {
+			t.Errorf("%v: WaitHealthy unhealthy %+v, want %v", tt.name, unhealthy, tt.unhealthy)
+		}
+	}
+}
+
+func TestUnhealthyError(t *testing.T) {
+	err := &UnhealthyError{Group: "app", Instances: map[string]string{
+		"zones/z1/instances/app-2": "UNKNOWN",
+		"zones/z1/instances/app-1": "UNHEALTHY",
+	}}
+
+	want := "group app has 2 unhealthy instances: zones/z1/instances/app-1 (UNHEALTHY), zones/z1/instances/app-2 (UNKNOWN)"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
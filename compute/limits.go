@@ -0,0 +1,182 @@
+package compute
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"google.golang.org/api/compute/v1"
+)
+
+// GCE metadata limits, exceeding them fails the write with an API error.
+const (
+	// MaxKeyLength is the longest key in bytes.
+	MaxKeyLength = 128
+
+	// MaxValueSize is the largest single value in bytes.
+	MaxValueSize = 256 << 10
+
+	// MaxMetadataSize is the largest total of every key and value in bytes.
+	MaxMetadataSize = 512 << 10
+)
+
+// keyPattern is the character set GCE accepts in keys.
+var keyPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Usage is the size of a projects common metadata.
+type Usage struct {
+	// Size is the total of every key and value in bytes.
+	Size int
+
+	// LargestKey holds the largest value, LargestSize is its size in bytes.
+	LargestKey  string
+	LargestSize int
+}
+
+// MetadataUsage returns the usage of meta.
+func MetadataUsage(meta *compute.Metadata) Usage {
+	var u Usage
+	for _, item := range meta.Items {
+		size := 0
+		if item.Value != nil {
+			size = len(*item.Value)
+		}
+		u.Size += len(item.Key) + size
+		if size > u.LargestSize {
+			u.LargestKey = item.Key
+			u.LargestSize = size
+		}
+	}
+
+	return u
+}
+
+// Percent returns the highest percentage of the total or value limit used.
+func (u Usage) Percent() int {
+	total := u.Size * 100 / MaxMetadataSize
+	value := u.LargestSize * 100 / MaxValueSize
+	if value > total {
+		return value
+	}
+
+	return total
+}
+
+func (u Usage) String() string {
+	s := fmt.Sprintf("%v of %v total (%d%%)", kib(u.Size), kib(MaxMetadataSize), u.Size*100/MaxMetadataSize)
+	if u.LargestKey != "" {
+		s += fmt.Sprintf(", largest value %v %v of %v (%d%%)",
+			u.LargestKey, kib(u.LargestSize), kib(MaxValueSize), u.LargestSize*100/MaxValueSize)
+	}
+
+	return s
+}
+
+// kib formats n bytes for the usage report.
+func kib(n int) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	return fmt.Sprintf("%.1f KiB", float64(n)/1024)
+}
+
+// LimitError lists the GCE limits a write would exceed.
+type LimitError struct {
+	Key      string
+	Problems []string
+
+	// Usage is the usage of the metadata after the write.
+	Usage Usage
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v exceeds GCE metadata limits: %v, usage would be %v",
+		e.Key, strings.Join(e.Problems, ", "), e.Usage)
+}
+
+// checkLimits returns a *LimitError when applying changes to meta would
+// exceed a GCE limit.
+func checkLimits(meta *compute.Metadata, changes ...Change) error {
+	var keys, problems []string
+	for _, c := range changes {
+		if c.Delete {
+			continue
+		}
+
+		p := valueProblems(c.Key, c.NewValue)
+		if p != nil {
+			keys = append(keys, c.Key)
+			problems = append(problems, p...)
+		}
+	}
+
+	u := MetadataUsage(applyChanges(meta, changes))
+	if u.Size > MaxMetadataSize {
+		if keys == nil {
+			for _, c := range changes {
+				keys = append(keys, c.Key)
+			}
+		}
+		problems = append(problems, fmt.Sprintf("metadata would be %v, the limit is %v", kib(u.Size), kib(MaxMetadataSize)))
+	}
+
+	if problems != nil {
+		return &LimitError{Key: strings.Join(keys, ", "), Problems: problems, Usage: u}
+	}
+
+	return nil
+}
+
+// valueProblems returns the limits key and value exceed on their own.
+func valueProblems(key, value string) []string {
+	var problems []string
+	if len(key) > MaxKeyLength {
+		problems = append(problems, fmt.Sprintf("key %v is %d bytes, the limit is %d", key, len(key), MaxKeyLength))
+	}
+
+	if !keyPattern.MatchString(key) {
+		problems = append(problems, fmt.Sprintf("key %v may only contain letters, digits, - and _", key))
+	}
+
+	if len(value) > MaxValueSize {
+		problems = append(problems, fmt.Sprintf("value of %v is %v, the limit is %v", key, kib(len(value)), kib(MaxValueSize)))
+	}
+
+	return problems
+}
+
+// applyChanges returns a copy of meta with changes applied and deleted keys
+// removed.
+func applyChanges(meta *compute.Metadata, changes []Change) *compute.Metadata {
+	applied := &compute.Metadata{Fingerprint: meta.Fingerprint, Kind: meta.Kind}
+	pending := make(map[string]Change)
+	for _, c := range changes {
+		pending[c.Key] = c
+	}
+
+	for _, item := range meta.Items {
+		c, ok := pending[item.Key]
+		if ok {
+			delete(pending, c.Key)
+			if c.Delete {
+				continue
+			}
+			value := c.NewValue
+			item = &compute.MetadataItems{Key: c.Key, Value: &value}
+		}
+		applied.Items = append(applied.Items, item)
+	}
+
+	// new keys are appended in the order given.
+	for _, c := range changes {
+		_, ok := pending[c.Key]
+		if ok && !c.Delete {
+			value := c.NewValue
+			applied.Items = append(applied.Items, &compute.MetadataItems{Key: c.Key, Value: &value})
+			delete(pending, c.Key)
+		}
+	}
+
+	return applied
+}
@@ -0,0 +1,135 @@
+package compute
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/fresh8/rollerderby/backup"
+	"google.golang.org/api/compute/v1"
+)
+
+// metadataOf returns metadata holding values in the order of keys.
+func metadataOf(keys []string, values map[string]string) *compute.Metadata {
+	meta := &compute.Metadata{}
+	for _, k := range keys {
+		v := values[k]
+		meta.Items = append(meta.Items, &compute.MetadataItems{Key: k, Value: &v})
+	}
+
+	return meta
+}
+
+func TestCheckLimits(t *testing.T) {
+	big := strings.Repeat("x", MaxValueSize)
+
+	// full holds two values filling the total limit less 10 bytes.
+	full := metadataOf([]string{"a", "b"}, map[string]string{
+		"a": big,
+		"b": strings.Repeat("y", MaxMetadataSize-MaxValueSize-2-10),
+	})
+
+	tests := []struct {
+		name     string
+		meta     *compute.Metadata
+		changes  []Change
+		problems []string
+	}{
+		{
+			name:    "within limits",
+			meta:    metadataOf([]string{"a"}, map[string]string{"a": "1"}),
+			changes: []Change{{Key: "b", NewValue: "2"}},
+		},
+		{
+			name:    "largest value",
+			meta:    &compute.Metadata{},
+			changes: []Change{{Key: "a", NewValue: big}},
+		},
+		{
+			name:     "value too large",
+			meta:     &compute.Metadata{},
+			changes:  []Change{{Key: "a", NewValue: big + "x"}},
+			problems: []string{"value of a is 256.0 KiB, the limit is 256.0 KiB"},
+		},
+		{
+			name:     "key too long",
+			meta:     &compute.Metadata{},
+			changes:  []Change{{Key: strings.Repeat("k", MaxKeyLength+1), NewValue: "1"}},
+			problems: []string{"is 129 bytes, the limit is 128"},
+		},
+		{
+			name:     "invalid key",
+			meta:     &compute.Metadata{},
+			changes:  []Change{{Key: "app.version", NewValue: "1"}},
+			problems: []string{"key app.version may only contain letters, digits, - and _"},
+		},
+		{
+			name:    "fills the total",
+			meta:    full,
+			changes: []Change{{Key: "c", NewValue: "123456789"}},
+		},
+		{
+			name:     "total too large",
+			meta:     full,
+			changes:  []Change{{Key: "c", NewValue: "1234567890"}},
+			problems: []string{"metadata would be 512.0 KiB, the limit is 512.0 KiB"},
+		},
+		{
+			name:    "replacing a value counts the new value",
+			meta:    full,
+			changes: []Change{{Key: "a", NewValue: "small"}, {Key: "c", NewValue: strings.Repeat("z", 1024)}},
+		},
+		{
+			name:    "deleting frees space",
+			meta:    full,
+			changes: []Change{{Key: "b", Delete: true}, {Key: "c", NewValue: strings.Repeat("z", 1024)}},
+		},
+	}
+
+	for _, tt := range tests {
+		err := checkLimits(tt.meta, tt.changes...)
+		if (err != nil) != (tt.problems != nil) {
+			t.Errorf("%v: checkLimits = %v, want problems %v", tt.name, err, tt.problems)
+			continue
+		}
+		if err == nil {
+			continue
+		}
+
+		l, ok := err.(*LimitError)
+		if !ok || len(l.Problems) != len(tt.problems) {
+			t.Errorf("%v: checkLimits error %#v, want a *LimitError with %v", tt.name, err, tt.problems)
+			continue
+		}
+		for i, p := range tt.problems {
+			if !strings.Contains(l.Problems[i], p) {
+				t.Errorf("%v: problem %q, want %q", tt.name, l.Problems[i], p)
+			}
+		}
+	}
+}
+
+func TestMetadataUsage(t *testing.T) {
+	meta := metadataOf([]string{"a", "bb"}, map[string]string{"a": "123", "bb": strings.Repeat("x", MaxValueSize/2)})
+	u := MetadataUsage(meta)
+
+	if u.Size != 1+3+2+MaxValueSize/2 || u.LargestKey != "bb" || u.LargestSize != MaxValueSize/2 {
+		t.Errorf("MetadataUsage = %+v", u)
+	}
+	if u.Percent() != 50 {
+		t.Errorf("Percent() = %d, want the 50 of the largest value", u.Percent())
+	}
+}
+
+func TestUpdateKeyLimits(t *testing.T) {
+	f := newFakeCompute(t, map[string]map[string]string{"p": {"a": "old"}})
+	opts := WriteOptions{Backups: &backup.Backups{Store: &backup.Dir{Path: t.TempDir()}}}
+
+	_, err := UpdateKey(context.Background(), "p", "a", strings.Repeat("x", MaxValueSize+1), opts)
+	if _, ok := err.(*LimitError); !ok {
+		t.Errorf("UpdateKey of a value over the limit error %v, want a *LimitError", err)
+	}
+	if got := f.value("p", "a"); got != "old" {
+		t.Errorf("UpdateKey over the limit wrote %d bytes", len(got))
+	}
+}
@@ -0,0 +1,25 @@
+package compute
+
+import "sync"
+
+// projectLocks serialises the read and write of the common metadata of each
+// project within the process, so concurrent rollouts to groups of the same
+// project do not fail each others fingerprint check.
+var projectLocks = struct {
+	sync.Mutex
+	locks map[string]*sync.Mutex
+}{locks: make(map[string]*sync.Mutex)}
+
+// lockProject locks the metadata of projectID returning the unlock function.
+func lockProject(projectID string) func() {
+	projectLocks.Lock()
+	l, ok := projectLocks.locks[projectID]
+	if !ok {
+		l = &sync.Mutex{}
+		projectLocks.locks[projectID] = l
+	}
+	projectLocks.Unlock()
+
+	l.Lock()
+	return l.Unlock
+}
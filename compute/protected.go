@@ -0,0 +1,82 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/fresh8/rollerderby/logger"
+)
+
+// ReservedKeys are globs of the metadata keys read by GCE guest
+// environments, writing them by mistake can lock users out of instances or
+// change how instances boot.
+var ReservedKeys = []string{
+	"ssh-keys",
+	"sshKeys",
+	"block-project-ssh-keys",
+	"enable-oslogin",
+	"enable-oslogin-*",
+	"enable-windows-ssh",
+	"windows-keys",
+	"startup-script",
+	"startup-script-url",
+	"shutdown-script",
+	"shutdown-script-url",
+	"windows-startup-script-*",
+	"windows-shutdown-script-*",
+	"sysprep-specialize-script-*",
+	"serial-port-enable",
+	"serial-port-logging-enable",
+	"enable-guest-attributes",
+	"enable-os-inventory",
+	"enable-osconfig",
+	"google-logging-enable",
+	"google-monitoring-enable",
+	"gce-container-declaration",
+	"user-data",
+	"disable-legacy-endpoints",
+	"vmdnssetting",
+}
+
+// ProtectedError is returned when a reserved or protected key would be
+// changed without the override.
+type ProtectedError struct {
+	Key string
+
+	// Op is the refused operation, write or delete.
+	Op string
+}
+
+func (e *ProtectedError) Error() string {
+	return fmt.Sprintf("refusing to %v protected key %v, allow it explicitly to continue", e.Op, e.Key)
+}
+
+// isProtected reports whether key matches ReservedKeys or protected.
+func isProtected(key string, protected []string) bool {
+	for _, list := range [][]string{ReservedKeys, protected} {
+		for _, pattern := range list {
+			matched, _ := path.Match(pattern, key)
+			if matched {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkProtected returns a *ProtectedError when op would change a protected
+// key unless it is allowed.
+func (o WriteOptions) checkProtected(ctx context.Context, key, op string) error {
+	if !isProtected(key, o.Protected) {
+		return nil
+	}
+
+	if o.AllowProtected {
+		logger.Warnf(ctx, "allowing %v of protected key %v", op, key)
+		return nil
+	}
+
+	return &ProtectedError{Key: key, Op: op}
+}
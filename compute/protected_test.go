@@ -0,0 +1,92 @@
+package compute
+
+import (
+	"context"
+	"testing"
+
+	"github.com/fresh8/rollerderby/backup"
+)
+
+func TestCheckProtected(t *testing.T) {
+	tests := []struct {
+		key       string
+		protected []string
+		allow     bool
+		refused   bool
+	}{
+		{key: "app_version", refused: false},
+		{key: "ssh-keys", refused: true},
+		{key: "startup-script", refused: true},
+		{key: "enable-oslogin-2fa", refused: true},
+		{key: "windows-startup-script-ps1", refused: true},
+		{key: "startup-script-extra", refused: false},
+		{key: "ssh-keys", allow: true, refused: false},
+		{key: "db_host", protected: []string{"db_*"}, refused: true},
+		{key: "db_host", protected: []string{"db_*"}, allow: true, refused: false},
+		{key: "app_version", protected: []string{"db_*"}, refused: false},
+	}
+
+	for _, tt := range tests {
+		o := WriteOptions{Protected: tt.protected, AllowProtected: tt.allow}
+		err := o.checkProtected(context.Background(), tt.key, "write")
+		if (err != nil) != tt.refused {
+			t.Errorf("checkProtected(%v, %v, allow %v) = %v, want refused %v", tt.key, tt.protected, tt.allow, err, tt.refused)
+			continue
+		}
+
+		if err != nil {
+			p, ok := err.(*ProtectedError)
+			if !ok || p.Key != tt.key || p.Op != "write" {
+				t.Errorf("checkProtected(%v) error %#v, want a *ProtectedError", tt.key, err)
+			}
+		}
+	}
+}
+
+func TestWriteProtected(t *testing.T) {
+	tests := []struct {
+		key     string
+		delete  bool
+		allow   bool
+		refused bool
+	}{
+		{key: "startup-script", refused: true},
+		{key: "startup-script", allow: true},
+		{key: "startup-script", delete: true, refused: true},
+		{key: "startup-script", delete: true, allow: true},
+		{key: "db_host", refused: true},
+		{key: "db_host", delete: true, refused: true},
+		{key: "app_version"},
+	}
+
+	for _, tt := range tests {
+		f := newFakeCompute(t, map[string]map[string]string{"p": {"startup-script": "old", "db_host": "old", "app_version": "old"}})
+		opts := WriteOptions{
+			Protected:      []string{"db_*"},
+			AllowProtected: tt.allow,
+			Backups:        &backup.Backups{Store: &backup.Dir{Path: t.TempDir()}},
+		}
+
+		var err error
+		want := "new"
+		if tt.delete {
+			_, err = DeleteKey(context.Background(), "p", tt.key, opts)
+			want = ""
+		} else {
+			_, err = UpdateKey(context.Background(), "p", tt.key, "new", opts)
+		}
+
+		if _, ok := err.(*ProtectedError); ok != tt.refused {
+			t.Errorf("%v delete %v allow %v error %v, want refused %v", tt.key, tt.delete, tt.allow, err, tt.refused)
+		}
+		if tt.refused {
+			want = "old"
+		} else if err != nil {
+			t.Errorf("%v delete %v allow %v: %v", tt.key, tt.delete, tt.allow, err)
+		}
+
+		if got := f.value("p", tt.key); got != want {
+			t.Errorf("%v delete %v allow %v left %q, want %q", tt.key, tt.delete, tt.allow, got, want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fresh8/rollerderby/logger"
+)
+
+// Selector picks instance groups by name, location and the labels of their
+// instance template. Blank fields match every group.
+type Selector struct {
+	// Name is a glob on the group name such as svc-api-*, or a regular
+	// expression between slashes such as /^svc-(api|web)-/.
+	Name string
+
+	// Locations are zones or regions, optionally globs, a region matches
+	// every zone in it.
+	Locations []string
+
+	// Labels must all be set to these values on the instance template.
+	Labels map[string]string
+}
+
+// SelectedGroup is an instance group picked by a Selector.
+type SelectedGroup struct {
+	Zone     string
+	Name     string
+	Template string
+	Versions []string
+}
+
+// IsZero reports whether s has no criteria.
+func (s Selector) IsZero() bool {
+	return s.Name == "" && len(s.Locations) == 0 && len(s.Labels) == 0
+}
+
+// nameMatcher returns a function matching group names against s.Name.
+func (s Selector) nameMatcher() (func(string) bool, error) {
+	if len(s.Name) > 1 && strings.HasPrefix(s.Name, "/") && strings.HasSuffix(s.Name, "/") {
+		re, err := regexp.Compile(s.Name[1 : len(s.Name)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid group name regex %v: %v", s.Name, err)
+		}
+
+		return re.MatchString, nil
+	}
+
+	if s.Name == "" {
+		return func(string) bool { return true }, nil
+	}
+
+	_, err := path.Match(s.Name, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid group name glob %q: %v", s.Name, err)
+	}
+
+	return func(name string) bool {
+		matched, _ := path.Match(s.Name, name)
+		return matched
+	}, nil
+}
+
+// inLocation reports whether zone or its region matches one of s.Locations.
+func (s Selector) inLocation(zone string) bool {
+	if len(s.Locations) == 0 {
+		return true
+	}
+
+	region := zone
+	i := strings.LastIndex(zone, "-")
+	if i > 0 {
+		region = zone[:i]
+	}
+
+	for _, l := range s.Locations {
+		inZone, _ := path.Match(l, zone)
+		inRegion, _ := path.Match(l, region)
+		if inZone || inRegion {
+			return true
+		}
+	}
+
+	return false
+}
+
+// SelectGroups returns the zonal instance groups of projectID picked by s
+// sorted by zone and name. Regional groups and groups whose template labels
+// cannot be read are skipped.
+func SelectGroups(ctx context.Context, projectID string, s Selector) ([]SelectedGroup, error) {
+	if projectID == "" {
+		return nil, fmt.Errorf("SelectGroups projectID cannot be blank")
+	}
+
+	matchName, err := s.nameMatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, l := range s.Locations {
+		_, err := path.Match(l, "")
+		if err != nil {
+			return nil, fmt.Errorf("invalid location %q: %v", l, err)
+		}
+	}
+
+	computeService, err := betaComputeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := aggregatedList(ctx, computeService.InstanceGroupManagers, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	// labels and labelErrs cache the template lookups, a group whose
+	// template cannot be read is skipped rather than failing the selection.
+	labels := make(map[string]map[string]string)
+	labelErrs := make(map[string]error)
+	var result []SelectedGroup
+	for _, item := range list.Items {
+		for _, group := range item.InstanceGroupManagers {
+			if group.Zone == "" {
+				logger.Verbosef(ctx, "skipping regional group %v", group.Name)
+				continue
+			}
+
+			zone := lastPart(group.Zone)
+			if !matchName(group.Name) || !s.inLocation(zone) {
+				continue
+			}
+
+			template := lastPart(group.InstanceTemplate)
+			if len(s.Labels) > 0 {
+				if _, ok := labels[template]; !ok {
+					labels[template], labelErrs[template] = templateLabels(ctx, projectID, template)
+				}
+				if labelErrs[template] != nil {
+					logger.Warnf(ctx, "skipping group %v in %v, unable to read the labels of template %v: %v", group.Name, zone, template, labelErrs[template])
+					continue
+				}
+				if !hasLabels(labels[template], s.Labels) {
+					continue
+				}
+			}
+
+			result = append(result, SelectedGroup{
+				Zone:     zone,
+				Name:     group.Name,
+				Template: template,
+				Versions: versionNames(group),
+			})
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Zone != result[j].Zone {
+			return result[i].Zone < result[j].Zone
+		}
+		return result[i].Name < result[j].Name
+	})
+
+	return result, nil
+}
+
+// templateLabels returns the labels of the instance template name.
+func templateLabels(ctx context.Context, projectID, name string) (map[string]string, error) {
+	computeService, err := v1ComputeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := apiSpan(ctx, "instanceTemplates.get", "project", projectID, "template", name)
+	template, err := computeService.InstanceTemplates.Get(projectID, name).Context(ctx).Do()
+	span.Finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	if template.Properties == nil {
+		return nil, nil
+	}
+
+	return template.Properties.Labels, nil
+}
+
+// hasLabels reports whether every label in want is set to its value in got.
+func hasLabels(got, want map[string]string) bool {
+	for k, v := range want {
+		if value, ok := got[k]; !ok || value != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// PrintGroups outputs groups as a table.
+func PrintGroups(groups []SelectedGroup) {
+	fmt.Printf("%-20.20s | %-30.30s | %-30.30s | %s\n", "zone", "group", "template", "versions")
+	fmt.Printf("%s\n", strings.Repeat("=", 20+30+30+30+3*3))
+	for _, g := range groups {
+		fmt.Printf("%-20.20s | %-30.30s | %-30.30s | %s\n", g.Zone, g.Name, g.Template, strings.Join(g.Versions, ", "))
+	}
+}
@@ -0,0 +1,91 @@
+package compute
+
+import "testing"
+
+func TestNameMatcher(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"", "svc-api-europe-west1-d", true},
+		{"svc-api-*", "svc-api-europe-west1-d", true},
+		{"svc-api-*", "svc-web-europe-west1-d", false},
+		{"svc-?pi-*", "svc-api-us-east1-c", true},
+		{"svc-api", "svc-api-europe-west1-d", false},
+		{"/^svc-(api|web)-/", "svc-web-us-east1-c", true},
+		{"/^svc-(api|web)-/", "svc-worker-us-east1-c", false},
+		{"/api/", "svc-api-us-east1-c", true},
+		{"/^api/", "svc-api-us-east1-c", false},
+		{"/", "/", true},
+	}
+
+	for _, tt := range tests {
+		match, err := Selector{Name: tt.pattern}.nameMatcher()
+		if err != nil {
+			t.Errorf("nameMatcher(%q) error: %v", tt.pattern, err)
+			continue
+		}
+
+		got := match(tt.name)
+		if got != tt.want {
+			t.Errorf("nameMatcher(%q)(%q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNameMatcherInvalid(t *testing.T) {
+	for _, pattern := range []string{"[", "svc-[a-", "/(/", "/svc-[/"} {
+		_, err := Selector{Name: pattern}.nameMatcher()
+		if err == nil {
+			t.Errorf("nameMatcher(%q) error = nil, want an error", pattern)
+		}
+	}
+}
+
+func TestInLocation(t *testing.T) {
+	tests := []struct {
+		locations []string
+		zone      string
+		want      bool
+	}{
+		{nil, "europe-west1-d", true},
+		{[]string{"europe-west1-d"}, "europe-west1-d", true},
+		{[]string{"europe-west1-d"}, "europe-west1-b", false},
+		{[]string{"europe-west1"}, "europe-west1-b", true},
+		{[]string{"europe-west1"}, "europe-west2-b", false},
+		{[]string{"europe-west"}, "europe-west1-b", false},
+		{[]string{"us-*"}, "us-east1-c", true},
+		{[]string{"us-*"}, "europe-west1-d", false},
+		{[]string{"europe-west1-?"}, "europe-west1-c", true},
+		{[]string{"us-east1", "europe-west1-d"}, "europe-west1-d", true},
+		{[]string{"us-east1", "europe-west1-d"}, "europe-west1-c", false},
+	}
+
+	for _, tt := range tests {
+		got := Selector{Locations: tt.locations}.inLocation(tt.zone)
+		if got != tt.want {
+			t.Errorf("inLocation(%v, %q) = %v, want %v", tt.locations, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestHasLabels(t *testing.T) {
+	got := map[string]string{"team": "core", "app": "api"}
+	tests := []struct {
+		want map[string]string
+		ok   bool
+	}{
+		{nil, true},
+		{map[string]string{"team": "core"}, true},
+		{map[string]string{"team": "core", "app": "api"}, true},
+		{map[string]string{"team": "core", "app": "web"}, false},
+		{map[string]string{"env": ""}, false},
+	}
+
+	for _, tt := range tests {
+		if ok := hasLabels(got, tt.want); ok != tt.ok {
+			t.Errorf("hasLabels(%v, %v) = %v, want %v", got, tt.want, ok, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/fresh8/rollerderby/logger"
+	"google.golang.org/api/compute/v1"
+)
+
+// SyncOptions selects the keys a desired state manages.
+type SyncOptions struct {
+	// Prune deletes managed keys missing from the desired state. Reserved
+	// and protected keys are only pruned when they are allowed to change.
+	Prune bool
+
+	// Keys limits the managed keys to these globs, every key when empty.
+	Keys []string
+
+	// Prefix limits the managed keys to those starting with it.
+	Prefix string
+}
+
+// manages reports whether key is managed by the desired state.
+func (so SyncOptions) manages(key string) bool {
+	if !strings.HasPrefix(key, so.Prefix) {
+		return false
+	}
+
+	if len(so.Keys) == 0 {
+		return true
+	}
+
+	for _, g := range so.Keys {
+		ok, _ := path.Match(g, key)
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// syncChanges returns the additions, updates and, when pruning, deletions
+// that make the managed keys of meta equal desired, sorted by key.
+func syncChanges(ctx context.Context, meta *compute.Metadata, desired map[string]string, so SyncOptions, opts WriteOptions) []Change {
+	changes := pendingChanges(newChanges(meta, desired, opts.Schemas))
+	if !so.Prune {
+		return changes
+	}
+
+	for _, item := range meta.Items {
+		_, ok := desired[item.Key]
+		if ok || !so.manages(item.Key) {
+			continue
+		}
+
+		if isProtected(item.Key, opts.Protected) && !opts.AllowProtected {
+			logger.Verbosef(ctx, "not pruning protected key %v", item.Key)
+			continue
+		}
+		changes = append(changes, deleteChange(meta, item.Key))
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	return changes
+}
+
+// Drift returns the changes Sync would make to projectID with opts without
+// checking whether they are allowed. Like Sync, reserved and protected keys
+// are only reported as pruned when opts allows them to change.
+func Drift(ctx context.Context, projectID string, desired map[string]string, so SyncOptions, opts WriteOptions) ([]Change, error) {
+	_, project, err := projectForKeys(ctx, projectID, desired)
+	if err != nil {
+		return nil, err
+	}
+
+	return syncChanges(ctx, project.CommonInstanceMetadata, desired, so, opts), nil
+}
+
+// PlanSync returns the changes Sync would make. The error is the one Sync
+// would refuse the changes with.
+func PlanSync(ctx context.Context, projectID string, desired map[string]string, so SyncOptions, opts WriteOptions) ([]Change, error) {
+	_, project, err := projectForKeys(ctx, projectID, desired)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := syncChanges(ctx, project.CommonInstanceMetadata, desired, so, opts)
+	for _, c := range changes {
+		if c.Delete {
+			err = opts.checkProtected(ctx, c.Key, "delete")
+		} else {
+			err = opts.check(ctx, c.Key, c.NewValue)
+			if err == nil {
+				err = opts.checkChange(ctx, c)
+			}
+		}
+		if err != nil {
+			return changes, err
+		}
+	}
+
+	return changes, checkLimits(project.CommonInstanceMetadata, changes...)
+}
+
+// Sync makes the managed keys of projectID equal desired in a single write
+// with the same checks, backup and fingerprint check as UpdateKey. Nothing is
+// written when there is no difference. The written changes are returned.
+func Sync(ctx context.Context, projectID string, desired map[string]string, so SyncOptions, opts WriteOptions) ([]Change, error) {
+	defer lockProject(projectID)()
+	computeService, project, err := projectForKeys(ctx, projectID, desired)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := syncChanges(ctx, project.CommonInstanceMetadata, desired, so, opts)
+	if len(changes) == 0 {
+		logger.Infof(ctx, "no changes to write")
+		return nil, nil
+	}
+
+	return changes, writeChanges(ctx, computeService, project, changes, opts)
+}
+
+// projectForKeys validates a multi key write and reads the project.
+func projectForKeys(ctx context.Context, projectID string, values map[string]string) (*compute.Service, *compute.Project, error) {
+	if projectID == "" {
+		return nil, nil, fmt.Errorf("Sync projectID cannot be blank")
+	}
+
+	for k, v := range values {
+		if k == "" {
+			return nil, nil, fmt.Errorf("Sync key cannot be blank")
+		}
+
+		if v == "" {
+			return nil, nil, fmt.Errorf("Sync value of %v cannot be blank", k)
+		}
+	}
+
+	computeService, err := v1ComputeClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	project, err := getProject(ctx, computeService, projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return computeService, project, nil
+}
+
+// pendingChanges returns changes without the unchanged keys.
+func pendingChanges(changes []Change) []Change {
+	var pending []Change
+	for _, c := range changes {
+		if !c.Unchanged() {
+			pending = append(pending, c)
+		}
+	}
+
+	return pending
+}
@@ -0,0 +1,24 @@
+package compute
+
+import (
+	"context"
+	"time"
+
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/tracing"
+)
+
+// operationPoll is the delay between polls of a pending operation.
+var operationPoll = 2 * time.Second
+
+// operationTimeout bounds waiting on a single operation.
+var operationTimeout = 5 * time.Minute
+
+// apiSpan starts a client span around a Compute API call and logs the call
+// at debug level.
+func apiSpan(ctx context.Context, name string, attrs ...string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "compute."+name, attrs...)
+	span.Kind = tracing.KindClient
+	logger.Debugf(logger.WithFields(ctx, attrs...), "calling compute.%v", name)
+	return ctx, span
+}
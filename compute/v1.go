@@ -2,17 +2,16 @@ package compute
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"log"
-	"os"
 	"sort"
 	"strings"
 	"time"
 
+	"github.com/fresh8/rollerderby/diff"
 	"github.com/fresh8/rollerderby/errors"
-	"golang.org/x/oauth2/google"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/redact"
+	"github.com/fresh8/rollerderby/tracing"
 	"google.golang.org/api/compute/v1"
 )
 
@@ -23,74 +22,8 @@ type CompareMeta struct {
 	B string
 }
 
-// ListInstanceGroups prints all instance groups for the given projectID.
-func ListInstanceGroups(projectID string) error {
-	if projectID == "" {
-		return fmt.Errorf("ListInstanceGroups projectID cannot be blank")
-	}
-
-	computeService, err := v1ComputeClient()
-	if err != nil {
-		return err
-	}
-
-	igms := compute.NewInstanceGroupManagersService(computeService)
-
-	list, err := aggregatedList(igms, projectID)
-	if err != nil {
-		return err
-	}
-
-	for k, item := range list.Items {
-		if len(item.InstanceGroupManagers) > 0 {
-			log.Println(k) // print zone
-		}
-
-		for _, v := range item.InstanceGroupManagers {
-			log.Println("    ", v.Name) // instance group
-			a := strings.Split(v.Zone, "/")
-
-			instances, err := ListManagedInstances(igms, projectID, a[len(a)-1], v.Name)
-			if err != nil {
-				return err
-			}
-
-			for _, instance := range instances {
-				log.Println("        ", instance) // instances
-			}
-		}
-	}
-
-	return nil
-}
-
-func aggregatedList(igms *compute.InstanceGroupManagersService, projectID string) (*compute.InstanceGroupManagerAggregatedList, error) {
-	return igms.AggregatedList(projectID).Do()
-}
-
-// ListManagedInstances lists the managed instances for the given projectID, zone, and groupName.
-func ListManagedInstances(igms *compute.InstanceGroupManagersService, projectID, zone, groupName string) ([]string, error) {
-	list, err := igms.ListManagedInstances(projectID, zone, groupName).Do()
-	if err != nil {
-		return nil, err
-	}
-
-	var result []string
-	for _, instance := range list.ManagedInstances {
-		if instance == nil {
-			continue
-		}
-
-		a := strings.Split(instance.Instance, "/")
-
-		result = append(result, strings.Join(a[len(a)-4:], "/"))
-	}
-
-	return result, nil
-}
-
 // CompareProjects prints a comparison table between projectA and projectB.
-func CompareProjects(projectA, projectB string) (map[string]CompareMeta, error) {
+func CompareProjects(ctx context.Context, projectA, projectB string) (map[string]CompareMeta, error) {
 	if projectA == "" {
 		return nil, fmt.Errorf("CompareProjects projectA cannot be blank")
 	}
@@ -104,14 +37,12 @@ func CompareProjects(projectA, projectB string) (map[string]CompareMeta, error)
 		return nil, err
 	}
 
-	projectService := compute.NewProjectsService(computeService)
-
-	aInfo, err := getProject(projectService, projectA)
+	aInfo, err := getProject(ctx, computeService, projectA)
 	if err != nil {
 		return nil, err
 	}
 
-	bInfo, err := getProject(projectService, projectB)
+	bInfo, err := getProject(ctx, computeService, projectB)
 	if err != nil {
 		return nil, err
 	}
@@ -121,7 +52,7 @@ func CompareProjects(projectA, projectB string) (map[string]CompareMeta, error)
 		// TODO should probably check for dups here rather than assume anything.
 		_, ok := keys[item.Key]
 		if ok {
-			log.Printf("WARNING: duplicate key seen in first projects metadata %v\n", item.Key)
+			logger.Warnf(ctx, "duplicate key seen in first projects metadata %v", item.Key)
 		}
 		keys[item.Key] = CompareMeta{
 			A: *item.Value,
@@ -148,9 +79,11 @@ func isSame(a, b string) string {
 	return "✔"
 }
 
-func PrintKeys(keys map[string]CompareMeta, projectA, projectB string) {
-	log.Printf("%-45.45s | %-5.5s | %-25.25s | %-25.25s\n", "key", "equal", projectA, projectB)
-	log.Printf("%s\n", strings.Repeat("=", 45+5+2*25+3*3))
+// PrintKeys outputs a comparison table of keys with the values r considers
+// secrets masked. Equality is decided on the unmasked values.
+func PrintKeys(keys map[string]CompareMeta, projectA, projectB string, r redact.Redactor) {
+	fmt.Printf("%-45.45s | %-5.5s | %-25.25s | %-25.25s\n", "key", "equal", projectA, projectB)
+	fmt.Printf("%s\n", strings.Repeat("=", 45+5+2*25+3*3))
 	var keyNames []string
 	for k := range keys {
 		keyNames = append(keyNames, k)
@@ -158,36 +91,55 @@ func PrintKeys(keys map[string]CompareMeta, projectA, projectB string) {
 	sort.Strings(keyNames)
 
 	for _, k := range keyNames {
-		log.Printf("%-45.45s | %3s   | %-25.25s | %-25.25s\n", k, isSame(keys[k].A, keys[k].B), keys[k].A, keys[k].B)
+		a, b := keys[k].A, keys[k].B
+		fmt.Printf("%-45.45s | %3s   | %-25.25s | %-25.25s\n", k, isSame(a, b), diff.Summary(r.Value(k, a)), diff.Summary(r.Value(k, b)))
 	}
 }
 
-// ListKeys prints a list of all keys associated with a projectID.
-func ListKeys(projectID string) error {
+// ListKeys prints a list of all keys associated with a projectID with the
+// values r considers secrets masked, followed by the usage of the GCE
+// metadata limits. A warning is logged when the usage is above warnPercent.
+func ListKeys(ctx context.Context, projectID string, r redact.Redactor, warnPercent int) error {
+	items, err := Items(ctx, projectID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-45.45s | %-30.30s\n", "key", projectID)
+	fmt.Printf("%s\n", strings.Repeat("=", 45+30+1*3))
+	for _, meta := range items {
+		fmt.Printf("%-45.45s | %-30.30s\n", meta.Key, diff.Summary(r.Value(meta.Key, *meta.Value)))
+	}
+
+	u := MetadataUsage(&compute.Metadata{Items: items})
+	fmt.Printf("usage: %v\n", u)
+	if warnPercent > 0 && u.Percent() >= warnPercent {
+		logger.Warnf(ctx, "%v metadata is at %d%% of the GCE limits", projectID, u.Percent())
+	}
+
+	return nil
+}
+
+// Items returns the common metadata items of projectID sorted by key.
+func Items(ctx context.Context, projectID string) ([]*compute.MetadataItems, error) {
 	if projectID == "" {
-		return fmt.Errorf("ListKeys projectID cannot be blank")
+		return nil, fmt.Errorf("Items projectID cannot be blank")
 	}
 
 	computeService, err := v1ComputeClient()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	projectService := compute.NewProjectsService(computeService)
-
-	project, err := getProject(projectService, projectID)
+	project, err := getProject(ctx, computeService, projectID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	sort.Sort(ItemsByKey(project.CommonInstanceMetadata.Items))
-	log.Printf("%-45.45s | %-30.30s\n", "key", projectID)
-	log.Printf("%s\n", strings.Repeat("=", 45+30+1*3))
-	for _, meta := range project.CommonInstanceMetadata.Items {
-		log.Printf("%-45.45s | %-30.30s\n", meta.Key, *meta.Value)
-	}
+	items := project.CommonInstanceMetadata.Items
+	sort.Sort(ItemsByKey(items))
 
-	return nil
+	return items, nil
 }
 
 // ItemsByKey is a sortable interface for metadata items.
@@ -197,100 +149,224 @@ func (m ItemsByKey) Len() int           { return len(m) }
 func (m ItemsByKey) Less(i, j int) bool { return m[i].Key < m[j].Key }
 func (m ItemsByKey) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
 
-// UpdateKey updates the projects common metadata key with newValue.
-func UpdateKey(projectID string, key string, newValue string) error {
-	configErrors := validateUpdateParms(projectID, key, newValue)
+// GetKey returns the value of the projects common metadata key and whether it
+// is set.
+func GetKey(ctx context.Context, projectID, key string) (string, bool, error) {
+	if projectID == "" {
+		return "", false, fmt.Errorf("GetKey projectID cannot be blank")
+	}
+
+	computeService, err := v1ComputeClient()
+	if err != nil {
+		return "", false, err
+	}
+
+	project, err := getProject(ctx, computeService, projectID)
+	if err != nil {
+		return "", false, err
+	}
+
+	item := findItem(project.CommonInstanceMetadata, key)
+	if item == nil {
+		return "", false, nil
+	}
+
+	return itemValue(item), true, nil
+}
+
+// UpdateKey updates the projects common metadata key with newValue returning
+// the change made, which holds the keys previous value. newValue is checked
+// against opts before anything is written.
+func UpdateKey(ctx context.Context, projectID string, key string, newValue string, opts WriteOptions) (Change, error) {
+	configErrors := validateUpdateParms(projectID, key, newValue, opts.AllowEmpty)
 	if configErrors != nil {
-		return configErrors
+		return Change{Key: key, NewValue: newValue}, configErrors
 	}
 
 	computeService, err := v1ComputeClient()
 	if err != nil {
-		return err
+		return Change{Key: key, NewValue: newValue}, err
 	}
 
-	projectService := compute.NewProjectsService(computeService)
 	// TODO (NF 2018-08-10): Retry loop when a fingerprint doesn't match (aka a concurrent write).
 	// retrieve current values
-	project, err := getProject(projectService, projectID)
+	defer lockProject(projectID)()
+	project, err := getProject(ctx, computeService, projectID)
 	if err != nil {
-		return err
+		return Change{Key: key, NewValue: newValue}, err
+	}
+
+	change := newChange(project.CommonInstanceMetadata, key, newValue, opts.Schemas)
+	err = writeChanges(ctx, computeService, project, []Change{change}, opts)
+
+	return change, err
+}
+
+// writeKey writes the change of key to newValue, see writeChanges, returning
+// the previous value.
+func writeKey(ctx context.Context, computeService *compute.Service, project *compute.Project, key, newValue string, opts WriteOptions) (string, error) {
+	change := newChange(project.CommonInstanceMetadata, key, newValue, opts.Schemas)
+	err := writeChanges(ctx, computeService, project, []Change{change}, opts)
+
+	return change.OldValue, err
+}
+
+// DeleteKey removes the projects common metadata key returning its previous
+// value. Nothing is written when the key is not set.
+func DeleteKey(ctx context.Context, projectID, key string, opts WriteOptions) (string, error) {
+	if projectID == "" || key == "" {
+		return "", fmt.Errorf("DeleteKey projectID and key cannot be blank")
 	}
 
-	filename := fmt.Sprintf("%v-%d.json", project.Name, time.Now().Unix())
-	w, err := os.Create(filename)
+	computeService, err := v1ComputeClient()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	err = writeMeta(w, project.CommonInstanceMetadata)
+	defer lockProject(projectID)()
+	project, err := getProject(ctx, computeService, projectID)
+	if err != nil {
+		return "", err
+	}
+
+	if findItem(project.CommonInstanceMetadata, key) == nil {
+		logger.Infof(ctx, "%v is not set, nothing to delete", key)
+		return "", nil
+	}
+
+	change := deleteChange(project.CommonInstanceMetadata, key)
+	err = writeChanges(ctx, computeService, project, []Change{change}, opts)
+
+	return change.OldValue, err
+}
+
+// writeChanges checks changes against opts and the GCE limits, backs up the
+// current metadata of project and writes every change at once. The write is
+// rejected if the metadata fingerprint changed since project was read.
+func writeChanges(ctx context.Context, computeService *compute.Service, project *compute.Project, changes []Change, opts WriteOptions) error {
+	for _, c := range changes {
+		if c.Delete {
+			err := opts.checkProtected(ctx, c.Key, "delete")
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		err := opts.check(ctx, c.Key, c.NewValue)
+		if err != nil {
+			return err
+		}
+
+		err = opts.checkChange(ctx, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := checkLimits(project.CommonInstanceMetadata, changes...)
 	if err != nil {
 		return err
 	}
 
-	log.Println("wrote current metadata to", filename)
-	err = updateCommonKey(project, key, newValue, projectService)
+	name, err := opts.backups().Save(ctx, snapshot(project, changes, opts))
 	if err != nil {
 		return err
 	}
 
+	logger.Infof(ctx, "wrote current metadata to %v", name)
+	logger.Verbosef(ctx, "fingerprint: %v", project.CommonInstanceMetadata.Fingerprint)
+	for _, c := range changes {
+		logger.Infof(ctx, "%v", c.Redacted(opts.Redactor))
+	}
+
+	err = setCommonInstanceMetadata(ctx, computeService, project.Name, applyChanges(project.CommonInstanceMetadata, changes))
+	if err != nil {
+		return fmt.Errorf("writeChanges %v", err)
+	}
+
 	return nil
 }
 
 func v1ComputeClient() (*compute.Service, error) {
-	ctx := context.Background()
-	client, err := google.DefaultClient(ctx, compute.ComputeScope)
+	client, err := httpClient()
 	if err != nil {
 		return nil, err
 	}
 
-	return compute.New(client)
+	service, err := compute.New(client)
+	if err != nil {
+		return nil, err
+	}
+
+	path := basePath("v1")
+	if path != "" {
+		service.BasePath = path
+	}
+
+	return service, nil
 }
 
-func getProject(projectService *compute.ProjectsService, projectID string) (*compute.Project, error) {
-	project, err := projectService.Get(projectID).Do()
+func getProject(ctx context.Context, computeService *compute.Service, projectID string) (*compute.Project, error) {
+	ctx, span := apiSpan(ctx, "projects.get", "project", projectID)
+	project, err := computeService.Projects.Get(projectID).Context(ctx).Do()
+	span.Finish(err)
 	return project, err
 }
 
-func writeMeta(w io.WriteCloser, meta *compute.Metadata) error {
-	enc := json.NewEncoder(w)
-	return enc.Encode(meta)
+// setCommonInstanceMetadata writes meta and waits for the operation to
+// complete. The write fails if the metadata fingerprint has changed since
+// it was read.
+func setCommonInstanceMetadata(ctx context.Context, computeService *compute.Service, projectID string, meta *compute.Metadata) error {
+	ctx, span := apiSpan(ctx, "projects.setCommonInstanceMetadata", "project", projectID, "fingerprint", meta.Fingerprint)
+	op, err := computeService.Projects.SetCommonInstanceMetadata(projectID, meta).Context(ctx).Do()
+	span.Finish(err)
+	if err != nil {
+		return err
+	}
+
+	return waitGlobalOperation(ctx, computeService, projectID, op)
+}
+
+// waitGlobalOperation polls op until it is done returning its error if any.
+func waitGlobalOperation(ctx context.Context, computeService *compute.Service, projectID string, op *compute.Operation) error {
+	ctx, span := tracing.Start(ctx, "compute.operations.wait", "project", projectID, "operation", op.Name)
+	err := pollGlobalOperation(ctx, computeService, projectID, op)
+	span.Finish(err)
+	return err
 }
 
-func updateCommonKey(project *compute.Project, key string, newValue string, projectService *compute.ProjectsService) error {
-	keyIndex := -1
-	log.Println("fingerprint:", project.CommonInstanceMetadata.Fingerprint)
-	for i, meta := range project.CommonInstanceMetadata.Items {
-		if meta.Key == key {
-			log.Printf("%s: %s -> %s\n", meta.Key, *meta.Value, newValue)
-			keyIndex = i
+func pollGlobalOperation(ctx context.Context, computeService *compute.Service, projectID string, op *compute.Operation) error {
+	deadline := time.Now().Add(operationTimeout)
+	for op.Status != "DONE" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("operation %v not done after %v", op.Name, operationTimeout)
+		}
+		err := sleep(ctx, operationPoll)
+		if err != nil {
+			return err
 		}
-	}
 
-	// update new values in metadata
-	if keyIndex == -1 {
-		keyIndex = len(project.CommonInstanceMetadata.Items)
-		newItem := compute.MetadataItems{
-			Key: key,
+		pollCtx, span := apiSpan(ctx, "globalOperations.get", "operation", op.Name)
+		op, err = computeService.GlobalOperations.Get(projectID, op.Name).Context(pollCtx).Do()
+		span.Finish(err)
+		if err != nil {
+			return err
 		}
-		project.CommonInstanceMetadata.Items = append(project.CommonInstanceMetadata.Items, &newItem)
-		log.Printf("%s: <EMPTY> -> %s\n", key, newValue)
 	}
 
-	project.CommonInstanceMetadata.Items[keyIndex].Value = &newValue
-
-	op, err := projectService.SetCommonInstanceMetadata(project.Name, project.CommonInstanceMetadata).Do()
-	if err != nil {
-		return err
-	}
 	if op.Error != nil {
-		return fmt.Errorf("updateCommonKey %+v", op.Error.Errors)
+		var errs errors.Errors
+		for _, e := range op.Error.Errors {
+			errs = append(errs, fmt.Errorf("%v %v %v", e.Code, e.Message, e.Location))
+		}
+		return fmt.Errorf("operation %v: %v", op.Name, errs)
 	}
 
 	return nil
 }
 
-func validateUpdateParms(projectID, key, value string) errors.Errors {
+func validateUpdateParms(projectID, key, value string, allowEmpty bool) errors.Errors {
 	var errors errors.Errors
 	if projectID == "" {
 		errors = append(errors, fmt.Errorf("validateUpdateParms projectID cannot be blank"))
@@ -300,9 +376,24 @@ func validateUpdateParms(projectID, key, value string) errors.Errors {
 		errors = append(errors, fmt.Errorf("validateUpdateParms key cannot be blank"))
 	}
 
-	if value == "" {
+	if value == "" && !allowEmpty {
 		errors = append(errors, fmt.Errorf("validateUpdateParms value cannot be blank"))
 	}
 
 	return errors
 }
+
+// Fingerprint returns the fingerprint of the projects common metadata.
+func Fingerprint(ctx context.Context, projectID string) (string, error) {
+	computeService, err := v1ComputeClient()
+	if err != nil {
+		return "", err
+	}
+
+	project, err := getProject(ctx, computeService, projectID)
+	if err != nil {
+		return "", err
+	}
+
+	return project.CommonInstanceMetadata.Fingerprint, nil
+}
@@ -0,0 +1,64 @@
+package compute
+
+import (
+	"context"
+
+	"github.com/fresh8/rollerderby/verify"
+	"google.golang.org/api/compute/v1"
+)
+
+// InstanceTargets returns a verify.Target for every managed instance in the
+// group, value is the deployed value the instances are expected to report.
+func InstanceTargets(ctx context.Context, projectID, zone, groupName, value string) ([]verify.Target, error) {
+	computeService, err := v1ComputeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	listCtx, span := apiSpan(ctx, "instanceGroupManagers.listManagedInstances", "project", projectID, "zone", zone, "group", groupName)
+	list, err := computeService.InstanceGroupManagers.ListManagedInstances(projectID, zone, groupName).Context(listCtx).Do()
+	span.Finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []verify.Target
+	for _, managed := range list.ManagedInstances {
+		if managed == nil {
+			continue
+		}
+
+		getCtx, span := apiSpan(ctx, "instances.get", "project", projectID, "zone", zone, "instance", lastPart(managed.Instance))
+		instance, err := computeService.Instances.Get(projectID, zone, lastPart(managed.Instance)).Context(getCtx).Do()
+		span.Finish(err)
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, instanceTarget(instance, zone, value))
+	}
+
+	return targets, nil
+}
+
+func instanceTarget(instance *compute.Instance, zone, value string) verify.Target {
+	t := verify.Target{
+		Name:  instance.Name,
+		Zone:  zone,
+		Value: value,
+	}
+
+	for _, ni := range instance.NetworkInterfaces {
+		if t.InternalIP == "" {
+			t.InternalIP = ni.NetworkIP
+		}
+
+		for _, ac := range ni.AccessConfigs {
+			if t.ExternalIP == "" {
+				t.ExternalIP = ac.NatIP
+			}
+		}
+	}
+
+	return t
+}
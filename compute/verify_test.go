@@ -0,0 +1,60 @@
+package compute
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fresh8/rollerderby/verify"
+	"google.golang.org/api/compute/v1"
+)
+
+func TestInstanceTarget(t *testing.T) {
+	tests := []struct {
+		instance *compute.Instance
+		want     verify.Target
+	}{
+		{
+			&compute.Instance{Name: "i-1"},
+			verify.Target{Name: "i-1", Zone: "europe-west1-d", Value: "1.0.1"},
+		},
+		{
+			&compute.Instance{
+				Name: "i-2",
+				NetworkInterfaces: []*compute.NetworkInterface{
+					{NetworkIP: "10.0.0.2"},
+					{NetworkIP: "10.1.0.2", AccessConfigs: []*compute.AccessConfig{{NatIP: "35.0.0.2"}, {NatIP: "35.0.0.3"}}},
+				},
+			},
+			verify.Target{Name: "i-2", Zone: "europe-west1-d", InternalIP: "10.0.0.2", ExternalIP: "35.0.0.2", Value: "1.0.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		got := instanceTarget(tt.instance, "europe-west1-d", "1.0.1")
+		if got != tt.want {
+			t.Errorf("instanceTarget(%v) = %+v, want %+v", tt.instance.Name, got, tt.want)
+		}
+	}
+}
+
+func TestInstanceTargetURL(t *testing.T) {
+	p, err := verify.New("http://{{.InternalIP}}:8080/version?zone={{.Zone}}&name={{.Name}}", "{{.Value}}", verify.MatchExact, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := instanceTarget(&compute.Instance{
+		Name:              "i-1",
+		NetworkInterfaces: []*compute.NetworkInterface{{NetworkIP: "10.0.0.2"}},
+	}, "europe-west1-d", "1.0.1")
+
+	var b strings.Builder
+	if err := p.URL.Execute(&b, target); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "http://10.0.0.2:8080/version?zone=europe-west1-d&name=i-1"
+	if b.String() != want {
+		t.Errorf("URL = %v, want %v", b.String(), want)
+	}
+}
@@ -0,0 +1,80 @@
+package compute
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultVersionTemplate names versions after the deployed key and value.
+const DefaultVersionTemplate = "{{.Key}}-{{.Value}}-{{.Timestamp}}"
+
+// maxNameLen is the maximum length of a GCE resource name.
+const maxNameLen = 63
+
+// VersionData is the data available to a version name template.
+type VersionData struct {
+	Group     string
+	Key       string
+	Value     string
+	Timestamp int64
+}
+
+// VersionName renders tmpl with the deployed key and value and sanitises the
+// result to GCE naming rules. A blank tmpl uses DefaultVersionTemplate.
+func VersionName(tmpl, group, key, value string, now time.Time) (string, error) {
+	if tmpl == "" {
+		tmpl = DefaultVersionTemplate
+	}
+
+	t, err := template.New("version").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	err = t.Execute(&b, VersionData{
+		Group:     group,
+		Key:       key,
+		Value:     value,
+		Timestamp: now.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return sanitiseName(b.String()), nil
+}
+
+// sanitiseName converts s to a valid GCE name matching
+// [a-z]([-a-z0-9]{0,61}[a-z0-9])?. Invalid characters become hyphens and names
+// that are too long are shortened from the middle so both the prefix and the
+// unique suffix are kept.
+func sanitiseName(s string) string {
+	var b strings.Builder
+	lastHyphen := true
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+			lastHyphen = false
+		case !lastHyphen:
+			b.WriteRune('-')
+			lastHyphen = true
+		}
+	}
+
+	name := strings.Trim(b.String(), "-")
+	if name == "" || name[0] < 'a' || name[0] > 'z' {
+		name = "v-" + name
+	}
+
+	if len(name) > maxNameLen {
+		head := (maxNameLen - 1) / 2
+		tail := maxNameLen - 1 - head
+		name = strings.TrimRight(name[:head], "-") + "-" + strings.TrimLeft(name[len(name)-tail:], "-")
+	}
+
+	return strings.TrimRight(name, "-")
+}
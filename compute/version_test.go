@@ -0,0 +1,83 @@
+package compute
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validName is the GCE resource name rule.
+var validName = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+func TestSanitiseName(t *testing.T) {
+	long := strings.Repeat("a", 40) + "-" + strings.Repeat("b", 40)
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"app-version-1-0-1", "app-version-1-0-1"},
+		{"App_Version-1.0.1", "app-version-1-0-1"},
+		{"APP", "app"},
+		{"app version: 1.0.1+build/7", "app-version-1-0-1-build-7"},
+		{"--app..version--", "app-version"},
+		{"héllo wörld", "h-llo-w-rld"},
+		{"1.0.1", "v-1-0-1"},
+		{"-1.0.1", "v-1-0-1"},
+		{"", "v"},
+		{"...", "v"},
+		{long, strings.Repeat("a", 31) + "-" + strings.Repeat("b", 31)},
+		{"1" + long, "v-1" + strings.Repeat("a", 28) + "-" + strings.Repeat("b", 31)},
+		// the cut lands next to a hyphen which is not doubled.
+		{strings.Repeat("a", 30) + "-" + strings.Repeat("c", 20) + "-" + strings.Repeat("b", 30), strings.Repeat("a", 30) + "-" + strings.Repeat("b", 30)},
+	}
+
+	for _, tt := range tests {
+		got := sanitiseName(tt.in)
+		if got != tt.want {
+			t.Errorf("sanitiseName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+		if !validName.MatchString(got) || len(got) > maxNameLen {
+			t.Errorf("sanitiseName(%q) = %q is not a valid name", tt.in, got)
+		}
+	}
+}
+
+func TestVersionName(t *testing.T) {
+	now := time.Unix(1534450340, 0)
+
+	tests := []struct {
+		tmpl  string
+		group string
+		key   string
+		value string
+		want  string
+		err   bool
+	}{
+		{tmpl: "", key: "app_version", value: "1.0.1", want: "app-version-1-0-1-1534450340"},
+		{tmpl: "{{.Group}}-{{.Value}}", group: "API", value: "V2.0.0-RC.1", want: "api-v2-0-0-rc-1"},
+		{tmpl: "{{.Value}}", value: "2.0.0", want: "v-2-0-0"},
+		{tmpl: "{{.Key}}-{{.Value}}-{{.Timestamp}}", key: "app_version", value: strings.Repeat("x", 80), want: "app-version-" + strings.Repeat("x", 19) + "-" + strings.Repeat("x", 20) + "-1534450340"},
+		{tmpl: "{{.Missing}}", err: true},
+		{tmpl: "{{.Value", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := VersionName(tt.tmpl, tt.group, tt.key, tt.value, now)
+		if (err != nil) != tt.err {
+			t.Errorf("VersionName(%q) error %v, want error %v", tt.tmpl, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if got != tt.want {
+			t.Errorf("VersionName(%q) = %q, want %q", tt.tmpl, got, tt.want)
+		}
+		if !validName.MatchString(got) {
+			t.Errorf("VersionName(%q) = %q is not a valid name", tt.tmpl, got)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fresh8/rollerderby/diff"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/redact"
+	"github.com/fresh8/rollerderby/tracing"
+	"google.golang.org/api/compute/v1"
+)
+
+// Watcher polls the common metadata of a project for changes.
+type Watcher struct {
+	ProjectID string
+
+	// Interval is the delay between polls.
+	Interval time.Duration
+
+	// MaxInterval caps the delay as it doubles after each failed poll.
+	MaxInterval time.Duration
+}
+
+// WatchEvent is a change of the metadata fingerprint.
+type WatchEvent struct {
+	Time        time.Time
+	Fingerprint string
+
+	// Since is when the previous fingerprint was last seen, the change was
+	// made between Since and Time.
+	Since time.Time
+
+	// Changes are the added, updated and deleted keys sorted by key.
+	Changes []Change
+}
+
+// Watch polls until ctx is done calling f each time the metadata fingerprint
+// changes. Failed polls are logged and retried with backoff.
+func (w *Watcher) Watch(ctx context.Context, f func(WatchEvent)) error {
+	if w.Interval <= 0 {
+		return fmt.Errorf("Watch interval must be positive")
+	}
+
+	computeService, err := v1ComputeClient()
+	if err != nil {
+		return err
+	}
+
+	project, err := getProject(ctx, computeService, w.ProjectID)
+	if err != nil {
+		return err
+	}
+	last := project.CommonInstanceMetadata
+	lastSeen := time.Now().UTC()
+	logger.Infof(ctx, "watching %v from fingerprint %v", w.ProjectID, last.Fingerprint)
+
+	delay := w.Interval
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+
+		project, err := getProject(ctx, computeService, w.ProjectID)
+		flushErr := tracing.Flush()
+		if flushErr != nil {
+			logger.Verbosef(ctx, "unable to export traces: %v", flushErr)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			delay *= 2
+			if delay > w.MaxInterval {
+				delay = w.MaxInterval
+			}
+			logger.Warnf(ctx, "unable to read %v, retrying in %v: %v", w.ProjectID, delay, err)
+			continue
+		}
+		delay = w.Interval
+
+		meta := project.CommonInstanceMetadata
+		now := time.Now().UTC()
+		logger.Verbosef(ctx, "fingerprint: %v", meta.Fingerprint)
+		if meta.Fingerprint == last.Fingerprint {
+			lastSeen = now
+			continue
+		}
+
+		f(WatchEvent{
+			Time:        now,
+			Fingerprint: meta.Fingerprint,
+			Since:       lastSeen,
+			Changes:     metaChanges(last, meta),
+		})
+		last, lastSeen = meta, now
+	}
+}
+
+// metaChanges returns the keys added, updated and deleted between old and
+// new sorted by key.
+func metaChanges(old, new *compute.Metadata) []Change {
+	var changes []Change
+	for _, item := range new.Items {
+		c := newChange(old, item.Key, itemValue(item), nil)
+		if !c.Unchanged() {
+			changes = append(changes, c)
+		}
+	}
+
+	for _, item := range old.Items {
+		if findItem(new, item.Key) == nil {
+			changes = append(changes, deleteChange(old, item.Key))
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	return changes
+}
+
+// itemValue returns the value of item, blank when it has none.
+func itemValue(item *compute.MetadataItems) string {
+	if item.Value == nil {
+		return ""
+	}
+
+	return *item.Value
+}
+
+// PrintWatchEvent outputs a timestamped line for each change in e with the
+// values r considers secrets masked.
+func PrintWatchEvent(e WatchEvent, r redact.Redactor) {
+	ts := e.Time.Format(time.RFC3339)
+	if len(e.Changes) == 0 {
+		fmt.Printf("%v fingerprint %v changed without a key change\n", ts, e.Fingerprint)
+		return
+	}
+
+	for _, c := range e.Changes {
+		old, new := r.Value(c.Key, c.OldValue), r.Value(c.Key, c.NewValue)
+		switch {
+		case c.Delete:
+			fmt.Printf("%v removed %v (was %v)\n", ts, c.Key, diff.Summary(old))
+		case !c.Exists:
+			fmt.Printf("%v added   %v = %v\n", ts, c.Key, diff.Summary(new))
+		default:
+			fmt.Printf("%v changed %v: %v -> %v\n", ts, c.Key, diff.Summary(old), diff.Summary(new))
+		}
+
+		if !c.Delete && diff.MultiLine(c.OldValue, c.NewValue) && !r.Secret(c.Key, c.OldValue) && !r.Secret(c.Key, c.NewValue) {
+			fmt.Print(indent(diff.Unified(c.Key+" (before)", c.Key+" (after)", c.OldValue, c.NewValue)))
+		}
+	}
+}
+
+// indent prefixes each line of s with two spaces.
+func indent(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, l := range lines {
+		if l != "" {
+			lines[i] = "  " + l
+		}
+	}
+
+	return strings.Join(lines, "")
+}
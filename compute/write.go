@@ -0,0 +1,318 @@
+package compute
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fresh8/rollerderby/backup"
+	"github.com/fresh8/rollerderby/diff"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/redact"
+	"github.com/fresh8/rollerderby/schema"
+	"github.com/fresh8/rollerderby/semver"
+	"google.golang.org/api/compute/v1"
+)
+
+// WriteOptions holds the checks made before metadata is written.
+type WriteOptions struct {
+	// Schemas validates the new value of each written key.
+	Schemas schema.Schemas
+
+	// Force writes values that fail validation after logging a warning.
+	Force bool
+
+	// AllowDowngrade writes a lower version to a semver key after logging a
+	// warning.
+	AllowDowngrade bool
+
+	// Protected lists globs of keys that are refused like ReservedKeys.
+	Protected []string
+
+	// AllowProtected changes reserved and protected keys after logging a
+	// warning.
+	AllowProtected bool
+
+	// AllowEmpty writes a blank value instead of refusing it.
+	AllowEmpty bool
+
+	// Backups stores the current metadata before each write, in the
+	// working directory when nil.
+	Backups *backup.Backups
+
+	// Operator and Reason are recorded in the backup, the reason defaults
+	// to a summary of the changes.
+	Operator string
+	Reason   string
+
+	// Redactor masks secrets in the logged changes.
+	Redactor redact.Redactor
+}
+
+func (o WriteOptions) backups() *backup.Backups {
+	if o.Backups == nil {
+		return &backup.Backups{Store: &backup.Dir{Path: "."}}
+	}
+
+	return o.Backups
+}
+
+// snapshot returns the backup of the metadata of project before changes.
+func snapshot(project *compute.Project, changes []Change, opts WriteOptions) backup.Snapshot {
+	reason := opts.Reason
+	if reason == "" {
+		var summary []string
+		for _, c := range changes {
+			action := "update"
+			if c.Delete {
+				action = "delete"
+			}
+			summary = append(summary, action+" "+c.Key)
+		}
+		reason = strings.Join(summary, ", ")
+	}
+
+	meta := project.CommonInstanceMetadata
+	s := backup.Snapshot{
+		Project:     project.Name,
+		Time:        time.Now(),
+		Fingerprint: meta.Fingerprint,
+		Operator:    opts.Operator,
+		Reason:      reason,
+	}
+	for _, item := range meta.Items {
+		s.Items = append(s.Items, backup.Item{Key: item.Key, Value: itemValue(item)})
+	}
+
+	return s
+}
+
+// check returns an error when key is protected or value breaks the schema for
+// key unless the write is allowed or forced.
+func (o WriteOptions) check(ctx context.Context, key, value string) error {
+	err := o.checkProtected(ctx, key, "write")
+	if err != nil {
+		return err
+	}
+
+	err = o.Schemas.Validate(key, value)
+	if err == nil {
+		return nil
+	}
+
+	if o.Force {
+		logger.Warnf(ctx, "forcing write: %v", err)
+		return nil
+	}
+
+	return err
+}
+
+// DowngradeError is returned when a semver key would move to a lower
+// version.
+type DowngradeError struct {
+	Change Change
+}
+
+func (e *DowngradeError) Error() string {
+	return fmt.Sprintf("%v would be downgraded from %v to %v, allow it explicitly to continue",
+		e.Change.Key, e.Change.OldValue, e.Change.NewValue)
+}
+
+// checkChange returns a *DowngradeError for a downgrade unless it is allowed.
+func (o WriteOptions) checkChange(ctx context.Context, c Change) error {
+	if c.Bump != semver.BumpDowngrade {
+		return nil
+	}
+
+	if o.AllowDowngrade {
+		logger.Warnf(ctx, "allowing downgrade of %v from %v to %v", c.Key, o.Redactor.Value(c.Key, c.OldValue), o.Redactor.Value(c.Key, c.NewValue))
+		return nil
+	}
+
+	return &DowngradeError{Change: c}
+}
+
+// Change is a planned write of a key.
+type Change struct {
+	Key      string
+	OldValue string
+	NewValue string
+
+	// Exists is false when the key is added.
+	Exists bool
+
+	// Delete removes the key, NewValue is blank.
+	Delete bool
+
+	// Bump is the semver bump for keys with a semver schema when both values
+	// are versions, blank otherwise.
+	Bump string
+}
+
+// String returns "key: old -> new", or a unified diff when either value spans
+// multiple lines.
+func (c Change) String() string {
+	if diff.MultiLine(c.OldValue, c.NewValue) {
+		return fmt.Sprintf("%s:\n%s", c.Key, strings.TrimSuffix(c.Diff(), "\n"))
+	}
+
+	old := c.OldValue
+	if !c.Exists {
+		old = "<EMPTY>"
+	}
+
+	newValue := c.NewValue
+	if c.Delete {
+		newValue = "<DELETED>"
+	}
+
+	s := fmt.Sprintf("%s: %s -> %s", c.Key, old, newValue)
+	if c.Bump != "" {
+		s += " (" + c.Bump + ")"
+	}
+
+	return s
+}
+
+// Redacted returns the change like String with the values r considers
+// secrets masked and without their diff.
+func (c Change) Redacted(r redact.Redactor) string {
+	if !r.Secret(c.Key, c.OldValue) && !r.Secret(c.Key, c.NewValue) {
+		return c.String()
+	}
+
+	masked := c
+	masked.OldValue = diff.Summary(r.Value(c.Key, c.OldValue))
+	masked.NewValue = diff.Summary(r.Value(c.Key, c.NewValue))
+
+	return masked.String()
+}
+
+// Diff returns the unified diff of the old and new value.
+func (c Change) Diff() string {
+	return diff.Unified(c.Key+" (current)", c.Key+" (planned)", c.OldValue, c.NewValue)
+}
+
+// newChange returns the change of key to newValue in meta.
+func newChange(meta *compute.Metadata, key, newValue string, schemas schema.Schemas) Change {
+	c := Change{Key: key, NewValue: newValue}
+	item := findItem(meta, key)
+	if item != nil && item.Value != nil {
+		c.OldValue = *item.Value
+		c.Exists = true
+	}
+
+	rule, ok := schemas.Rule(key)
+	if !ok || !rule.Semver || !c.Exists {
+		return c
+	}
+
+	oldVersion, err := semver.Parse(c.OldValue)
+	if err != nil {
+		return c
+	}
+	newVersion, err := semver.Parse(c.NewValue)
+	if err != nil {
+		return c
+	}
+	c.Bump = semver.Bump(oldVersion, newVersion)
+
+	return c
+}
+
+// PlanUpdate returns the change UpdateKey would make without writing it. The
+// error is the one UpdateKey would refuse the change with.
+func PlanUpdate(ctx context.Context, projectID, key, newValue string, opts WriteOptions) (Change, error) {
+	configErrors := validateUpdateParms(projectID, key, newValue, opts.AllowEmpty)
+	if configErrors != nil {
+		return Change{}, configErrors
+	}
+
+	computeService, err := v1ComputeClient()
+	if err != nil {
+		return Change{}, err
+	}
+
+	project, err := getProject(ctx, computeService, projectID)
+	if err != nil {
+		return Change{}, err
+	}
+
+	c := newChange(project.CommonInstanceMetadata, key, newValue, opts.Schemas)
+	err = opts.check(ctx, key, newValue)
+	if err != nil {
+		return c, err
+	}
+
+	err = opts.checkChange(ctx, c)
+	if err != nil {
+		return c, err
+	}
+
+	return c, checkLimits(project.CommonInstanceMetadata, c)
+}
+
+// newChanges returns the changes setting values in meta sorted by key.
+func newChanges(meta *compute.Metadata, values map[string]string, schemas schema.Schemas) []Change {
+	var changes []Change
+	for k, v := range values {
+		changes = append(changes, newChange(meta, k, v, schemas))
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+
+	return changes
+}
+
+// deleteChange returns the deletion of key from meta.
+func deleteChange(meta *compute.Metadata, key string) Change {
+	c := Change{Key: key, Exists: true, Delete: true}
+	item := findItem(meta, key)
+	if item != nil && item.Value != nil {
+		c.OldValue = *item.Value
+	}
+
+	return c
+}
+
+// Unchanged reports whether the change leaves the key as it is.
+func (c Change) Unchanged() bool {
+	return !c.Delete && c.Exists && c.OldValue == c.NewValue
+}
+
+// PrintChanges outputs a table of planned changes followed by the diff of
+// each multi-line value. Values r considers secrets are masked and their
+// diffs left out.
+func PrintChanges(changes []Change, r redact.Redactor) {
+	fmt.Printf("%-30.30s | %-25.25s | %-25.25s | %s\n", "key", "current", "planned", "change")
+	fmt.Printf("%s\n", strings.Repeat("=", 30+25+25+10+3*3))
+	for _, c := range changes {
+		kind := c.Bump
+		switch {
+		case c.Delete:
+			kind = "delete"
+		case !c.Exists:
+			kind = "add"
+		case c.OldValue == c.NewValue:
+			kind = "unchanged"
+		case kind == "":
+			kind = "update"
+		}
+		fmt.Printf("%-30.30s | %-25.25s | %-25.25s | %s\n", c.Key,
+			diff.Summary(r.Value(c.Key, c.OldValue)), diff.Summary(r.Value(c.Key, c.NewValue)), kind)
+	}
+
+	for _, c := range changes {
+		if !diff.MultiLine(c.OldValue, c.NewValue) || c.OldValue == c.NewValue {
+			continue
+		}
+
+		if r.Secret(c.Key, c.OldValue) || r.Secret(c.Key, c.NewValue) {
+			fmt.Printf("\n%s: diff of secret value not shown\n", c.Key)
+			continue
+		}
+		fmt.Printf("\n%s", c.Diff())
+	}
+}
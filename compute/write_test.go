@@ -0,0 +1,34 @@
+package compute
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fresh8/rollerderby/redact"
+)
+
+func TestChangeRedacted(t *testing.T) {
+	r := redact.New(nil, nil)
+
+	tests := []struct {
+		change Change
+		want   string
+		hidden string
+	}{
+		{Change{Key: "app_version", OldValue: "1.0.0", NewValue: "1.0.1", Exists: true}, "app_version: 1.0.0 -> 1.0.1", ""},
+		{Change{Key: "db_password", OldValue: "hunter2", NewValue: "hunter3", Exists: true}, "db_password: ****** hmac:", "hunter"},
+		{Change{Key: "db_password", NewValue: "hunter3"}, "db_password: <EMPTY> -> ****** hmac:", "hunter"},
+		{Change{Key: "db_password", OldValue: "hunter2", Exists: true, Delete: true}, "db_password: ****** hmac:", "hunter"},
+		{Change{Key: "tls_secret", OldValue: "a\nb\n", NewValue: "a\nc\n", Exists: true}, "tls_secret: ****** hmac:", "\n"},
+	}
+
+	for _, tt := range tests {
+		got := tt.change.Redacted(r)
+		if !strings.HasPrefix(got, tt.want) {
+			t.Errorf("Redacted(%v) = %q, want prefix %q", tt.change.Key, got, tt.want)
+		}
+		if tt.hidden != "" && strings.Contains(got, tt.hidden) {
+			t.Errorf("Redacted(%v) = %q shows %q", tt.change.Key, got, tt.hidden)
+		}
+	}
+}
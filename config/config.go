@@ -0,0 +1,307 @@
+// Package config loads the optional rollerderby JSON configuration file.
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultAuditSize is the number of entries retained in a metadata audit ring
+// when no size is configured.
+const DefaultAuditSize = 50
+
+// DefaultBackupKeep is the number of backups kept per project unless
+// configured otherwise.
+const DefaultBackupKeep = 50
+
+// Config is the root of the rollerderby configuration file.
+type Config struct {
+	Audit  Audit  `json:"audit"`
+	Backup Backup `json:"backup"`
+	Notify Notify `json:"notify"`
+
+	// Hooks maps a deploy stage to the commands run for it.
+	Hooks map[string][]Hook `json:"hooks"`
+
+	Metrics Metrics `json:"metrics"`
+	Tracing Tracing `json:"tracing"`
+	Server  Server  `json:"server"`
+
+	// Keys maps a metadata key, or a glob such as *_version, to the rule
+	// its values must satisfy.
+	Keys map[string]KeyRule `json:"keys"`
+
+	// ProtectedKeys lists globs of keys that, like the GCE reserved keys,
+	// are only changed with an explicit override.
+	ProtectedKeys []string `json:"protected_keys"`
+
+	Redact Redact `json:"redact"`
+	Limits Limits `json:"limits"`
+
+	// Projects holds per environment settings keyed by project ID that
+	// replace the top level sections when present.
+	Projects map[string]Project `json:"projects"`
+}
+
+// Project holds the settings for a single environment.
+type Project struct {
+	Notify *Notify           `json:"notify"`
+	Hooks  map[string][]Hook `json:"hooks"`
+}
+
+// Metrics configures where deploy metrics are pushed.
+type Metrics struct {
+	// Pushgateway is the base URL of a Pushgateway compatible endpoint that
+	// receives the metrics at the end of each run, blank disables pushing.
+	Pushgateway string `json:"pushgateway"`
+
+	// Job is the job label metrics are pushed under, defaults to rollerderby.
+	Job string `json:"job"`
+}
+
+// Tracing configures where spans are exported.
+type Tracing struct {
+	// Endpoint is the base URL of an OTLP/HTTP collector, spans are posted
+	// to Endpoint/v1/traces. Defaults to OTEL_EXPORTER_OTLP_ENDPOINT, blank
+	// disables tracing.
+	Endpoint string `json:"endpoint"`
+
+	// Headers are sent with each export, for example for authentication.
+	Headers map[string]string `json:"headers"`
+
+	// ServiceName is reported as the service.name resource attribute,
+	// defaults to rollerderby.
+	ServiceName string `json:"service_name"`
+}
+
+// KeyRule constrains the values written to a key.
+type KeyRule struct {
+	// Regex must match the whole value.
+	Regex string `json:"regex"`
+
+	// Semver requires a semantic version.
+	Semver bool `json:"semver"`
+
+	// Enum lists the allowed values.
+	Enum []string `json:"enum"`
+
+	// MaxLength is the maximum value length in bytes.
+	MaxLength int `json:"max_length"`
+
+	// Format is json or yaml to require a well formed document.
+	Format string `json:"format"`
+}
+
+// Redact configures which values are masked when metadata is listed or
+// compared.
+type Redact struct {
+	// Keys lists globs of keys whose values are always masked, defaults to
+	// *_token, *password* and *secret*.
+	Keys []string `json:"keys"`
+
+	// Allow lists globs of keys never masked even when their value looks
+	// like a secret.
+	Allow []string `json:"allow"`
+
+	// KeyFile holds the key of the hash in masks, it is generated when
+	// missing. Defaults to ~/.rollerderby/redact.key.
+	KeyFile string `json:"key_file"`
+}
+
+// Limits configures warnings about the GCE metadata limits.
+type Limits struct {
+	// WarnPercent is the usage of the total or single value size limit at
+	// which -meta warns, defaults to 80 and 0 disables the warning.
+	WarnPercent int `json:"warn_percent"`
+}
+
+// Server configures the API served by the serve command.
+type Server struct {
+	// Tokens maps a caller name to the environment variable holding its
+	// bearer token so tokens are kept out of the configuration file. The name
+	// is recorded as the audit user of the callers rollouts.
+	Tokens map[string]string `json:"tokens"`
+
+	Webhooks Webhooks `json:"webhooks"`
+}
+
+// Webhooks configures the Git webhook receivers of the API.
+type Webhooks struct {
+	// GitHubSecretEnv names the environment variable holding the secret
+	// GitHub deliveries are signed with, blank disables the receiver.
+	GitHubSecretEnv string `json:"github_secret_env"`
+
+	// GitLabTokenEnv names the environment variable holding the secret token
+	// sent with GitLab deliveries, blank disables the receiver.
+	GitLabTokenEnv string `json:"gitlab_token_env"`
+
+	Rules []WebhookRule `json:"rules"`
+}
+
+// WebhookRule deploys pushes to a repository with a matching ref.
+type WebhookRule struct {
+	// Repository is the owner/name or group/project path of the repository.
+	Repository string `json:"repository"`
+
+	// Ref is a glob such as refs/tags/v* matched against the pushed ref.
+	Ref string `json:"ref"`
+
+	Project       string   `json:"project"`
+	Zone          string   `json:"zone"`
+	Group         string   `json:"group"`
+	Key           string   `json:"key"`
+	HealthTimeout Duration `json:"health_timeout"`
+	Rollback      bool     `json:"rollback"`
+	VerifyURL     string   `json:"verify_url"`
+}
+
+// Hook is an external command run at a deploy stage.
+type Hook struct {
+	Command []string `json:"command"`
+	Timeout Duration `json:"timeout"`
+}
+
+// HooksFor returns the hooks for projectID.
+func (c *Config) HooksFor(projectID string) map[string][]Hook {
+	p, ok := c.Projects[projectID]
+	if ok && p.Hooks != nil {
+		return p.Hooks
+	}
+
+	return c.Hooks
+}
+
+// Duration is a time.Duration written as a string such as "90s" or "5m".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration, err = time.ParseDuration(s)
+	return err
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Notify configures where rollout notifications are sent.
+type Notify struct {
+	// Webhooks receive each event as a JSON document.
+	Webhooks []string `json:"webhooks"`
+
+	// Slack incoming webhook URLs receive a text summary of each event.
+	Slack []string `json:"slack"`
+
+	Email *Email `json:"email"`
+}
+
+// Email configures SMTP notifications.
+type Email struct {
+	// Addr is the host:port of the SMTP server.
+	Addr     string   `json:"addr"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username"`
+
+	// PasswordEnv names the environment variable holding the SMTP password
+	// so it is kept out of the configuration file.
+	PasswordEnv string `json:"password_env"`
+}
+
+// NotifyFor returns the notification settings for projectID.
+func (c *Config) NotifyFor(projectID string) Notify {
+	p, ok := c.Projects[projectID]
+	if ok && p.Notify != nil {
+		return *p.Notify
+	}
+
+	return c.Notify
+}
+
+// Audit configures where audit entries are recorded.
+type Audit struct {
+	// File is the path of a local JSONL audit log, blank disables it.
+	File string `json:"file"`
+
+	// MetadataKey is the common metadata key used to store a bounded ring of
+	// audit entries in the project, blank disables it.
+	MetadataKey string `json:"metadata_key"`
+
+	// MetadataSize is the maximum number of entries kept in the ring.
+	MetadataSize int `json:"metadata_size"`
+}
+
+// Backup configures where the metadata is backed up before each write.
+type Backup struct {
+	// Dir is the local directory backups are written to below a directory
+	// per project, defaults to ~/.rollerderby/backups.
+	Dir string `json:"dir"`
+
+	// Bucket is a Cloud Storage bucket backups are written to instead of
+	// Dir.
+	Bucket string `json:"bucket"`
+
+	// Prefix is prepended to the object names in Bucket.
+	Prefix string `json:"prefix"`
+
+	// Keep is the number of backups kept per project, 0 keeps every one.
+	Keep int `json:"keep"`
+
+	// MaxAge removes backups older than it, unset keeps them regardless of
+	// age.
+	MaxAge Duration `json:"max_age"`
+}
+
+// Default returns the configuration used when no file is specified.
+func Default() *Config {
+	var c Config
+	c.Metrics.Job = "rollerderby"
+	c.Tracing.Endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	c.Tracing.ServiceName = "rollerderby"
+	c.Limits.WarnPercent = 80
+	c.Backup.Keep = DefaultBackupKeep
+	home := os.Getenv("HOME")
+	if home != "" {
+		c.Audit.File = filepath.Join(home, ".rollerderby", "audit.jsonl")
+		c.Backup.Dir = filepath.Join(home, ".rollerderby", "backups")
+		c.Redact.KeyFile = filepath.Join(home, ".rollerderby", "redact.key")
+	}
+
+	return &c
+}
+
+// Load reads the configuration file at path. A blank path returns Default.
+func Load(path string) (*Config, error) {
+	c := Default()
+	if path == "" {
+		return c, nil
+	}
+
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	err = json.NewDecoder(r).Decode(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Audit.MetadataKey != "" && c.Audit.MetadataSize <= 0 {
+		c.Audit.MetadataSize = DefaultAuditSize
+	}
+
+	return c, nil
+}
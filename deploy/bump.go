@@ -0,0 +1,37 @@
+package deploy
+
+import (
+	"context"
+	"time"
+
+	"github.com/fresh8/rollerderby/audit"
+	"github.com/fresh8/rollerderby/compute"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/tracing"
+)
+
+// Bump increments part of the semantic version held in dep.Key, see
+// compute.BumpKey, recording the update like Run. dep.Value is ignored and
+// the new value is returned.
+func (d *Deployer) Bump(ctx context.Context, dep Deployment, part, preID string) (string, error) {
+	ctx, span := tracing.Start(ctx, "deploy.bump", "project", dep.Project, "key", dep.Key, "part", part)
+	ctx = logger.WithFields(ctx, "project", dep.Project, "key", dep.Key)
+
+	start := time.Now()
+	opts := d.writeOptions(dep)
+	opts.Reason = "bump " + part + " " + dep.Key
+	oldValue, newValue, err := compute.BumpKey(ctx, dep.Project, dep.Key, part, preID, opts)
+	span.Finish(err)
+
+	e := d.record(ctx, audit.Entry{
+		Action:    audit.ActionUpdate,
+		Project:   dep.Project,
+		Key:       dep.Key,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+		Overrides: dep.overrides(),
+	}, start, err)
+	observe(e, "")
+
+	return newValue, err
+}
@@ -0,0 +1,525 @@
+// Package deploy coordinates metadata updates and rolling replacements,
+// recording each step to the audit log.
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fresh8/rollerderby/audit"
+	"github.com/fresh8/rollerderby/backup"
+	"github.com/fresh8/rollerderby/compute"
+	"github.com/fresh8/rollerderby/errors"
+	"github.com/fresh8/rollerderby/hooks"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/notify"
+	"github.com/fresh8/rollerderby/redact"
+	"github.com/fresh8/rollerderby/schema"
+	"github.com/fresh8/rollerderby/tracing"
+	"github.com/fresh8/rollerderby/verify"
+)
+
+// Deployment describes a metadata update and an optional rolling replace of
+// an instance group.
+type Deployment struct {
+	Project     string
+	Zone        string
+	Group       string
+	Key         string
+	Value       string
+	MinReadySec int64
+
+	// VersionTemplate names the new instance group version, see
+	// compute.VersionName.
+	VersionTemplate string
+
+	// HealthTimeout enables waiting for the new instances to report HEALTHY
+	// on the backend services using the group when greater than zero.
+	HealthTimeout time.Duration
+
+	// Probe when set verifies each new instance reports the deployed value.
+	Probe *verify.Probe
+
+	// WaitTimeout bounds waiting for every instance to run the new version
+	// before probing when no health check is configured.
+	WaitTimeout time.Duration
+
+	// Waves lists the groups replaced when there is more than one, see
+	// ParseTargets. The groups of a wave are replaced together and each wave
+	// starts once every group of the previous one has been replaced and
+	// passed its checks. Zone and Group are ignored when it is set.
+	Waves [][]Target
+
+	// Parallel is the maximum number of groups of a wave replaced at once,
+	// 0 replaces every group of a wave together.
+	Parallel int
+
+	// FailurePolicy decides what happens to the other groups when one
+	// fails, one of FailurePolicies, defaults to FailFinishInFlight.
+	FailurePolicy string
+
+	// Rollback restores the previous value when the replace fails.
+	Rollback bool
+
+	// Force writes the value even when it fails the key schema.
+	Force bool
+
+	// AllowDowngrade writes a lower version to a semver key.
+	AllowDowngrade bool
+
+	// AllowProtected writes a reserved or protected key.
+	AllowProtected bool
+
+	// AllowEmpty writes a blank value.
+	AllowEmpty bool
+}
+
+// Deployer runs deployments.
+type Deployer struct {
+	Audit   audit.Sink
+	Notify  notify.Notifier
+	Hooks   hooks.Hooks
+	Schemas schema.Schemas
+
+	// Protected lists globs of keys refused like compute.ReservedKeys.
+	Protected []string
+
+	// Redactor masks secrets in printed plans and logged changes.
+	Redactor redact.Redactor
+
+	// Backups stores the metadata before each write, see
+	// compute.WriteOptions.
+	Backups *backup.Backups
+
+	User string
+	Host string
+
+	// mu serialises the audit records of groups replaced together.
+	mu sync.Mutex
+}
+
+// New returns a Deployer that records to sink, sends events to notifier and
+// runs h using the identity of the default credentials.
+func New(sink audit.Sink, notifier notify.Notifier, h hooks.Hooks) *Deployer {
+	return &Deployer{
+		Audit:  sink,
+		Notify: notifier,
+		Hooks:  h,
+		User:   audit.Identity(context.Background()),
+		Host:   audit.Hostname(),
+	}
+}
+
+// writeOptions returns the checks made before the key of dep is written.
+func (d *Deployer) writeOptions(dep Deployment) compute.WriteOptions {
+	return compute.WriteOptions{
+		Schemas:        d.Schemas,
+		Force:          dep.Force,
+		AllowDowngrade: dep.AllowDowngrade,
+		Protected:      d.Protected,
+		AllowProtected: dep.AllowProtected,
+		AllowEmpty:     dep.AllowEmpty,
+		Backups:        d.Backups,
+		Operator:       d.User,
+		Redactor:       d.Redactor,
+	}
+}
+
+// overrides returns the safety checks bypassed by dep for the audit log.
+func (dep Deployment) overrides() []string {
+	var o []string
+	if dep.Force {
+		o = append(o, audit.OverrideForce)
+	}
+	if dep.AllowDowngrade {
+		o = append(o, audit.OverrideAllowDowngrade)
+	}
+	if dep.AllowProtected {
+		o = append(o, audit.OverrideAllowProtected)
+	}
+	if dep.AllowEmpty {
+		o = append(o, audit.OverrideAllowEmpty)
+	}
+
+	return o
+}
+
+// rollout holds the state of a single Run.
+type rollout struct {
+	*Deployer
+	dep      Deployment
+	start    time.Time
+	oldValue string
+
+	// existed is false when the rollout adds the key, so a rollback deletes
+	// it rather than restoring a blank value.
+	existed bool
+
+	// groups are the groups replaced in wave order.
+	groups []*groupResult
+
+	// replacer replaces the group of dep, it is r.replace outside tests.
+	replacer func(ctx context.Context, dep Deployment, oldValue string) (string, error)
+
+	// mu guards the failures and the stages of groups replaced together.
+	mu       sync.Mutex
+	failures int
+}
+
+// Run updates the key and, when groups are specified, replaces their
+// instances wave by wave. Pre-update and pre-replace hooks abort the deploy
+// when they fail, post-update and post-replace hook failures are logged and
+// notified. A failed group is handled by dep.FailurePolicy. When a replace
+// fails and Rollback is set the previous value is restored, or the key is
+// deleted when the rollout added it, and every group already replaced is
+// replaced again. A summary of the groups is printed when there is more than
+// one.
+func (d *Deployer) Run(ctx context.Context, dep Deployment) error {
+	err := dep.checkPolicy()
+	if err != nil {
+		return err
+	}
+
+	r := d.newRollout(dep)
+	ctx, span := tracing.Start(ctx, "deploy", "project", dep.Project, "group", r.groupNames(), "key", dep.Key)
+	ctx = logger.WithFields(ctx, "project", dep.Project, "group", r.groupNames(), "key", dep.Key)
+
+	err = r.run(ctx)
+	if len(r.groups) > 1 {
+		printSummary(r.groups)
+	}
+
+	if err != nil {
+		r.event(ctx, dep.Group, notify.EventFailure, err.Error())
+
+		hookErr := r.hook(ctx, r.failed(), hooks.OnFailure, "", err)
+		if hookErr != nil {
+			logger.Warnf(ctx, "%v", hookErr)
+		}
+
+		if dep.Rollback && r.replaceFailed() {
+			r.rollback(ctx)
+		}
+		span.Finish(err)
+		return err
+	}
+
+	r.event(ctx, dep.Group, notify.EventSuccess, "")
+	span.Finish(nil)
+	return nil
+}
+
+// newRollout returns the rollout of dep with its groups in wave order.
+func (d *Deployer) newRollout(dep Deployment) *rollout {
+	r := &rollout{Deployer: d, dep: dep, start: time.Now()}
+	r.replacer = r.replace
+	for i, wave := range dep.waves() {
+		for _, t := range wave {
+			g := &groupResult{Target: t, wave: i, dep: dep}
+			g.dep.Zone, g.dep.Group, g.dep.Waves = t.Zone, t.Group, nil
+			r.groups = append(r.groups, g)
+		}
+	}
+
+	return r
+}
+
+// run sends the start event and executes the hooks and stages.
+func (r *rollout) run(ctx context.Context) error {
+	// the start event and hooks receive the current value as the old one,
+	// the update returns it again as read when written.
+	var err error
+	r.oldValue, r.existed, err = compute.GetKey(ctx, r.dep.Project, r.dep.Key)
+	r.event(ctx, r.dep.Group, notify.EventStart, "")
+	if err != nil {
+		return err
+	}
+
+	err = r.hook(ctx, r.dep, hooks.PreUpdate, "", nil)
+	if err != nil {
+		return err
+	}
+
+	change, err := r.update(ctx, r.dep, r.writeOptions(r.dep))
+	if err != nil {
+		return err
+	}
+	r.oldValue, r.existed = change.OldValue, change.Exists
+
+	r.postHook(ctx, r.dep, hooks.PostUpdate, "")
+
+	if len(r.groups) == 0 {
+		return nil
+	}
+	r.event(ctx, r.dep.Group, notify.EventProgress, "metadata updated")
+
+	return r.replaceWaves(ctx)
+}
+
+func (r *rollout) update(ctx context.Context, dep Deployment, opts compute.WriteOptions) (compute.Change, error) {
+	ctx, span := tracing.Start(ctx, "deploy.update", "key", dep.Key)
+	start := time.Now()
+	change, err := compute.UpdateKey(ctx, dep.Project, dep.Key, dep.Value, opts)
+	span.Finish(err)
+	e := r.record(ctx, audit.Entry{
+		Action:    audit.ActionUpdate,
+		Project:   dep.Project,
+		Key:       dep.Key,
+		OldValue:  change.OldValue,
+		NewValue:  dep.Value,
+		Overrides: dep.overrides(),
+	}, start, err)
+	observe(e, dep.Group)
+
+	return change, err
+}
+
+// remove deletes the key of dep, recording the deletion.
+func (r *rollout) remove(ctx context.Context, dep Deployment, opts compute.WriteOptions) error {
+	ctx, span := tracing.Start(ctx, "deploy.delete", "key", dep.Key)
+	start := time.Now()
+	oldValue, err := compute.DeleteKey(ctx, dep.Project, dep.Key, opts)
+	span.Finish(err)
+	e := r.record(ctx, audit.Entry{
+		Action:    audit.ActionDelete,
+		Project:   dep.Project,
+		Key:       dep.Key,
+		OldValue:  oldValue,
+		Overrides: dep.overrides(),
+	}, start, err)
+	observe(e, dep.Group)
+
+	return err
+}
+
+func (r *rollout) replace(ctx context.Context, dep Deployment, oldValue string) (string, error) {
+	ctx, span := tracing.Start(ctx, "deploy.replace", "group", dep.Group)
+	start := time.Now()
+	version, err := compute.VersionName(dep.VersionTemplate, dep.Group, dep.Key, dep.Value, start)
+	if err == nil {
+		span.Attrs["version"] = version
+		err = r.checkedReplace(ctx, dep, version)
+	}
+	span.Finish(err)
+	e := r.record(ctx, audit.Entry{
+		Action:   audit.ActionReplace,
+		Project:  dep.Project,
+		Zone:     dep.Zone,
+		Group:    dep.Group,
+		Key:      dep.Key,
+		OldValue: oldValue,
+		NewValue: dep.Value,
+		Version:  version,
+	}, start, err)
+	observe(e, dep.Group)
+
+	return version, err
+}
+
+// checkedReplace replaces the group and runs the configured health and
+// verification checks against the new version.
+func (r *rollout) checkedReplace(ctx context.Context, dep Deployment, version string) error {
+	err := compute.RollingReplace(ctx, dep.Project, dep.Zone, dep.Group, version, dep.MinReadySec)
+	if err != nil {
+		return err
+	}
+	r.milestone(ctx, dep, "replacing instances with version "+version)
+
+	if dep.HealthTimeout > 0 {
+		healthCtx, span := tracing.Start(ctx, "deploy.health", "group", dep.Group)
+		err = compute.WaitHealthy(healthCtx, dep.Project, dep.Zone, dep.Group, version, dep.HealthTimeout)
+		span.Finish(err)
+		if err != nil {
+			return err
+		}
+		r.milestone(ctx, dep, "instances healthy")
+	} else if dep.Probe != nil || len(r.groups) > 1 {
+		// the next wave only starts once this group has been replaced.
+		waitCtx, span := tracing.Start(ctx, "deploy.wait", "group", dep.Group)
+		_, err = compute.WaitForVersion(waitCtx, dep.Project, dep.Zone, dep.Group, version, time.Now().Add(dep.WaitTimeout))
+		span.Finish(err)
+		if err != nil {
+			return err
+		}
+		r.milestone(ctx, dep, "instances running version "+version)
+	}
+
+	if dep.Probe != nil {
+		err = probe(ctx, dep)
+		if err != nil {
+			return err
+		}
+		r.milestone(ctx, dep, "instances verified")
+	}
+
+	return nil
+}
+
+// rollback restores the old value, or deletes the key when the rollout added
+// it, and replaces every group whose replace was started again, one at a time
+// and without running checks.
+func (r *rollout) rollback(ctx context.Context) {
+	revert := r.dep
+	revert.Value = r.oldValue
+	revert.HealthTimeout = 0
+	revert.Probe = nil
+
+	// a blank previous value was accepted when it was written.
+	revert.AllowEmpty = r.existed && r.oldValue == ""
+
+	ctx, span := tracing.Start(ctx, "deploy.rollback", "key", r.dep.Key)
+
+	// report the reverted change from the failed value back to the old one,
+	// which was accepted when it was written so it is not validated again.
+	opts := r.writeOptions(revert)
+	opts.Schemas = nil
+	opts.Reason = "rollback " + r.dep.Key
+	var err error
+	if r.existed {
+		_, err = r.update(ctx, revert, opts)
+	} else {
+		err = r.remove(ctx, revert, opts)
+	}
+	if err == nil {
+		// a group that fails to roll back does not stop the others.
+		var errs errors.Errors
+		for _, g := range r.groups {
+			if !g.started {
+				continue
+			}
+
+			group := revert
+			group.Zone, group.Group, group.Waves = g.Zone, g.Group, nil
+			groupCtx := ctx
+			if len(r.groups) > 1 {
+				groupCtx = logger.WithFields(ctx, "zone", g.Zone, "group", g.Group)
+			}
+
+			_, replaceErr := r.replacer(groupCtx, group, r.dep.Value)
+			if replaceErr != nil {
+				errs = append(errs, fmt.Errorf("group %v: %v", g.Target, replaceErr))
+			}
+		}
+		if errs != nil {
+			err = errs
+		}
+	}
+	span.Finish(err)
+
+	msg := "restored previous value"
+	if !r.existed {
+		msg = "deleted the added key"
+	}
+	if err != nil {
+		msg = "rollback failed: " + err.Error()
+	}
+	r.Notify.Notify(ctx, r.newEvent(notify.EventRollback, msg, r.dep.Group, r.dep.Value, r.oldValue))
+}
+
+func probe(ctx context.Context, dep Deployment) (err error) {
+	ctx, span := tracing.Start(ctx, "deploy.verify", "group", dep.Group)
+	defer func() { span.Finish(err) }()
+
+	targets, err := compute.InstanceTargets(ctx, dep.Project, dep.Zone, dep.Group, dep.Value)
+	if err != nil {
+		return err
+	}
+
+	results, err := dep.Probe.Run(ctx, targets)
+	printMu.Lock()
+	verify.Print(results)
+	printMu.Unlock()
+
+	return err
+}
+
+// hook runs the hooks for stage with the zone and group of dep.
+func (r *rollout) hook(ctx context.Context, dep Deployment, stage, version string, deployErr error) error {
+	if len(r.Hooks[stage]) == 0 {
+		return nil
+	}
+
+	c := hooks.Context{
+		Stage:    stage,
+		Project:  dep.Project,
+		Zone:     dep.Zone,
+		Group:    dep.Group,
+		Key:      dep.Key,
+		OldValue: r.Redactor.Value(dep.Key, r.oldValue),
+		NewValue: r.Redactor.Value(dep.Key, dep.Value),
+		Version:  version,
+		Operator: r.User,
+	}
+	if deployErr != nil {
+		c.Error = deployErr.Error()
+	}
+
+	_, span := tracing.Start(ctx, "hook."+stage, "group", dep.Group)
+	err := r.Hooks.Run(c)
+	span.Finish(err)
+
+	return err
+}
+
+// postHook runs the hooks for a post- stage, a failure is logged and notified
+// but does not fail the deploy as the stage already happened.
+func (r *rollout) postHook(ctx context.Context, dep Deployment, stage, version string) {
+	err := r.hook(ctx, dep, stage, version, nil)
+	if err != nil {
+		logger.Warnf(ctx, "%v", err)
+		r.event(ctx, dep.Group, notify.EventProgress, err.Error())
+	}
+}
+
+func (r *rollout) event(ctx context.Context, group, eventType, msg string) {
+	r.Notify.Notify(ctx, r.newEvent(eventType, msg, group, r.oldValue, r.dep.Value))
+}
+
+// newEvent returns an event of the rollout with secret values masked.
+func (r *rollout) newEvent(eventType, msg, group, oldValue, newValue string) notify.Event {
+	return notify.Event{
+		Type:     eventType,
+		Time:     time.Now().UTC(),
+		Project:  r.dep.Project,
+		Group:    group,
+		Key:      r.dep.Key,
+		OldValue: r.Redactor.Value(r.dep.Key, oldValue),
+		NewValue: r.Redactor.Value(r.dep.Key, newValue),
+		Duration: time.Since(r.start),
+		Operator: r.User,
+		Message:  msg,
+	}
+}
+
+// record completes e with the deployer identity, timing and outcome, masks
+// its secret values and writes it to the audit sink.
+func (d *Deployer) record(ctx context.Context, e audit.Entry, start time.Time, err error) audit.Entry {
+	e.OldValue = d.Redactor.Value(e.Key, e.OldValue)
+	e.NewValue = d.Redactor.Value(e.Key, e.NewValue)
+	e.Time = start.UTC()
+	e.TraceID = tracing.TraceIDFromContext(ctx)
+	e.User = d.User
+	e.Host = d.Host
+	e.Duration = time.Since(start)
+	e.Outcome = audit.OutcomeSuccess
+	if err != nil {
+		e.Outcome = audit.OutcomeFailure
+		e.Error = err.Error()
+	}
+
+	if d.Audit == nil {
+		return e
+	}
+
+	// an audit failure is reported but does not fail the deploy.
+	d.mu.Lock()
+	auditErr := d.Audit.Record(ctx, e)
+	d.mu.Unlock()
+	if auditErr != nil {
+		logger.Warnf(ctx, "unable to record audit entry: %v", auditErr)
+	}
+
+	return e
+}
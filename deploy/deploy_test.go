@@ -0,0 +1,197 @@
+package deploy
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fresh8/rollerderby/audit"
+	"github.com/fresh8/rollerderby/backup"
+	"github.com/fresh8/rollerderby/hooks"
+	"github.com/fresh8/rollerderby/notify"
+	"github.com/fresh8/rollerderby/redact"
+	"github.com/fresh8/rollerderby/semver"
+)
+
+// recordingAudit keeps the entries recorded in memory.
+type recordingAudit struct {
+	entries []audit.Entry
+}
+
+func (a *recordingAudit) Record(ctx context.Context, e audit.Entry) error {
+	a.entries = append(a.entries, e)
+	return nil
+}
+
+func (a *recordingAudit) Query(ctx context.Context, f audit.Filter) ([]audit.Entry, error) {
+	return a.entries, nil
+}
+
+// recordingSink keeps the events notified in memory.
+type recordingSink struct {
+	events []notify.Event
+}
+
+func (s *recordingSink) Notify(e notify.Event) error {
+	s.events = append(s.events, e)
+	return nil
+}
+
+func TestRunMasksSecrets(t *testing.T) {
+	const oldSecret, newSecret = "old-secret-value", "new-secret-value"
+	f := newFakeCompute(t, map[string]map[string]string{"p": {"api_token": oldSecret}})
+
+	out := filepath.Join(t.TempDir(), "hooks")
+	hook := hooks.Hook{Command: []string{"sh", "-c", `env >> "$0"; cat >> "$0"`, out}}
+	a := &recordingAudit{}
+	s := &recordingSink{}
+	d := &Deployer{
+		Audit:    a,
+		Notify:   notify.Notifier{s},
+		Hooks:    hooks.Hooks{hooks.PreUpdate: {hook}, hooks.PostUpdate: {hook}},
+		Redactor: redact.New(nil, nil),
+		Backups:  &backup.Backups{Store: &backup.Dir{Path: t.TempDir()}},
+		User:     "tester",
+	}
+
+	err := d.Run(context.Background(), Deployment{Project: "p", Key: "api_token", Value: newSecret})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := f.value("p", "api_token"); got != newSecret {
+		t.Fatalf("deployed value %q, want %q", got, newSecret)
+	}
+
+	if len(s.events) == 0 || s.events[0].Type != notify.EventStart || s.events[0].OldValue == "" {
+		t.Errorf("first event %+v, want a start event with the masked old value", s.events)
+	}
+
+	if len(a.entries) == 0 || len(s.events) == 0 {
+		t.Fatalf("got %d audit entries and %d events, want some of each", len(a.entries), len(s.events))
+	}
+	entries, err := json.Marshal(a.entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := json.Marshal(s.events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hookOut, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(hookOut), "ROLLERDERBY_NEW_VALUE=******") {
+		t.Errorf("hook environment has no masked new value:\n%s", hookOut)
+	}
+
+	sinks := map[string]string{"audit": string(entries), "notify": string(events), "hooks": string(hookOut)}
+	for name, got := range sinks {
+		for _, secret := range []string{oldSecret, newSecret} {
+			if strings.Contains(got, secret) {
+				t.Errorf("%v sink received secret %q:\n%s", name, secret, got)
+			}
+		}
+	}
+}
+
+func TestBump(t *testing.T) {
+	tests := []struct {
+		value string
+		part  string
+		want  string
+		err   bool
+	}{
+		{value: "1.2.3", part: semver.BumpMinor, want: "1.3.0"},
+		{value: "v1.2.3", part: semver.BumpMajor, want: "v2.0.0"},
+		{value: "1.2.4-rc.1", part: semver.BumpPrerelease, want: "1.2.4-rc.2"},
+		{value: "latest", part: semver.BumpPatch, err: true},
+	}
+
+	for _, tt := range tests {
+		f := newFakeCompute(t, map[string]map[string]string{"p": {"app_version": tt.value}})
+		a := &recordingAudit{}
+		d := &Deployer{
+			Audit:   a,
+			Backups: &backup.Backups{Store: &backup.Dir{Path: t.TempDir()}},
+			User:    "tester",
+		}
+
+		got, err := d.Bump(context.Background(), Deployment{Project: "p", Key: "app_version"}, tt.part, "")
+		if (err != nil) != tt.err {
+			t.Errorf("Bump(%v, %v) error %v, want error %v", tt.value, tt.part, err, tt.err)
+			continue
+		}
+
+		if len(a.entries) != 1 {
+			t.Errorf("Bump(%v, %v) recorded %d audit entries, want 1", tt.value, tt.part, len(a.entries))
+			continue
+		}
+		e := a.entries[0]
+		if err != nil {
+			if e.Outcome != audit.OutcomeFailure || f.value("p", "app_version") != tt.value {
+				t.Errorf("refused Bump(%v, %v) recorded %v and left %q", tt.value, tt.part, e.Outcome, f.value("p", "app_version"))
+			}
+			continue
+		}
+
+		if got != tt.want || f.value("p", "app_version") != tt.want {
+			t.Errorf("Bump(%v, %v) = %q wrote %q, want %q", tt.value, tt.part, got, f.value("p", "app_version"), tt.want)
+		}
+		if e.Action != audit.ActionUpdate || e.OldValue != tt.value || e.NewValue != tt.want || e.Outcome != audit.OutcomeSuccess {
+			t.Errorf("Bump(%v, %v) recorded %+v", tt.value, tt.part, e)
+		}
+	}
+}
+
+func TestRunHookFailures(t *testing.T) {
+	fail := []hooks.Hook{{Command: []string{"sh", "-c", "exit 1"}}}
+
+	tests := []struct {
+		name  string
+		hooks hooks.Hooks
+		err   bool
+		value string
+	}{
+		{name: "pre-update aborts", hooks: hooks.Hooks{hooks.PreUpdate: fail}, err: true, value: "1.0.0"},
+		{name: "post-update warns", hooks: hooks.Hooks{hooks.PostUpdate: fail}, value: "1.0.1"},
+	}
+
+	for _, tt := range tests {
+		f := newFakeCompute(t, map[string]map[string]string{"p": {"app_version": "1.0.0"}})
+		s := &recordingSink{}
+		d := &Deployer{
+			Notify:  notify.Notifier{s},
+			Hooks:   tt.hooks,
+			Backups: &backup.Backups{Store: &backup.Dir{Path: t.TempDir()}},
+		}
+
+		err := d.Run(context.Background(), Deployment{Project: "p", Key: "app_version", Value: "1.0.1"})
+		if (err != nil) != tt.err {
+			t.Errorf("%v: Run error %v, want error %v", tt.name, err, tt.err)
+		}
+		if got := f.value("p", "app_version"); got != tt.value {
+			t.Errorf("%v: value %q, want %q", tt.name, got, tt.value)
+		}
+
+		var types []string
+		hookEvent := false
+		for _, e := range s.events {
+			types = append(types, e.Type)
+			if strings.Contains(e.Message, "hook sh: exit status 1") {
+				hookEvent = true
+			}
+		}
+		want := notify.EventSuccess
+		if tt.err {
+			want = notify.EventFailure
+		}
+		if len(types) == 0 || types[len(types)-1] != want || !hookEvent {
+			t.Errorf("%v: events %v, want the hook failure and %v last", tt.name, types, want)
+		}
+	}
+}
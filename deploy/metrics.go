@@ -0,0 +1,31 @@
+package deploy
+
+import (
+	"github.com/fresh8/rollerderby/audit"
+	"github.com/fresh8/rollerderby/metrics"
+)
+
+var (
+	updatesTotal = metrics.Default.NewCounter("rollerderby_metadata_updates_total",
+		"Common metadata key updates.", "project", "group", "outcome")
+	updateSeconds = metrics.Default.NewHistogram("rollerderby_metadata_update_duration_seconds",
+		"Duration of common metadata key updates.", metrics.DefaultBuckets, "project", "group", "outcome")
+	rolloutsTotal = metrics.Default.NewCounter("rollerderby_rollouts_total",
+		"Rolling replacements of instance groups including their checks.", "project", "group", "outcome")
+	rolloutSeconds = metrics.Default.NewHistogram("rollerderby_rollout_duration_seconds",
+		"Duration of rolling replacements of instance groups including their checks.", metrics.DefaultBuckets, "project", "group", "outcome")
+)
+
+// observe instruments a completed audit entry, group labels the update with
+// the group being deployed.
+func observe(e audit.Entry, group string) {
+	seconds := e.Duration.Seconds()
+	switch e.Action {
+	case audit.ActionUpdate, audit.ActionDelete:
+		updatesTotal.Inc(e.Project, group, e.Outcome)
+		updateSeconds.Observe(seconds, e.Project, group, e.Outcome)
+	case audit.ActionReplace:
+		rolloutsTotal.Inc(e.Project, group, e.Outcome)
+		rolloutSeconds.Observe(seconds, e.Project, group, e.Outcome)
+	}
+}
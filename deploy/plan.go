@@ -0,0 +1,44 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fresh8/rollerderby/compute"
+)
+
+// Plan prints the change Run would make to the key and groups without making
+// it, returning the error Run would refuse the change with.
+func (d *Deployer) Plan(ctx context.Context, dep Deployment) error {
+	err := dep.checkPolicy()
+	if err != nil {
+		return err
+	}
+
+	c, err := compute.PlanUpdate(ctx, dep.Project, dep.Key, dep.Value, d.writeOptions(dep))
+	if c.Key == "" {
+		return err
+	}
+	compute.PrintChanges([]compute.Change{c}, d.Redactor)
+
+	waves := dep.waves()
+	if len(waves) > 0 {
+		fmt.Println()
+	}
+	for i, wave := range waves {
+		for _, t := range wave {
+			version, versionErr := compute.VersionName(dep.VersionTemplate, t.Group, dep.Key, dep.Value, time.Now())
+			if versionErr != nil {
+				return versionErr
+			}
+
+			if len(waves) > 1 || len(wave) > 1 {
+				fmt.Printf("wave %d: ", i+1)
+			}
+			fmt.Printf("replace group %v in %v with version %v\n", t.Group, t.Zone, version)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,34 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fresh8/rollerderby/audit"
+	"github.com/fresh8/rollerderby/tracing"
+)
+
+// Reveal records that the unmasked metadata values of projectID are about to
+// be shown. Unlike deploys the error is returned when the entry cannot be
+// recorded so secrets are never shown without a record.
+func (d *Deployer) Reveal(ctx context.Context, projectID string) error {
+	if d.Audit == nil {
+		return nil
+	}
+
+	err := d.Audit.Record(ctx, audit.Entry{
+		Time:    time.Now().UTC(),
+		User:    d.User,
+		Host:    d.Host,
+		Action:  audit.ActionReveal,
+		Project: projectID,
+		Outcome: audit.OutcomeSuccess,
+		TraceID: tracing.TraceIDFromContext(ctx),
+	})
+	if err != nil {
+		return fmt.Errorf("refusing to reveal values, unable to record audit entry: %v", err)
+	}
+
+	return nil
+}
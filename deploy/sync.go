@@ -0,0 +1,65 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/fresh8/rollerderby/audit"
+	"github.com/fresh8/rollerderby/compute"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/tracing"
+)
+
+// Sync makes the keys of dep.Project managed by so equal desired in a single
+// write, see compute.Sync, recording an update or delete for each changed
+// key. dep.Key and dep.Value are ignored.
+func (d *Deployer) Sync(ctx context.Context, dep Deployment, desired map[string]string, so compute.SyncOptions) ([]compute.Change, error) {
+	ctx, span := tracing.Start(ctx, "deploy.sync", "project", dep.Project, "keys", strconv.Itoa(len(desired)))
+	ctx = logger.WithFields(ctx, "project", dep.Project)
+
+	start := time.Now()
+	changes, err := compute.Sync(ctx, dep.Project, desired, so, d.writeOptions(dep))
+	span.Finish(err)
+
+	for _, c := range changes {
+		action := audit.ActionUpdate
+		if c.Delete {
+			action = audit.ActionDelete
+		}
+
+		e := d.record(ctx, audit.Entry{
+			Action:    action,
+			Project:   dep.Project,
+			Key:       c.Key,
+			OldValue:  c.OldValue,
+			NewValue:  c.NewValue,
+			Overrides: dep.overrides(),
+		}, start, err)
+		observe(e, "")
+	}
+
+	return changes, err
+}
+
+// Drift returns the changes Sync would make to dep.Project with the same
+// schemas and protected keys, see compute.Drift.
+func (d *Deployer) Drift(ctx context.Context, dep Deployment, desired map[string]string, so compute.SyncOptions) ([]compute.Change, error) {
+	return compute.Drift(ctx, dep.Project, desired, so, d.writeOptions(dep))
+}
+
+// PlanSync prints the changes Sync would make without making them, returning
+// the error Sync would refuse them with.
+func (d *Deployer) PlanSync(ctx context.Context, dep Deployment, desired map[string]string, so compute.SyncOptions) error {
+	changes, err := compute.PlanSync(ctx, dep.Project, desired, so, d.writeOptions(dep))
+	if len(changes) == 0 {
+		if err == nil {
+			fmt.Println("no changes")
+		}
+		return err
+	}
+	compute.PrintChanges(changes, d.Redactor)
+
+	return err
+}
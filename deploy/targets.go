@@ -0,0 +1,60 @@
+package deploy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Target is an instance group replaced by a rollout.
+type Target struct {
+	Zone  string
+	Group string
+}
+
+func (t Target) String() string {
+	return t.Zone + "/" + t.Group
+}
+
+// ParseTargets parses groups separated by commas into waves replaced in that
+// order, groups joined by + form a single wave replaced together. A group is
+// in zone unless given as zone/group. For example canary,api+worker replaces
+// canary and then api and worker together.
+func ParseTargets(s, zone string) ([][]Target, error) {
+	var waves [][]Target
+	seen := make(map[Target]bool)
+	for _, w := range strings.Split(s, ",") {
+		var wave []Target
+		for _, g := range strings.Split(w, "+") {
+			t := Target{Zone: zone, Group: strings.TrimSpace(g)}
+			i := strings.Index(t.Group, "/")
+			if i >= 0 {
+				t.Zone, t.Group = t.Group[:i], t.Group[i+1:]
+			}
+
+			if t.Zone == "" || t.Group == "" || strings.Contains(t.Group, "/") {
+				return nil, fmt.Errorf("invalid target group %q in %q, want group or zone/group", g, s)
+			}
+			if seen[t] {
+				return nil, fmt.Errorf("target group %v is listed more than once", t)
+			}
+			seen[t] = true
+			wave = append(wave, t)
+		}
+		waves = append(waves, wave)
+	}
+
+	return waves, nil
+}
+
+// waves returns the groups replaced by dep, Waves or the single Group.
+func (dep Deployment) waves() [][]Target {
+	if len(dep.Waves) > 0 {
+		return dep.Waves
+	}
+
+	if dep.Group != "" {
+		return [][]Target{{{Zone: dep.Zone, Group: dep.Group}}}
+	}
+
+	return nil
+}
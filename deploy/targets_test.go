@@ -0,0 +1,45 @@
+package deploy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTargets(t *testing.T) {
+	tests := []struct {
+		s    string
+		want [][]Target
+		err  bool
+	}{
+		{s: "api", want: [][]Target{{{"z1", "api"}}}},
+		{s: "canary,api+worker", want: [][]Target{{{"z1", "canary"}}, {{"z1", "api"}, {"z1", "worker"}}}},
+		{s: "a, b + c ,d", want: [][]Target{{{"z1", "a"}}, {{"z1", "b"}, {"z1", "c"}}, {{"z1", "d"}}}},
+		{s: "z2/api,api", want: [][]Target{{{"z2", "api"}}, {{"z1", "api"}}}},
+		{s: "canary,z2/api+z3/api", want: [][]Target{{{"z1", "canary"}}, {{"z2", "api"}, {"z3", "api"}}}},
+		{s: "", err: true},
+		{s: "api,", err: true},
+		{s: "api++worker", err: true},
+		{s: "/api", err: true},
+		{s: "z2/", err: true},
+		{s: "z2/a/b", err: true},
+		{s: "api,api", err: true},
+		{s: "api+z1/api", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTargets(tt.s, "z1")
+		if (err != nil) != tt.err {
+			t.Errorf("ParseTargets(%q) error %v, want error %v", tt.s, err, tt.err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTargets(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+
+	_, err := ParseTargets("api", "")
+	if err == nil {
+		t.Errorf("ParseTargets without a zone succeeded")
+	}
+}
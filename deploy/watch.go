@@ -0,0 +1,113 @@
+package deploy
+
+import (
+	"context"
+	"time"
+
+	"github.com/fresh8/rollerderby/audit"
+	"github.com/fresh8/rollerderby/compute"
+	"github.com/fresh8/rollerderby/hooks"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/notify"
+	"github.com/fresh8/rollerderby/tracing"
+)
+
+// attributionSlack widens the window in which an audit entry is matched to a
+// change, as an entry is timed from the start of the write.
+const attributionSlack = time.Minute
+
+// Watch prints each change to the metadata polled by w until ctx is done,
+// running the on-change hooks and sending a change event for every changed
+// key. Secret values are masked by d.Redactor in the hooks and events too.
+// The operator of a change is taken from a matching audit entry, see
+// changedBy. Hook failures are logged and do not stop the watch.
+func (d *Deployer) Watch(ctx context.Context, w *compute.Watcher) error {
+	return w.Watch(ctx, func(e compute.WatchEvent) {
+		compute.PrintWatchEvent(e, d.Redactor)
+		entries := d.recentEntries(ctx, w.ProjectID, e)
+
+		for _, c := range e.Changes {
+			operator := d.changedBy(entries, c)
+			oldValue := d.Redactor.Value(c.Key, c.OldValue)
+			newValue := d.Redactor.Value(c.Key, c.NewValue)
+
+			msg := "updated"
+			if c.Delete {
+				msg = "deleted"
+				newValue = ""
+			} else if !c.Exists {
+				msg = "added"
+			}
+
+			d.Notify.Notify(ctx, notify.Event{
+				Type:     notify.EventChange,
+				Time:     e.Time,
+				Project:  w.ProjectID,
+				Key:      c.Key,
+				OldValue: oldValue,
+				NewValue: newValue,
+				Operator: operator,
+				Message:  msg,
+			})
+
+			if len(d.Hooks[hooks.OnChange]) == 0 {
+				continue
+			}
+
+			_, span := tracing.Start(ctx, "hook."+hooks.OnChange, "key", c.Key)
+			err := d.Hooks.Run(hooks.Context{
+				Stage:    hooks.OnChange,
+				Project:  w.ProjectID,
+				Key:      c.Key,
+				OldValue: oldValue,
+				NewValue: newValue,
+				Operator: operator,
+			})
+			span.Finish(err)
+			if err != nil {
+				logger.Warnf(ctx, "%v", err)
+			}
+		}
+	})
+}
+
+// recentEntries returns the audit entries of project recorded while the
+// change of e could have been made, none when they cannot be read.
+func (d *Deployer) recentEntries(ctx context.Context, project string, e compute.WatchEvent) []audit.Entry {
+	if d.Audit == nil {
+		return nil
+	}
+
+	entries, err := d.Audit.Query(ctx, audit.Filter{Since: e.Since.Add(-attributionSlack)})
+	if err != nil {
+		logger.Warnf(ctx, "unable to read audit entries to attribute changes: %v", err)
+		return nil
+	}
+
+	var result []audit.Entry
+	for _, entry := range entries {
+		if entry.Project == project && entry.Outcome == audit.OutcomeSuccess {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+// changedBy returns the user of the latest entry that made c, or marks the
+// operator unknown as the watch only observed the change. Entries hold masked
+// values so the new value of c is masked to compare them.
+func (d *Deployer) changedBy(entries []audit.Entry, c compute.Change) string {
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Key != c.Key {
+			continue
+		}
+
+		if (c.Delete && entry.Action == audit.ActionDelete) || (!c.Delete && entry.Action == audit.ActionUpdate && entry.NewValue == d.Redactor.Value(c.Key, c.NewValue)) {
+			return entry.User
+		}
+	}
+
+	return "unknown (observed by " + d.User + ")"
+}
@@ -0,0 +1,334 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fresh8/rollerderby/errors"
+	"github.com/fresh8/rollerderby/hooks"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/notify"
+)
+
+const (
+	// FailAbortAll stops waiting on the groups in flight when a group fails
+	// and starts no more.
+	FailAbortAll = "abort-all"
+
+	// FailFinishInFlight lets the groups in flight finish when a group fails
+	// and starts no more.
+	FailFinishInFlight = "finish-in-flight"
+
+	// FailContinueOthers replaces every other group, including later waves,
+	// when a group fails.
+	FailContinueOthers = "continue-others"
+)
+
+// FailurePolicies lists the policies for the other groups when one fails.
+var FailurePolicies = []string{FailAbortAll, FailFinishInFlight, FailContinueOthers}
+
+// printMu keeps the tables printed by groups replaced together apart.
+var printMu sync.Mutex
+
+// groupResult is the outcome of replacing one group of a rollout.
+type groupResult struct {
+	Target
+	wave int
+
+	// dep is the rollout deployment with the zone and group of the target.
+	dep Deployment
+
+	version  string
+	duration time.Duration
+	err      error
+
+	// started is set once the replace is attempted and replaceFailed when
+	// the replace or its checks failed rather than a hook. aborted is set
+	// when the group was stopped because another group failed.
+	started       bool
+	replaceFailed bool
+	aborted       bool
+
+	// stage is the last milestone of the group and finished is set once it
+	// has an outcome, both are guarded by rollout.mu.
+	stage    string
+	finished bool
+}
+
+func (g *groupResult) outcome() string {
+	switch {
+	case g.aborted:
+		return "aborted"
+	case g.err != nil:
+		return "failure"
+	case !g.started:
+		return "skipped"
+	}
+
+	return "success"
+}
+
+// checkPolicy returns an error for an unknown failure policy or limit.
+func (dep Deployment) checkPolicy() error {
+	if dep.Parallel < 0 {
+		return fmt.Errorf("parallel groups cannot be negative, got %d", dep.Parallel)
+	}
+
+	if dep.FailurePolicy == "" {
+		return nil
+	}
+
+	for _, p := range FailurePolicies {
+		if p == dep.FailurePolicy {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown failure policy %q, want one of %v", dep.FailurePolicy, FailurePolicies)
+}
+
+// replaceWaves replaces the groups wave by wave with at most dep.Parallel
+// groups replacing at once, applying the failure policy when one fails.
+func (r *rollout) replaceWaves(ctx context.Context) error {
+	// cancelling groupCtx aborts the groups in flight.
+	groupCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	waves := r.dep.waves()
+	last := 0
+	for i := range waves {
+		if r.halted() {
+			break
+		}
+		last = i
+		r.replaceWave(groupCtx, cancel, i)
+	}
+
+	var errs errors.Errors
+	for _, g := range r.groups {
+		if g.err == nil || g.aborted {
+			continue
+		}
+
+		if len(r.groups) == 1 {
+			return g.err
+		}
+		errs = append(errs, fmt.Errorf("group %v: %v", g.Target, g.err))
+	}
+
+	switch {
+	case errs == nil:
+		return nil
+	case r.dep.FailurePolicy == FailContinueOthers:
+		return fmt.Errorf("%d of %d groups failed: %v", len(errs), len(r.groups), errs)
+	}
+
+	return fmt.Errorf("rollout halted in wave %d of %d: %v", last+1, len(waves), errs)
+}
+
+// replaceWave replaces the groups of wave i in order as slots free up.
+func (r *rollout) replaceWave(ctx context.Context, cancel func(), i int) {
+	var wave []*groupResult
+	for _, g := range r.groups {
+		if g.wave == i {
+			wave = append(wave, g)
+		}
+	}
+
+	limit := r.dep.Parallel
+	if limit == 0 || limit > len(wave) {
+		limit = len(wave)
+	}
+
+	slots := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for _, g := range wave {
+		slots <- struct{}{}
+		if r.halted() {
+			break
+		}
+
+		wg.Add(1)
+		go func(g *groupResult) {
+			defer wg.Done()
+			r.replaceGroup(ctx, cancel, g)
+			<-slots
+		}(g)
+	}
+	wg.Wait()
+}
+
+// halted reports whether a group failed and the policy starts no more.
+func (r *rollout) halted() bool {
+	if r.dep.FailurePolicy == FailContinueOthers {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failures > 0
+}
+
+// replaceGroup runs the replace hooks and stages for g recording the
+// outcome in g.
+func (r *rollout) replaceGroup(ctx context.Context, cancel func(), g *groupResult) {
+	groupCtx := ctx
+	if len(r.groups) > 1 {
+		groupCtx = logger.WithFields(ctx, "zone", g.Zone, "group", g.Group)
+	}
+	start := time.Now()
+	r.setStage(ctx, g.dep, "starting")
+
+	err := r.hook(groupCtx, g.dep, hooks.PreReplace, "", nil)
+	if err == nil {
+		g.started = true
+		g.version, err = r.replacer(groupCtx, g.dep, r.oldValue)
+		if err != nil {
+			g.replaceFailed = true
+		} else {
+			r.postHook(groupCtx, g.dep, hooks.PostReplace, g.version)
+		}
+	}
+	g.duration = time.Since(start)
+
+	r.mu.Lock()
+	if err != nil && ctx.Err() != nil && r.failures > 0 {
+		g.aborted = true
+		err = fmt.Errorf("aborted after another group failed")
+	} else if err != nil {
+		r.failures++
+		if r.dep.FailurePolicy == FailAbortAll {
+			cancel()
+		}
+	}
+	g.err = err
+	g.finished = true
+	g.stage = g.outcome()
+	line := r.progressLine()
+	r.mu.Unlock()
+
+	if len(r.groups) > 1 {
+		logger.Infof(ctx, "progress: %v", line)
+	}
+}
+
+// milestone sends a progress event for the group of dep and records it as
+// the stage of the group.
+func (r *rollout) milestone(ctx context.Context, dep Deployment, msg string) {
+	r.event(ctx, dep.Group, notify.EventProgress, msg)
+	r.setStage(ctx, dep, msg)
+}
+
+// setStage records stage for the group of dep while it is in flight and logs
+// the progress of every group when there is more than one.
+func (r *rollout) setStage(ctx context.Context, dep Deployment, stage string) {
+	if len(r.groups) < 2 {
+		return
+	}
+
+	r.mu.Lock()
+	changed := false
+	for _, g := range r.groups {
+		if g.Zone == dep.Zone && g.Group == dep.Group && !g.finished {
+			g.stage = stage
+			changed = true
+		}
+	}
+	line := r.progressLine()
+	r.mu.Unlock()
+
+	if changed {
+		logger.Infof(ctx, "progress: %v", line)
+	}
+}
+
+// progressLine summarises the groups with the stage of those in flight, it
+// is called with r.mu held.
+func (r *rollout) progressLine() string {
+	var done, failed, pending int
+	var inFlight []string
+	for _, g := range r.groups {
+		switch {
+		case g.finished:
+			done++
+			if g.err != nil {
+				failed++
+			}
+		case g.stage == "":
+			pending++
+		default:
+			inFlight = append(inFlight, fmt.Sprintf("%v (%v)", g.Target, g.stage))
+		}
+	}
+
+	s := fmt.Sprintf("%d/%d groups done", done, len(r.groups))
+	if failed > 0 {
+		s += fmt.Sprintf(", %d failed", failed)
+	}
+	if len(inFlight) > 0 {
+		s += ", in flight: " + strings.Join(inFlight, ", ")
+	}
+	if pending > 0 {
+		s += fmt.Sprintf(", %d pending", pending)
+	}
+
+	return s
+}
+
+// failed returns the deployment of the first failed group, or of the rollout
+// when no group failed.
+func (r *rollout) failed() Deployment {
+	for _, g := range r.groups {
+		if g.err != nil && !g.aborted {
+			return g.dep
+		}
+	}
+
+	return r.dep
+}
+
+// replaceFailed reports whether the replace or checks of a group failed.
+func (r *rollout) replaceFailed() bool {
+	for _, g := range r.groups {
+		if g.replaceFailed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// groupNames returns the zone/group names of the rollout separated by commas.
+func (r *rollout) groupNames() string {
+	if len(r.groups) == 1 {
+		return r.groups[0].Group
+	}
+
+	var names []string
+	for _, g := range r.groups {
+		names = append(names, g.Target.String())
+	}
+
+	return strings.Join(names, ",")
+}
+
+// printSummary outputs the outcome of each group of a rollout.
+func printSummary(groups []*groupResult) {
+	printMu.Lock()
+	defer printMu.Unlock()
+
+	fmt.Printf("%-4.4s | %-20.20s | %-30.30s | %-7.7s | %-40.40s | %-8.8s | %s\n", "wave", "zone", "group", "outcome", "version", "duration", "error")
+	fmt.Printf("%s\n", strings.Repeat("=", 4+20+30+7+40+8+5+6*3))
+	for _, g := range groups {
+		var msg string
+		if g.err != nil {
+			msg = g.err.Error()
+		}
+
+		fmt.Printf("%-4d | %-20.20s | %-30.30s | %-7.7s | %-40.40s | %-8.8s | %s\n",
+			g.wave+1, g.Zone, g.Group, g.outcome(), g.version, g.duration.Round(time.Second), msg)
+	}
+}
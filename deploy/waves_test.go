@@ -0,0 +1,303 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fresh8/rollerderby/backup"
+)
+
+// fakeReplacer stands in for replacing groups, each replace takes delay
+// unless the rollout context is cancelled first.
+type fakeReplacer struct {
+	mu    sync.Mutex
+	delay time.Duration
+
+	// fail lists the groups whose replace fails after failDelay.
+	fail      map[string]bool
+	failDelay time.Duration
+
+	// started holds zone/group=value for each replace in the order started.
+	started []string
+
+	// finished holds the zone/group of each replace that returned.
+	finished []string
+
+	inFlight    int
+	maxInFlight int
+
+	// waveOf maps a group to its wave so a replace can check every earlier
+	// wave finished before it started.
+	waveOf map[string]int
+	early  []string
+}
+
+func (f *fakeReplacer) replace(ctx context.Context, dep Deployment, oldValue string) (string, error) {
+	target := Target{dep.Zone, dep.Group}.String()
+	f.mu.Lock()
+	for _, done := range f.started {
+		name := strings.SplitN(done, "=", 2)[0]
+		if f.waveOf[name] < f.waveOf[target] && !f.isFinished(name) {
+			f.early = append(f.early, target+" before "+name)
+		}
+	}
+	f.started = append(f.started, target+"="+dep.Value)
+	f.inFlight++
+	if f.inFlight > f.maxInFlight {
+		f.maxInFlight = f.inFlight
+	}
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.inFlight--
+		f.finished = append(f.finished, target)
+		f.mu.Unlock()
+	}()
+
+	delay := f.delay
+	if f.fail[dep.Group] {
+		delay = f.failDelay
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	if f.fail[dep.Group] {
+		return "", fmt.Errorf("%v failed", dep.Group)
+	}
+
+	return "version-" + dep.Group, nil
+}
+
+// isFinished reports whether target returned, it is called with f.mu held.
+func (f *fakeReplacer) isFinished(target string) bool {
+	for _, t := range f.finished {
+		if t == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newWaveRollout returns a rollout of dep replacing its groups with f.
+func newWaveRollout(d *Deployer, dep Deployment, f *fakeReplacer) *rollout {
+	r := d.newRollout(dep)
+	r.replacer = f.replace
+	f.waveOf = make(map[string]int)
+	for _, g := range r.groups {
+		f.waveOf[g.Target.String()] = g.wave
+	}
+
+	return r
+}
+
+// outcomes returns the outcome of each group of r by its zone/group.
+func outcomes(r *rollout) map[string]string {
+	got := make(map[string]string)
+	for _, g := range r.groups {
+		got[g.Target.String()] = g.outcome()
+	}
+
+	return got
+}
+
+// startedTargets returns the zone/group of each replace f started, sorted.
+func startedTargets(f *fakeReplacer) []string {
+	var targets []string
+	for _, s := range f.started {
+		targets = append(targets, strings.SplitN(s, "=", 2)[0])
+	}
+	sort.Strings(targets)
+
+	return targets
+}
+
+func TestReplaceWavesOrder(t *testing.T) {
+	waves, err := ParseTargets("canary,api+worker+z2/api,z2/cron", "z1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeReplacer{delay: 10 * time.Millisecond}
+	r := newWaveRollout(&Deployer{}, Deployment{Project: "p", Key: "k", Value: "v2", Waves: waves}, f)
+	err = r.replaceWaves(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(f.started) != 5 || f.started[0] != "z1/canary=v2" || f.started[4] != "z2/cron=v2" {
+		t.Errorf("started %v, want z1/canary first and z2/cron last", f.started)
+	}
+	if want := []string{"z1/api", "z1/canary", "z1/worker", "z2/api", "z2/cron"}; !reflect.DeepEqual(startedTargets(f), want) {
+		t.Errorf("started %v, want %v", startedTargets(f), want)
+	}
+	if f.early != nil {
+		t.Errorf("groups started before their earlier wave finished: %v", f.early)
+	}
+	if f.maxInFlight != 3 {
+		t.Errorf("%d groups replaced at once, want the 3 of the second wave", f.maxInFlight)
+	}
+
+	for _, g := range r.groups {
+		if g.outcome() != "success" || g.version != "version-"+g.Group {
+			t.Errorf("group %v outcome %v version %v", g.Target, g.outcome(), g.version)
+		}
+	}
+}
+
+func TestReplaceWavesStopsAtFailedWave(t *testing.T) {
+	waves, err := ParseTargets("canary,api+worker,cron", "z1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fakeReplacer{delay: 10 * time.Millisecond, fail: map[string]bool{"api": true}, failDelay: 5 * time.Millisecond}
+	r := newWaveRollout(&Deployer{}, Deployment{Project: "p", Key: "k", Value: "v2", Waves: waves}, f)
+	err = r.replaceWaves(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "wave 2 of 3") || !strings.Contains(err.Error(), "api failed") {
+		t.Errorf("replaceWaves error %v, want the failure of api in wave 2 of 3", err)
+	}
+
+	want := map[string]string{"z1/canary": "success", "z1/api": "failure", "z1/worker": "success", "z1/cron": "skipped"}
+	if got := outcomes(r); !reflect.DeepEqual(got, want) {
+		t.Errorf("outcomes %v, want %v", got, want)
+	}
+	if !r.replaceFailed() || r.failed().Group != "api" {
+		t.Errorf("failed group %v, want api", r.failed().Group)
+	}
+}
+
+func TestRollbackReplacesStartedGroups(t *testing.T) {
+	fc := newFakeCompute(t, map[string]map[string]string{"p": {"app_version": "1.0.0"}})
+	waves, err := ParseTargets("canary,api+worker,cron", "z1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Deployer{Backups: &backup.Backups{Store: &backup.Dir{Path: t.TempDir()}}}
+	dep := Deployment{Project: "p", Key: "app_version", Value: "1.0.1", Waves: waves, Rollback: true}
+	f := &fakeReplacer{fail: map[string]bool{"api": true}, failDelay: 5 * time.Millisecond}
+	r := newWaveRollout(d, dep, f)
+
+	ctx := context.Background()
+	err = r.run(ctx)
+	if err == nil || !r.replaceFailed() {
+		t.Fatalf("run error %v, want the replace of api to fail", err)
+	}
+	if got := fc.value("p", "app_version"); got != "1.0.1" {
+		t.Fatalf("value before the rollback %q, want 1.0.1", got)
+	}
+
+	f.started = nil
+	r.rollback(ctx)
+
+	if got := fc.value("p", "app_version"); got != "1.0.0" {
+		t.Errorf("value after the rollback %q, want 1.0.0", got)
+	}
+
+	// cron never started so it is not replaced again.
+	want := []string{"z1/canary=1.0.0", "z1/api=1.0.0", "z1/worker=1.0.0"}
+	if !reflect.DeepEqual(f.started, want) {
+		t.Errorf("rollback replaced %v, want %v", f.started, want)
+	}
+}
+
+func TestReplaceWaveSlots(t *testing.T) {
+	waves, err := ParseTargets("a+b+c+d+e", "z1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		parallel int
+		want     int
+	}{
+		{0, 5},
+		{1, 1},
+		{2, 2},
+		{9, 5},
+	}
+
+	for _, tt := range tests {
+		f := &fakeReplacer{delay: 20 * time.Millisecond}
+		r := newWaveRollout(&Deployer{}, Deployment{Project: "p", Key: "k", Waves: waves, Parallel: tt.parallel}, f)
+		err := r.replaceWaves(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.maxInFlight != tt.want || len(f.started) != 5 {
+			t.Errorf("parallel %d replaced %d at once and %d in all, want %d at once and 5 in all", tt.parallel, f.maxInFlight, len(f.started), tt.want)
+		}
+		if want := []string{"z1/a=", "z1/b=", "z1/c=", "z1/d=", "z1/e="}; tt.parallel == 1 && !reflect.DeepEqual(f.started, want) {
+			t.Errorf("parallel 1 started %v, want %v", f.started, want)
+		}
+	}
+}
+
+func TestReplaceWavesFailurePolicy(t *testing.T) {
+	// a fails while b is in flight, with two slots c and d of the first wave
+	// and e of the second wave are still pending.
+	waves, err := ParseTargets("a+b+c+d,e", "z1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy string
+		want   map[string]string
+		err    string
+	}{
+		{
+			policy: FailAbortAll,
+			want:   map[string]string{"z1/a": "failure", "z1/b": "aborted", "z1/c": "skipped", "z1/d": "skipped", "z1/e": "skipped"},
+			err:    "rollout halted in wave 1 of 2",
+		},
+		{
+			policy: FailFinishInFlight,
+			want:   map[string]string{"z1/a": "failure", "z1/b": "success", "z1/c": "skipped", "z1/d": "skipped", "z1/e": "skipped"},
+			err:    "rollout halted in wave 1 of 2",
+		},
+		{
+			policy: "",
+			want:   map[string]string{"z1/a": "failure", "z1/b": "success", "z1/c": "skipped", "z1/d": "skipped", "z1/e": "skipped"},
+			err:    "rollout halted in wave 1 of 2",
+		},
+		{
+			policy: FailContinueOthers,
+			want:   map[string]string{"z1/a": "failure", "z1/b": "success", "z1/c": "success", "z1/d": "success", "z1/e": "success"},
+			err:    "1 of 5 groups failed",
+		},
+	}
+
+	for _, tt := range tests {
+		f := &fakeReplacer{delay: 100 * time.Millisecond, fail: map[string]bool{"a": true}, failDelay: 10 * time.Millisecond}
+		dep := Deployment{Project: "p", Key: "k", Waves: waves, Parallel: 2, FailurePolicy: tt.policy}
+		r := newWaveRollout(&Deployer{}, dep, f)
+		err := r.replaceWaves(context.Background())
+		if err == nil || !strings.Contains(err.Error(), tt.err) || !strings.Contains(err.Error(), "a failed") {
+			t.Errorf("policy %q error %v, want %q with the failure of a", tt.policy, err, tt.err)
+		}
+
+		if got := outcomes(r); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("policy %q outcomes %v, want %v", tt.policy, got, tt.want)
+		}
+		if f.maxInFlight > 2 {
+			t.Errorf("policy %q replaced %d groups at once, want at most 2", tt.policy, f.maxInFlight)
+		}
+		if err != nil && strings.Contains(err.Error(), "z1/b") && tt.policy == FailAbortAll {
+			t.Errorf("policy %q reports the aborted group as failed: %v", tt.policy, err)
+		}
+	}
+}
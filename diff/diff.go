@@ -0,0 +1,205 @@
+// Package diff compares multi-line metadata values line by line.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Context is the number of unchanged lines shown around each change.
+const Context = 3
+
+// edit is a line kept, deleted or inserted by the edit script. a and b are
+// the indexes in the old and new lines the edit applies at.
+type edit struct {
+	op   byte
+	line string
+	a, b int
+}
+
+// MultiLine reports whether either value spans more than one line.
+func MultiLine(values ...string) bool {
+	for _, v := range values {
+		if strings.Contains(v, "\n") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Summary returns v, or a line count and size in place of a multi-line value
+// so it fits a table cell.
+func Summary(v string) string {
+	if !MultiLine(v) {
+		return v
+	}
+
+	n := len(lines(v))
+	if len(v) < 1024 {
+		return fmt.Sprintf("<%d lines, %d B>", n, len(v))
+	}
+
+	return fmt.Sprintf("<%d lines, %.1f KiB>", n, float64(len(v))/1024)
+}
+
+// Unified returns the unified diff of old and new labelled oldName and
+// newName, or a blank string when they are equal. Newlines are compared
+// exactly so a missing final newline is reported.
+func Unified(oldName, newName, old, new string) string {
+	if old == new {
+		return ""
+	}
+
+	edits := editScript(lines(old), lines(new))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	i := 0
+	for i < len(edits) {
+		for i < len(edits) && edits[i].op == ' ' {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		// extend the hunk while changes are close enough to share context.
+		last := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].op != ' ' {
+				last = j
+			} else if j-last > 2*Context {
+				break
+			}
+		}
+
+		start := i - Context
+		if start < 0 {
+			start = 0
+		}
+		end := last + Context + 1
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		writeHunk(&b, edits[start:end])
+		i = end
+	}
+
+	return b.String()
+}
+
+func writeHunk(b *strings.Builder, hunk []edit) {
+	var oldLines, newLines int
+	for _, e := range hunk {
+		if e.op != '+' {
+			oldLines++
+		}
+		if e.op != '-' {
+			newLines++
+		}
+	}
+
+	fmt.Fprintf(b, "@@ -%s +%s @@\n", hunkRange(hunk[0].a, oldLines), hunkRange(hunk[0].b, newLines))
+	for _, e := range hunk {
+		b.WriteByte(e.op)
+		b.WriteString(e.line)
+		if !strings.HasSuffix(e.line, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange formats the start line and count of a hunk, an empty range
+// starts at the line before it.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// lines splits s after each newline keeping the newlines.
+func lines(s string) []string {
+	l := strings.SplitAfter(s, "\n")
+	if l[len(l)-1] == "" {
+		l = l[:len(l)-1]
+	}
+
+	return l
+}
+
+// editScript returns the shortest edit script turning a into b using the
+// Myers algorithm.
+func editScript(a, b []string) []edit {
+	n, m := len(a), len(b)
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+2)
+
+	// trace holds v at the start of each round for backtracking.
+	var trace [][]int
+search:
+	for d := 0; d <= max; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var edits []edit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{op: ' ', line: a[x], a: x, b: y})
+		}
+
+		if d > 0 {
+			if x == prevX {
+				edits = append(edits, edit{op: '+', line: b[prevY], a: prevX, b: prevY})
+			} else {
+				edits = append(edits, edit{op: '-', line: a[prevX], a: prevX, b: prevY})
+			}
+		}
+		x, y = prevX, prevY
+	}
+
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+
+	return edits
+}
@@ -0,0 +1,162 @@
+// Package exporter periodically exports fleet gauges for Prometheus to
+// scrape.
+package exporter
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/fresh8/rollerderby/compute"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/metrics"
+	"github.com/fresh8/rollerderby/tracing"
+)
+
+// Exporter collects instance group and metadata gauges for a set of projects.
+type Exporter struct {
+	Projects []string
+	Interval time.Duration
+	Registry *metrics.Registry
+
+	targetSize     *metrics.GaugeVec
+	versions       *metrics.GaugeVec
+	actions        *metrics.GaugeVec
+	fingerprintAge *metrics.GaugeVec
+	up             *metrics.GaugeVec
+
+	// fingerprints holds the last fingerprint seen per project and when it
+	// was first seen.
+	fingerprints map[string]fingerprint
+}
+
+type fingerprint struct {
+	value string
+	seen  time.Time
+}
+
+// New returns an Exporter that collects from projects every interval.
+func New(projects []string, interval time.Duration) *Exporter {
+	r := metrics.NewRegistry()
+	return &Exporter{
+		Projects: projects,
+		Interval: interval,
+		Registry: r,
+
+		targetSize: r.NewGauge("rollerderby_group_target_size",
+			"Target size of the instance group.", "project", "zone", "group"),
+		versions: r.NewGauge("rollerderby_group_instances",
+			"Managed instances in the group by version.", "project", "zone", "group", "version"),
+		actions: r.NewGauge("rollerderby_group_instances_by_action",
+			"Managed instances in the group by current action.", "project", "zone", "group", "action"),
+		fingerprintAge: r.NewGauge("rollerderby_metadata_fingerprint_age_seconds",
+			"Seconds since the common metadata fingerprint was first seen by the exporter.", "project"),
+		up: r.NewGauge("rollerderby_exporter_up",
+			"Whether the last collection for the project succeeded.", "project"),
+
+		fingerprints: make(map[string]fingerprint),
+	}
+}
+
+// ListenAndServe collects in the background and serves /metrics on addr. The
+// spans of each collection are exported once it completes.
+func (e *Exporter) ListenAndServe(addr string) error {
+	go func() {
+		for {
+			e.Collect()
+			err := tracing.Flush()
+			if err != nil {
+				logger.Warnf(context.Background(), "unable to export traces: %v", err)
+			}
+			time.Sleep(e.Interval)
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e.Registry)
+	logger.Infof(context.Background(), "exporting metrics on %v", addr)
+
+	return http.ListenAndServe(addr, mux)
+}
+
+// collection holds the gauge values of a Collect until they replace the
+// previous ones.
+type collection struct {
+	targetSize *metrics.GaugeSet
+	versions   *metrics.GaugeSet
+	actions    *metrics.GaugeSet
+
+	// up and fingerprintAge are by project.
+	up             map[string]float64
+	fingerprintAge map[string]float64
+}
+
+// Collect refreshes every gauge. The group gauges of every project are
+// collected before replacing the previous series at once, so a scrape never
+// sees a partial collection. Projects that fail are logged and reported
+// through rollerderby_exporter_up.
+func (e *Exporter) Collect() {
+	c := collection{
+		targetSize:     e.targetSize.NewSet(),
+		versions:       e.versions.NewSet(),
+		actions:        e.actions.NewSet(),
+		up:             make(map[string]float64),
+		fingerprintAge: make(map[string]float64),
+	}
+
+	for _, projectID := range e.Projects {
+		err := e.collect(projectID, c)
+		if err != nil {
+			logger.Warnf(logger.WithFields(context.Background(), "project", projectID), "collecting: %v", err)
+			c.up[projectID] = 0
+			continue
+		}
+		c.up[projectID] = 1
+	}
+
+	e.Registry.Update(func() {
+		e.targetSize.Replace(c.targetSize)
+		e.versions.Replace(c.versions)
+		e.actions.Replace(c.actions)
+		for projectID, v := range c.up {
+			e.up.Set(v, projectID)
+		}
+		for projectID, v := range c.fingerprintAge {
+			e.fingerprintAge.Set(v, projectID)
+		}
+	})
+}
+
+func (e *Exporter) collect(projectID string, c collection) error {
+	ctx := context.Background()
+
+	groups, err := compute.Fleet(ctx, projectID)
+	if err != nil {
+		return err
+	}
+
+	for _, g := range groups {
+		c.targetSize.Set(float64(g.TargetSize), projectID, g.Zone, g.Name)
+		for version, n := range g.Versions {
+			c.versions.Set(float64(n), projectID, g.Zone, g.Name, version)
+		}
+		for action, n := range g.Actions {
+			c.actions.Set(float64(n), projectID, g.Zone, g.Name, action)
+		}
+	}
+
+	value, err := compute.Fingerprint(ctx, projectID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	last, ok := e.fingerprints[projectID]
+	if !ok || last.value != value {
+		last = fingerprint{value: value, seen: now}
+		e.fingerprints[projectID] = last
+	}
+	c.fingerprintAge[projectID] = now.Sub(last.seen).Seconds()
+
+	return nil
+}
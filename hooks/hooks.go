@@ -0,0 +1,128 @@
+// Package hooks runs user commands before and after the stages of a deploy.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const (
+	// PreUpdate runs before the metadata update, a failure aborts the deploy.
+	PreUpdate = "pre-update"
+
+	// PostUpdate runs after the metadata update.
+	PostUpdate = "post-update"
+
+	// PreReplace runs before the rolling replace, a failure aborts the deploy.
+	PreReplace = "pre-replace"
+
+	// PostReplace runs after the rolling replace and its checks succeed.
+	PostReplace = "post-replace"
+
+	// OnFailure runs when any stage of the deploy fails.
+	OnFailure = "on-failure"
+
+	// OnChange runs for each key changed while watching a project.
+	OnChange = "on-change"
+)
+
+// Stages lists the deploy stages in the order they run followed by the watch
+// stage.
+var Stages = []string{PreUpdate, PostUpdate, PreReplace, PostReplace, OnFailure, OnChange}
+
+// DefaultTimeout bounds a hook that does not specify a timeout.
+const DefaultTimeout = 5 * time.Minute
+
+// Hook is an external command.
+type Hook struct {
+	Command []string
+	Timeout time.Duration
+}
+
+// Context describes the deploy a hook runs for. It is passed to the command
+// as JSON on stdin and as ROLLERDERBY_* environment variables.
+type Context struct {
+	Stage    string `json:"stage"`
+	Project  string `json:"project"`
+	Zone     string `json:"zone,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Key      string `json:"key"`
+	OldValue string `json:"old_value,omitempty"`
+	NewValue string `json:"new_value"`
+	Version  string `json:"version,omitempty"`
+	Operator string `json:"operator"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (c Context) env() []string {
+	return []string{
+		"ROLLERDERBY_STAGE=" + c.Stage,
+		"ROLLERDERBY_PROJECT=" + c.Project,
+		"ROLLERDERBY_ZONE=" + c.Zone,
+		"ROLLERDERBY_GROUP=" + c.Group,
+		"ROLLERDERBY_KEY=" + c.Key,
+		"ROLLERDERBY_OLD_VALUE=" + c.OldValue,
+		"ROLLERDERBY_NEW_VALUE=" + c.NewValue,
+		"ROLLERDERBY_VERSION=" + c.Version,
+		"ROLLERDERBY_OPERATOR=" + c.Operator,
+		"ROLLERDERBY_ERROR=" + c.Error,
+	}
+}
+
+// Hooks maps a stage to the commands run in order for it.
+type Hooks map[string][]Hook
+
+// Run executes the hooks for c.Stage in order stopping at the first failure.
+func (h Hooks) Run(c Context) error {
+	for _, hook := range h[c.Stage] {
+		err := hook.Run(c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run executes the command with the deploy context, output is passed through
+// to the rollerderby output.
+func (hook Hook) Run(c Context) error {
+	if len(hook.Command) == 0 {
+		return fmt.Errorf("%v hook command cannot be blank", c.Stage)
+	}
+
+	timeout := hook.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stdin, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = append(os.Environ(), c.env()...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	// hook output is diagnostic so it is kept off stdout.
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%v hook %v timed out after %v", c.Stage, hook.Command[0], timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%v hook %v: %v", c.Stage, hook.Command[0], err)
+	}
+
+	return nil
+}
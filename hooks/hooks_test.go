@@ -0,0 +1,126 @@
+package hooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunContract(t *testing.T) {
+	dir := t.TempDir()
+	env := filepath.Join(dir, "env")
+	stdin := filepath.Join(dir, "stdin")
+	hook := Hook{Command: []string{"sh", "-c", `env > "$0"; cat > "$1"`, env, stdin}}
+
+	c := Context{
+		Stage:    PreReplace,
+		Project:  "p",
+		Zone:     "z1",
+		Group:    "api",
+		Key:      "app_version",
+		OldValue: "1.0.0",
+		NewValue: "it's \"1.0.1\"\nreally",
+		Version:  "app-version-1-0-1",
+		Operator: "dev@example.com",
+	}
+	err := hook.Run(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"ROLLERDERBY_STAGE=pre-replace",
+		"ROLLERDERBY_PROJECT=p",
+		"ROLLERDERBY_ZONE=z1",
+		"ROLLERDERBY_GROUP=api",
+		"ROLLERDERBY_KEY=app_version",
+		"ROLLERDERBY_OLD_VALUE=1.0.0",
+		"ROLLERDERBY_NEW_VALUE=it's \"1.0.1\"\nreally",
+		"ROLLERDERBY_VERSION=app-version-1-0-1",
+		"ROLLERDERBY_OPERATOR=dev@example.com",
+		"ROLLERDERBY_ERROR=\n",
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("hook environment has no %q:\n%s", want, b)
+		}
+	}
+
+	b, err = ioutil.ReadFile(stdin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Context
+	err = json.Unmarshal(b, &got)
+	if err != nil {
+		t.Fatalf("hook stdin %q: %v", b, err)
+	}
+	if got != c {
+		t.Errorf("hook stdin %+v, want %+v", got, c)
+	}
+	if strings.Contains(string(b), "error") {
+		t.Errorf("hook stdin %s has a blank error", b)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		hook Hook
+		err  string
+	}{
+		{"success", Hook{Command: []string{"true"}}, ""},
+		{"exit status", Hook{Command: []string{"sh", "-c", "exit 3"}}, "pre-update hook sh: exit status 3"},
+		{"missing command", Hook{Command: []string{"rollerderby-missing-hook"}}, "pre-update hook rollerderby-missing-hook:"},
+		{"blank command", Hook{}, "pre-update hook command cannot be blank"},
+		{"timeout", Hook{Command: []string{"sleep", "5"}, Timeout: 50 * time.Millisecond}, "pre-update hook sleep timed out after 50ms"},
+	}
+
+	for _, tt := range tests {
+		start := time.Now()
+		err := tt.hook.Run(Context{Stage: PreUpdate})
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%v: %v", tt.name, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%v: error %v, want %q", tt.name, err, tt.err)
+		}
+		if time.Since(start) > 2*time.Second {
+			t.Errorf("%v: took %v", tt.name, time.Since(start))
+		}
+	}
+}
+
+func TestHooksRunStopsAtFailure(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	h := Hooks{PreUpdate: {
+		{Command: []string{"sh", "-c", `echo first >> "$0"`, out}},
+		{Command: []string{"false"}},
+		{Command: []string{"sh", "-c", `echo third >> "$0"`, out}},
+	}}
+
+	err := h.Run(Context{Stage: PreUpdate})
+	if err == nil {
+		t.Errorf("Run succeeded with a failing hook")
+	}
+
+	b, _ := ioutil.ReadFile(out)
+	if string(b) != "first\n" {
+		t.Errorf("hooks ran %q, want only the first", b)
+	}
+
+	err = h.Run(Context{Stage: PostUpdate})
+	if err != nil {
+		t.Errorf("Run of a stage without hooks: %v", err)
+	}
+}
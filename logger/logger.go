@@ -0,0 +1,185 @@
+// Package logger writes levelled diagnostics to stderr as text or JSON so
+// they are kept apart from the user facing output on stdout.
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fresh8/rollerderby/tracing"
+)
+
+// Level orders messages by importance.
+type Level int
+
+const (
+	// LevelDebug includes every Compute API call.
+	LevelDebug Level = iota
+
+	// LevelVerbose includes polling progress and other detail.
+	LevelVerbose
+
+	// LevelInfo is the default and reports what rollerderby is doing.
+	LevelInfo
+
+	// LevelWarn reports failures that do not stop the command.
+	LevelWarn
+
+	// LevelError reports the failure that stopped the command.
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug:   "debug",
+	LevelVerbose: "verbose",
+	LevelInfo:    "info",
+	LevelWarn:    "warn",
+	LevelError:   "error",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+const (
+	// FormatText writes a line per message followed by key=value fields.
+	FormatText = "text"
+
+	// FormatJSON writes a JSON object per message.
+	FormatJSON = "json"
+)
+
+var (
+	mu     sync.Mutex
+	out    io.Writer = os.Stderr
+	level            = LevelInfo
+	format           = FormatText
+)
+
+// SetOutput sets where messages are written, stderr by default.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+}
+
+// SetLevel discards messages below l.
+func SetLevel(l Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	level = l
+}
+
+// SetFormat sets the output format to FormatText or FormatJSON.
+func SetFormat(f string) error {
+	if f != FormatText && f != FormatJSON {
+		return fmt.Errorf("unknown log format %q, want %v or %v", f, FormatText, FormatJSON)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	format = f
+	return nil
+}
+
+type fieldsKey struct{}
+
+// WithFields returns a copy of ctx whose messages include the key value
+// pairs kv in addition to any fields already in ctx.
+func WithFields(ctx context.Context, kv ...string) context.Context {
+	fields := make(map[string]string)
+	for k, v := range fieldsFrom(ctx) {
+		fields[k] = v
+	}
+
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			fields[kv[i]] = kv[i+1]
+		}
+	}
+
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+func fieldsFrom(ctx context.Context) map[string]string {
+	fields, _ := ctx.Value(fieldsKey{}).(map[string]string)
+	return fields
+}
+
+// Debugf logs a debug message.
+func Debugf(ctx context.Context, f string, args ...interface{}) {
+	write(ctx, LevelDebug, fmt.Sprintf(f, args...))
+}
+
+// Verbosef logs a verbose message.
+func Verbosef(ctx context.Context, f string, args ...interface{}) {
+	write(ctx, LevelVerbose, fmt.Sprintf(f, args...))
+}
+
+// Infof logs an info message.
+func Infof(ctx context.Context, f string, args ...interface{}) {
+	write(ctx, LevelInfo, fmt.Sprintf(f, args...))
+}
+
+// Warnf logs a warning.
+func Warnf(ctx context.Context, f string, args ...interface{}) {
+	write(ctx, LevelWarn, fmt.Sprintf(f, args...))
+}
+
+// Errorf logs an error.
+func Errorf(ctx context.Context, f string, args ...interface{}) {
+	write(ctx, LevelError, fmt.Sprintf(f, args...))
+}
+
+// write formats the message with the fields of ctx and the trace ID of its
+// span as trace_id.
+func write(ctx context.Context, l Level, msg string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if l < level {
+		return
+	}
+
+	fields := make(map[string]string)
+	for k, v := range fieldsFrom(ctx) {
+		fields[k] = v
+	}
+	traceID := tracing.TraceIDFromContext(ctx)
+	if traceID != "" {
+		fields["trace_id"] = traceID
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	msg = strings.TrimRight(msg, "\n")
+
+	if format == FormatJSON {
+		fields["time"] = now
+		fields["level"] = l.String()
+		fields["msg"] = msg
+		b, err := json.Marshal(fields)
+		if err != nil {
+			return
+		}
+		fmt.Fprintln(out, string(b))
+		return
+	}
+
+	var keys []string
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	line := fmt.Sprintf("%v %-7v %v", now, strings.ToUpper(l.String()), msg)
+	for _, k := range keys {
+		line += fmt.Sprintf(" %v=%v", k, fields[k])
+	}
+	fmt.Fprintln(out, line)
+}
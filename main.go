@@ -1,12 +1,35 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
-	"log"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/fresh8/rollerderby/audit"
+	"github.com/fresh8/rollerderby/backup"
 	"github.com/fresh8/rollerderby/compute"
+	"github.com/fresh8/rollerderby/config"
+	"github.com/fresh8/rollerderby/deploy"
+	"github.com/fresh8/rollerderby/exporter"
+	"github.com/fresh8/rollerderby/hooks"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/metafile"
+	"github.com/fresh8/rollerderby/metrics"
+	"github.com/fresh8/rollerderby/notify"
+	"github.com/fresh8/rollerderby/redact"
+	"github.com/fresh8/rollerderby/schema"
+	"github.com/fresh8/rollerderby/semver"
+	"github.com/fresh8/rollerderby/server"
+	"github.com/fresh8/rollerderby/tracing"
+	"github.com/fresh8/rollerderby/verify"
 )
 
 // Version is the Git SHA for this application specified at compile time.
@@ -16,14 +39,14 @@ var Version string
 var Source string
 
 func main() {
-	log.SetOutput(os.Stdout)
 	err := exec()
 	if err != nil {
-		log.Fatal(err)
+		logger.Errorf(context.Background(), "%v", err)
+		os.Exit(1)
 	}
 }
 
-func exec() error {
+func exec() (err error) {
 	var key string
 	var newValue string
 	var projectID string
@@ -35,70 +58,921 @@ func exec() error {
 	var listMeta bool
 	var listVersion bool
 	var listGroups bool
+	var configPath = os.Getenv("ROLLERDERBY_CONFIG")
+	var since string
+	var until string
+	var versionTemplate string
+	var healthTimeout time.Duration
+	var waitTimeout time.Duration
+	var verifyURL string
+	var verifyExpect string
+	var verifyMatch string
+	var verifyRetries int
+	var verifyInterval time.Duration
+	var rollback bool
+	var parallel int
+	var failurePolicy string
+	var pushgateway string
+	var listen string
+	var interval time.Duration
+	var quiet bool
+	var verbose bool
+	var debug bool
+	var logFormat = os.Getenv("ROLLERDERBY_LOG_FORMAT")
+	var force bool
+	var allowDowngrade bool
+	var plan bool
+	var allowProtected bool
+	var part string
+	var preID string
+	var reveal bool
+	var valueFile string
+	var format string
+	var keys string
+	var prefix string
+	var prune bool
+	var match string
+	var location string
+	var labels string
+	var yes bool
+	var allowEmpty bool
 
 	flag.StringVar(&projectID, "project", os.Getenv("GOOGLE_PROJECT_ID"), "Google project ID, can be set with this flag or GOOGLE_PROJECT_ID environment variable")
 	flag.StringVar(&key, "key", "", "metadata key to update")
 	flag.StringVar(&newValue, "value", "", "metadata value to set")
+	flag.StringVar(&valueFile, "value-file", "", "read the metadata value to set from this file, - reads stdin, newlines are kept exactly")
 	flag.StringVar(&otherProjectID, "compare", "", "compare this projects meta to the default projects")
-	flag.StringVar(&groupName, "target", "", "target instance group to replace")
+	flag.StringVar(&groupName, "target", "", "target instance group to replace, or groups replaced in order separated by commas with + joining groups replaced together, each group optionally zone/group")
+	flag.StringVar(&match, "match", "", "select instance groups whose name matches this glob, or regex between slashes (e.g. /^svc-api-/), for -groups and rollouts")
+	flag.StringVar(&location, "location", "", "comma separated zones or regions, optionally globs, selecting instance groups for -groups and rollouts")
+	flag.StringVar(&labels, "labels", "", "comma separated key=value labels the instance template must have to select a group for -groups and rollouts")
+	flag.BoolVar(&yes, "yes", false, "replace the groups selected by -match, -location or -labels without asking for confirmation")
 	flag.StringVar(&zoneName, "zone", os.Getenv("GOOGLE_ZONE"), "target instance group to replace")
 	flag.Int64Var(&minReadySec, "ready", 90, "minimum number of seconds to wait before assuming the service is ready")
 	flag.BoolVar(&listMeta, "meta", false, "list projects common metadata key values")
 	flag.BoolVar(&listVersion, "version", false, "output version and exit")
 	flag.BoolVar(&listGroups, "groups", false, "list compute instance groups and exit")
+	flag.StringVar(&configPath, "config", configPath, "configuration file path, can be set with this flag or ROLLERDERBY_CONFIG environment variable")
+	flag.StringVar(&since, "since", "", "history entries at or after this RFC3339 time or duration ago (e.g. 24h)")
+	flag.StringVar(&until, "until", "", "history entries at or before this RFC3339 time or duration ago")
+	flag.StringVar(&versionTemplate, "version-name", compute.DefaultVersionTemplate, "instance group version name template, fields are .Group, .Key, .Value and .Timestamp")
+	flag.DurationVar(&healthTimeout, "health-timeout", 0, "wait up to this long for the new instances to be healthy in the groups backend services, 0 disables the check")
+	flag.DurationVar(&waitTimeout, "wait-timeout", 30*time.Minute, "maximum time to wait for every instance to run the new version before verifying")
+	flag.StringVar(&verifyURL, "verify-url", "", "URL template probed on each new instance (e.g. http://{{.InternalIP}}:8080/version), fields are .Name, .Zone, .InternalIP, .ExternalIP and .Value")
+	flag.StringVar(&verifyExpect, "verify-expect", "{{.Value}}", "expected response body template for -verify-url")
+	flag.StringVar(&verifyMatch, "verify-match", verify.MatchExact, "how the body is compared to -verify-expect: exact, contains or regex")
+	flag.IntVar(&verifyRetries, "verify-retries", 5, "number of retries for each instance probe")
+	flag.DurationVar(&verifyInterval, "verify-interval", 5*time.Second, "delay between instance probe retries")
+	flag.IntVar(&parallel, "parallel", 0, "maximum number of groups of a wave replaced at once, 0 replaces every group of a wave together, defaults to 1 for groups selected by -match, -location or -labels")
+	flag.StringVar(&failurePolicy, "failure-policy", deploy.FailFinishInFlight, "when a group fails: abort-all stops the groups in flight, finish-in-flight lets them finish, continue-others replaces every other group")
+	flag.BoolVar(&rollback, "rollback", false, "restore the previous value and replace the group again when the rollout fails")
+	flag.StringVar(&pushgateway, "pushgateway", "", "Pushgateway URL that receives deploy metrics at the end of the run, overrides the configuration file")
+	flag.StringVar(&listen, "listen", ":9402", "address the exporter and API server listen on")
+	flag.DurationVar(&interval, "interval", time.Minute, "delay between exporter collections and watch polls")
+	flag.BoolVar(&allowDowngrade, "allow-downgrade", false, "write a lower version to a key with a semver schema, the override is audited")
+	flag.BoolVar(&allowEmpty, "allow-empty", false, "write a blank value read from -value-file instead of refusing it, the override is audited")
+	flag.BoolVar(&allowProtected, "allow-protected", false, "write a GCE reserved or configured protected key, the override is audited")
+	flag.BoolVar(&plan, "plan", false, "print the planned change including the semver bump and exit without writing")
+	flag.StringVar(&part, "part", semver.BumpPatch, "version part incremented by bump: major, minor, patch or prerelease")
+	flag.StringVar(&format, "format", "", "export and import format env, json, yaml or shell, defaults to the file extension or env")
+	flag.StringVar(&keys, "keys", "", "comma separated key globs exported or imported, e.g. app_*,db_host")
+	flag.StringVar(&prefix, "prefix", "", "export only keys with this prefix stripping it, import adds it back")
+	flag.BoolVar(&prune, "prune", false, "sync and drift delete keys missing from the desired file, limited by -keys and -prefix")
+	flag.StringVar(&preID, "preid", "", "pre-release identifier used by bump, e.g. rc")
+	flag.BoolVar(&reveal, "reveal", false, "show secret looking values unmasked in -meta and -compare, the reveal is audited")
+	flag.BoolVar(&force, "force", false, "write the value even when it fails the key schema, the override is audited")
+	flag.BoolVar(&quiet, "quiet", false, "only log warnings and errors")
+	flag.BoolVar(&verbose, "verbose", false, "log polling progress and other detail")
+	flag.BoolVar(&debug, "debug", false, "log every Compute API call, implies -verbose")
+	flag.StringVar(&logFormat, "log-format", logFormat, "diagnostic log format text or json, can be set with this flag or ROLLERDERBY_LOG_FORMAT environment variable")
 	flag.Parse()
+	command := parseCommand()
 
-	if zoneName == "" {
-		zoneName = "europe-west1-d"
+	err = setupLogging(quiet, verbose, debug, logFormat)
+	if err != nil {
+		return err
+	}
+
+	sel, err := selector(match, location, labels)
+	if err != nil {
+		return err
+	}
+	if !sel.IsZero() && groupName != "" {
+		return fmt.Errorf("-target cannot be combined with -match, -location or -labels")
+	}
+
+	// the selected groups form a single wave, so they are replaced one at a
+	// time unless asked otherwise rather than the whole fleet at once.
+	if !sel.IsZero() && !flagSet("parallel") {
+		parallel = 1
+	}
+
+	if valueFile != "" {
+		newValue, err = readValue(valueFile, newValue, allowEmpty)
+		if err != nil {
+			return err
+		}
+	}
+
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return err
 	}
+	auditSink := auditSinks(cfg, projectID)
 
-	log.SetFlags(log.LUTC | log.Lshortfile | log.LstdFlags)
-	// for user interactive output remove extended log info
-	if listMeta || otherProjectID != "" || listVersion || listGroups {
-		log.SetFlags(0)
+	if cfg.Tracing.Endpoint != "" {
+		tracing.SetExporter(tracing.NewOTLP(cfg.Tracing.Endpoint, cfg.Tracing.ServiceName, cfg.Tracing.Headers))
+		defer flushTraces()
+	}
+	ctx, span := tracing.Start(context.Background(), "rollerderby", "project", projectID)
+	ctx = logger.WithFields(ctx, "project", projectID)
+	defer func() { span.Finish(err) }()
+
+	if zoneName == "" {
+		zoneName = "europe-west1-d"
 	}
 
 	// output target environment details
-	printConfig(authPath, projectID, Version, Source)
+	printConfig(ctx, authPath, projectID, Version, Source)
 
 	if listVersion {
 		return nil
+	} else if len(command) > 0 && command[0] == "history" {
+		filter := audit.Filter{Key: key, Group: groupName}
+		filter.Since, err = parseTime(since)
+		if err != nil {
+			return err
+		}
+		filter.Until, err = parseTime(until)
+		if err != nil {
+			return err
+		}
+
+		entries, err := auditSink.Query(ctx, filter)
+		if err != nil {
+			return err
+		}
+		audit.Print(entries)
+
+		return nil
+	} else if len(command) > 0 && command[0] == "backups" {
+		if len(command) != 2 || command[1] != "list" {
+			return fmt.Errorf("usage: backups list")
+		}
+
+		b, err := backups(cfg.Backup)
+		if err != nil {
+			return err
+		}
+
+		snapshots, err := b.List(ctx, projectID)
+		if err != nil {
+			return err
+		}
+		backup.Print(snapshots)
+
+		return nil
+	} else if len(command) > 0 && command[0] == "exporter" {
+		projects, err := projectList(projectID)
+		if err != nil {
+			return err
+		}
+
+		return exporter.New(projects, interval).ListenAndServe(listen)
+	} else if len(command) > 0 && command[0] == "watch" {
+		d, err := deployer(cfg, projectID)
+		if err != nil {
+			return err
+		}
+
+		d.Redactor, err = redactor(ctx, cfg, reveal, projectID)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sig
+			logger.Infof(ctx, "stopping watch")
+			cancel()
+		}()
+
+		return d.Watch(ctx, &compute.Watcher{
+			ProjectID:   projectID,
+			Interval:    interval,
+			MaxInterval: 10 * interval,
+		})
+	} else if len(command) > 0 && command[0] == "bump" {
+		d, err := deployer(cfg, projectID)
+		if err != nil {
+			return err
+		}
+
+		if pushgateway != "" {
+			cfg.Metrics.Pushgateway = pushgateway
+		}
+		defer pushMetrics(cfg.Metrics, projectID)
+
+		value, err := d.Bump(ctx, deploy.Deployment{
+			Project:        projectID,
+			Key:            key,
+			Force:          force,
+			AllowDowngrade: allowDowngrade,
+			AllowProtected: allowProtected,
+		}, part, preID)
+		if err != nil {
+			return err
+		}
+		fmt.Println(value)
+
+		return nil
+	} else if len(command) > 0 && command[0] == "export" {
+		r, err := redactor(ctx, cfg, reveal, projectID)
+		if err != nil {
+			return err
+		}
+
+		items, err := compute.Items(ctx, projectID)
+		if err != nil {
+			return err
+		}
+
+		values := make(map[string]string)
+		for _, item := range items {
+			values[item.Key] = r.Value(item.Key, *item.Value)
+		}
+
+		return writeValues(ctx, command[1:], format, metafile.Select(values, globs(keys), prefix))
+	} else if len(command) > 0 && (command[0] == "import" || command[0] == "sync" || command[0] == "drift") {
+		values, err := readValues(command[1:], format)
+		if err != nil {
+			return err
+		}
+
+		values = metafile.Select(metafile.AddPrefix(values, prefix), globs(keys), "")
+		for k, v := range values {
+			if redact.IsMask(v) {
+				return fmt.Errorf("value of %v is masked, export it again with -reveal", k)
+			}
+		}
+
+		// import only adds and updates keys, sync and drift manage every key
+		// selected by -keys and -prefix.
+		var so compute.SyncOptions
+		if command[0] != "import" {
+			so = compute.SyncOptions{Prune: prune, Keys: globs(keys), Prefix: prefix}
+		}
+
+		d, err := deployer(cfg, projectID)
+		if err != nil {
+			return err
+		}
+
+		dep := deploy.Deployment{
+			Project:        projectID,
+			Force:          force,
+			AllowDowngrade: allowDowngrade,
+			AllowProtected: allowProtected,
+		}
+
+		if command[0] == "drift" {
+			changes, err := d.Drift(ctx, dep, values, so)
+			if err != nil {
+				return err
+			}
+			if len(changes) == 0 {
+				logger.Infof(ctx, "%v matches %v", projectID, command[1])
+				return nil
+			}
+			compute.PrintChanges(changes, d.Redactor)
+
+			return fmt.Errorf("%v has drifted from %v by %d keys", projectID, command[1], len(changes))
+		}
+
+		if plan {
+			return d.PlanSync(ctx, dep, values, so)
+		}
+
+		if pushgateway != "" {
+			cfg.Metrics.Pushgateway = pushgateway
+		}
+		defer pushMetrics(cfg.Metrics, projectID)
+
+		changes, err := d.Sync(ctx, dep, values, so)
+		if err != nil {
+			return err
+		}
+		logger.Infof(ctx, "changed %d keys", len(changes))
+
+		return nil
+	} else if len(command) > 0 && command[0] == "serve" {
+		srv, err := apiServer(cfg)
+		if err != nil {
+			return err
+		}
+
+		return srv.ListenAndServe(listen)
+	} else if len(command) > 0 {
+		return fmt.Errorf("unknown command %q", command[0])
 	} else if otherProjectID != "" {
-		keys, err := compute.CompareProjects(projectID, otherProjectID)
+		r, err := redactor(ctx, cfg, reveal, projectID, otherProjectID)
+		if err != nil {
+			return err
+		}
+
+		keys, err := compute.CompareProjects(ctx, projectID, otherProjectID)
 		if err != nil {
 			return err
 		}
-		compute.PrintKeys(keys, projectID, otherProjectID)
+		compute.PrintKeys(keys, projectID, otherProjectID, r)
 
 		return nil
 	} else if listMeta {
-		return compute.ListKeys(projectID)
+		r, err := redactor(ctx, cfg, reveal, projectID)
+		if err != nil {
+			return err
+		}
+
+		return compute.ListKeys(ctx, projectID, r, cfg.Limits.WarnPercent)
+	} else if listGroups && groupName != "" {
+		return compute.DescribeInstanceGroup(ctx, projectID, zoneName, groupName)
 	} else if listGroups {
-		return compute.ListInstanceGroups(projectID)
-	} else if projectID != "" && key != "" && newValue != "" && groupName != "" {
-		err := compute.UpdateKey(projectID, key, newValue)
+		return compute.ListInstanceGroups(ctx, projectID, sel)
+	} else if projectID != "" && key != "" && (newValue != "" || (valueFile != "" && allowEmpty)) {
+		var probe *verify.Probe
+		if verifyURL != "" {
+			probe, err = verify.New(verifyURL, verifyExpect, verifyMatch, verifyRetries, verifyInterval)
+			if err != nil {
+				return err
+			}
+		}
+
+		d, err := deployer(cfg, projectID)
 		if err != nil {
 			return err
 		}
 
 		// TODO (NF 2018-08-15): replace with zone look-up for instance group.
-		return compute.RollingReplace(projectID, zoneName, groupName, minReadySec)
-	} else if projectID != "" && key != "" && newValue != "" {
-		return compute.UpdateKey(projectID, key, newValue)
+		dep := deploy.Deployment{
+			Project:         projectID,
+			Zone:            zoneName,
+			Key:             key,
+			Value:           newValue,
+			MinReadySec:     minReadySec,
+			VersionTemplate: versionTemplate,
+			HealthTimeout:   healthTimeout,
+			Probe:           probe,
+			WaitTimeout:     waitTimeout,
+			Rollback:        rollback,
+			Parallel:        parallel,
+			FailurePolicy:   failurePolicy,
+			Force:           force,
+			AllowDowngrade:  allowDowngrade,
+			AllowProtected:  allowProtected,
+			AllowEmpty:      allowEmpty,
+		}
+
+		if groupName != "" {
+			waves, err := deploy.ParseTargets(groupName, zoneName)
+			if err != nil {
+				return err
+			}
+
+			if len(waves) == 1 && len(waves[0]) == 1 {
+				dep.Zone, dep.Group = waves[0][0].Zone, waves[0][0].Group
+			} else {
+				dep.Waves = waves
+			}
+		}
+
+		if !sel.IsZero() {
+			waves, err := selectTargets(ctx, projectID, sel, !plan && !yes, valueFile == "-")
+			if err != nil {
+				return err
+			}
+
+			if len(waves[0]) == 1 {
+				dep.Zone, dep.Group = waves[0][0].Zone, waves[0][0].Group
+			} else {
+				dep.Waves = waves
+			}
+		}
+
+		if plan {
+			return d.Plan(ctx, dep)
+		}
+
+		if pushgateway != "" {
+			cfg.Metrics.Pushgateway = pushgateway
+		}
+		defer pushMetrics(cfg.Metrics, projectID)
+
+		return d.Run(ctx, dep)
 	}
 
 	return nil
 }
 
-func printConfig(authPath, projectID, version, source string) {
-	log.Println("project:", projectID)
-	log.Println("version:", version)
-	log.Println("source:", source)
-	log.Println("go:", runtime.Version())
-	if authPath != "" {
-		log.Println("auth:", authPath)
+// parseCommand returns the positional command words parsing any flags that
+// follow each of them.
+func parseCommand() []string {
+	var words []string
+	for flag.NArg() > 0 {
+		args := flag.Args()
+		words = append(words, args[0])
+		flag.CommandLine.Parse(args[1:])
+	}
+
+	return words
+}
+
+func auditSinks(cfg *config.Config, projectID string) audit.Multi {
+	var sinks audit.Multi
+	if cfg.Audit.File != "" {
+		sinks = append(sinks, &audit.File{Path: cfg.Audit.File})
+	}
+
+	if cfg.Audit.MetadataKey != "" && projectID != "" {
+		sinks = append(sinks, &compute.MetadataAudit{
+			ProjectID: projectID,
+			Key:       cfg.Audit.MetadataKey,
+			Size:      cfg.Audit.MetadataSize,
+		})
+	}
+
+	return sinks
+}
+
+// deployer returns a Deployer with the audit, notification, hook and key
+// schema settings of cfg for projectID.
+func deployer(cfg *config.Config, projectID string) (*deploy.Deployer, error) {
+	h, err := hookSet(cfg.HooksFor(projectID))
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := schemas(cfg.Keys)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := backups(cfg.Backup)
+	if err != nil {
+		return nil, err
+	}
+
+	d := deploy.New(auditSinks(cfg, projectID), notifier(cfg.NotifyFor(projectID)), h)
+	d.Schemas = s
+	d.Backups = b
+	d.Redactor = newRedactor(cfg)
+	d.Protected = cfg.ProtectedKeys
+	if cfg.Audit.MetadataKey != "" {
+		// the audit ring is only written by rollerderby itself.
+		d.Protected = append(d.Protected, cfg.Audit.MetadataKey)
+	}
+
+	return d, nil
+}
+
+// backups returns where cfg stores the metadata backups, a bucket when one is
+// configured, the directory otherwise and the working directory when neither
+// is set.
+func backups(cfg config.Backup) (*backup.Backups, error) {
+	b := &backup.Backups{Keep: cfg.Keep, MaxAge: cfg.MaxAge.Duration}
+	if cfg.Bucket != "" {
+		store, err := backup.NewGCS(cfg.Bucket, cfg.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		b.Store = store
+
+		return b, nil
+	}
+
+	dir := cfg.Dir
+	if dir == "" {
+		dir = "."
+	}
+	b.Store = &backup.Dir{Path: dir}
+
+	return b, nil
+}
+
+// newRedactor returns the Redactor configured by cfg with the key of
+// cfg.Redact.KeyFile. When the key cannot be loaded a key for the process is
+// used and masks only match within the run.
+func newRedactor(cfg *config.Config) redact.Redactor {
+	r := redact.New(cfg.Redact.Keys, cfg.Redact.Allow)
+	if cfg.Redact.KeyFile == "" {
+		return r
+	}
+
+	key, err := redact.LoadKey(cfg.Redact.KeyFile)
+	if err != nil {
+		logger.Warnf(context.Background(), "unable to load the redact key: %v", err)
+		return r
+	}
+	r.HashKey = key
+
+	return r
+}
+
+// redactor returns the Redactor configured by cfg. When reveal is set the
+// reveal is audited for each of projects before masking is disabled.
+func redactor(ctx context.Context, cfg *config.Config, reveal bool, projects ...string) (redact.Redactor, error) {
+	r := newRedactor(cfg)
+	if !reveal {
+		return r, nil
+	}
+
+	for _, p := range projects {
+		d, err := deployer(cfg, p)
+		if err != nil {
+			return r, err
+		}
+
+		err = d.Reveal(ctx, p)
+		if err != nil {
+			return r, err
+		}
+	}
+	r.Reveal = true
+
+	return r, nil
+}
+
+func schemas(cfg map[string]config.KeyRule) (schema.Schemas, error) {
+	s := make(schema.Schemas)
+	for key, rule := range cfg {
+		s[key] = schema.Rule{
+			Regex:     rule.Regex,
+			Semver:    rule.Semver,
+			Enum:      rule.Enum,
+			MaxLength: rule.MaxLength,
+			Format:    rule.Format,
+		}
+	}
+
+	return s, s.Check()
+}
+
+func notifier(cfg config.Notify) notify.Notifier {
+	var n notify.Notifier
+	for _, url := range cfg.Webhooks {
+		n = append(n, &notify.Webhook{URL: url})
+	}
+
+	for _, url := range cfg.Slack {
+		n = append(n, &notify.Slack{URL: url})
+	}
+
+	if cfg.Email != nil {
+		n = append(n, &notify.Email{
+			Addr:     cfg.Email.Addr,
+			From:     cfg.Email.From,
+			To:       cfg.Email.To,
+			Username: cfg.Email.Username,
+			Password: os.Getenv(cfg.Email.PasswordEnv),
+		})
+	}
+
+	return n
+}
+
+// pushMetrics pushes the deploy metrics when a Pushgateway is configured, a
+// failure is reported but does not fail the deploy.
+func pushMetrics(cfg config.Metrics, projectID string) {
+	if cfg.Pushgateway == "" {
 		return
 	}
 
-	log.Println("auth: <gcloud auth>")
+	err := metrics.Default.Push(cfg.Pushgateway, cfg.Job, "project", projectID)
+	if err != nil {
+		logger.Warnf(context.Background(), "unable to push metrics: %v", err)
+	}
+}
+
+// apiServer returns the API server for cfg, refusing to serve without any
+// tokens or webhook secrets.
+func apiServer(cfg *config.Config) (*server.Server, error) {
+	tokens := make(map[string]string)
+	for name, env := range cfg.Server.Tokens {
+		token := os.Getenv(env)
+		if token == "" {
+			return nil, fmt.Errorf("API token for %v is blank, set %v", name, env)
+		}
+		tokens[token] = name
+	}
+
+	srv := server.New(tokens, func(projectID string) (*deploy.Deployer, error) {
+		return deployer(cfg, projectID)
+	})
+
+	srv.Redactor = newRedactor(cfg)
+
+	webhooks := cfg.Server.Webhooks
+	if webhooks.GitHubSecretEnv != "" {
+		srv.Webhooks.GitHubSecret = os.Getenv(webhooks.GitHubSecretEnv)
+	}
+	if webhooks.GitLabTokenEnv != "" {
+		srv.Webhooks.GitLabToken = os.Getenv(webhooks.GitLabTokenEnv)
+	}
+
+	for _, rule := range webhooks.Rules {
+		req := server.RolloutRequest{
+			Project:  rule.Project,
+			Zone:     rule.Zone,
+			Group:    rule.Group,
+			Key:      rule.Key,
+			Rollback: rule.Rollback,
+		}
+		if rule.HealthTimeout.Duration > 0 {
+			req.HealthTimeout = rule.HealthTimeout.String()
+		}
+		if rule.VerifyURL != "" {
+			req.Verify = &server.VerifyRequest{URL: rule.VerifyURL}
+		}
+
+		srv.Webhooks.Rules = append(srv.Webhooks.Rules, server.Rule{
+			Repository: rule.Repository,
+			Ref:        rule.Ref,
+			Rollout:    req,
+		})
+	}
+
+	if len(tokens) == 0 && srv.Webhooks.GitHubSecret == "" && srv.Webhooks.GitLabToken == "" {
+		return nil, fmt.Errorf("serve requires at least one token in server.tokens or a webhook secret")
+	}
+
+	return srv, nil
+}
+
+// flushTraces exports the spans of the run, a failure is reported but does not
+// fail the command.
+func flushTraces() {
+	err := tracing.Flush()
+	if err != nil {
+		logger.Warnf(context.Background(), "unable to export traces: %v", err)
+	}
+}
+
+func hookSet(cfg map[string][]config.Hook) (hooks.Hooks, error) {
+	h := make(hooks.Hooks)
+	for stage, list := range cfg {
+		if !isStage(stage) {
+			return nil, fmt.Errorf("unknown hook stage %q, want one of %v", stage, hooks.Stages)
+		}
+
+		for _, hook := range list {
+			h[stage] = append(h[stage], hooks.Hook{
+				Command: hook.Command,
+				Timeout: hook.Timeout.Duration,
+			})
+		}
+	}
+
+	return h, nil
+}
+
+func isStage(stage string) bool {
+	for _, s := range hooks.Stages {
+		if s == stage {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readValue returns the contents of path, or stdin when path is -, refusing
+// to combine it with a -value. Blank contents are refused unless allowEmpty
+// is set.
+func readValue(path, value string, allowEmpty bool) (string, error) {
+	if value != "" {
+		return "", fmt.Errorf("-value and -value-file cannot both be set")
+	}
+
+	var b []byte
+	var err error
+	if path == "-" {
+		b, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		b, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(string(b)) == "" && !allowEmpty {
+		if path == "-" {
+			path = "stdin"
+		}
+		return "", fmt.Errorf("-value-file %v is blank, pass -allow-empty to write it", path)
+	}
+
+	return string(b), nil
+}
+
+// writeValues encodes values to the file named by args, or stdout when
+// there is none, in format or the format of the file extension.
+func writeValues(ctx context.Context, args []string, format string, values map[string]string) error {
+	w := os.Stdout
+	path := ""
+	if len(args) > 0 && args[0] != "-" {
+		path = args[0]
+	}
+
+	if format == "" && path == "" {
+		format = metafile.FormatEnv
+	}
+	format, err := metafile.FormatOf(format, path)
+	if err != nil {
+		return err
+	}
+
+	if path != "" {
+		w, err = os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+
+	skipped, err := metafile.Encode(w, format, values)
+	for _, k := range skipped {
+		logger.Warnf(ctx, "skipped %v, it is not a valid variable name", k)
+	}
+
+	return err
+}
+
+// readValues decodes the file named by args, or stdin for -, in format or
+// the format of the file extension.
+func readValues(args []string, format string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("a file is required, - reads stdin")
+	}
+
+	path := args[0]
+	if path == "-" {
+		if format == "" {
+			format = metafile.FormatEnv
+		}
+		return metafile.Decode(os.Stdin, format)
+	}
+
+	format, err := metafile.FormatOf(format, path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return metafile.Decode(r, format)
+}
+
+// globs splits a comma separated list of globs.
+func globs(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(s, ",")
+}
+
+// flagSet reports whether the flag name was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+// projectList splits a comma separated list of projects, refusing a blank
+// list or entry.
+func projectList(s string) ([]string, error) {
+	var projects []string
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			return nil, fmt.Errorf("invalid -project %q, want one or more projects separated by commas", s)
+		}
+		projects = append(projects, p)
+	}
+
+	return projects, nil
+}
+
+// selector returns the instance group selection of the -match, -location and
+// -labels flags.
+func selector(match, location, labels string) (compute.Selector, error) {
+	sel := compute.Selector{Name: match, Locations: globs(location)}
+	for _, l := range globs(labels) {
+		i := strings.Index(l, "=")
+		if i <= 0 {
+			return sel, fmt.Errorf("invalid label %q in -labels, want key=value", l)
+		}
+
+		if sel.Labels == nil {
+			sel.Labels = make(map[string]string)
+		}
+		sel.Labels[l[:i]] = l[i+1:]
+	}
+
+	return sel, nil
+}
+
+// selectTargets resolves sel to a single wave of groups, printing them and
+// asking for confirmation first when confirm is set. The answer is read from
+// the terminal rather than stdin when stdinUsed is set.
+func selectTargets(ctx context.Context, projectID string, sel compute.Selector, confirm, stdinUsed bool) ([][]deploy.Target, error) {
+	groups, err := compute.SelectGroups(ctx, projectID, sel)
+	if err != nil {
+		return nil, err
+	}
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("no instance groups in %v match the selection", projectID)
+	}
+
+	var wave []deploy.Target
+	for _, g := range groups {
+		wave = append(wave, deploy.Target{Zone: g.Zone, Group: g.Name})
+	}
+
+	if confirm {
+		in := os.Stdin
+		if stdinUsed {
+			in, err = os.Open("/dev/tty")
+			if err != nil {
+				return nil, fmt.Errorf("stdin holds the value and there is no terminal to confirm the selected groups, pass -yes: %v", err)
+			}
+			defer in.Close()
+		}
+
+		compute.PrintGroups(groups)
+		fmt.Fprintf(os.Stderr, "replace these %d groups? [y/N] ", len(groups))
+		answer, _ := bufio.NewReader(in).ReadString('\n')
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer != "y" && answer != "yes" {
+			return nil, fmt.Errorf("rollout cancelled, pass -yes to replace the selected groups without asking")
+		}
+	}
+
+	return [][]deploy.Target{wave}, nil
+}
+
+// parseTime accepts an RFC3339 timestamp or a duration before now. A blank
+// value returns the zero time.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// setupLogging sets the diagnostic log level and format from the flags.
+func setupLogging(quiet, verbose, debug bool, format string) error {
+	switch {
+	case debug:
+		logger.SetLevel(logger.LevelDebug)
+	case verbose:
+		logger.SetLevel(logger.LevelVerbose)
+	case quiet:
+		logger.SetLevel(logger.LevelWarn)
+	}
+
+	if format == "" {
+		return nil
+	}
+
+	return logger.SetFormat(format)
+}
+
+func printConfig(ctx context.Context, authPath, projectID, version, source string) {
+	if authPath == "" {
+		authPath = "<gcloud auth>"
+	}
+
+	logger.Infof(ctx, "project: %v", projectID)
+	logger.Infof(ctx, "version: %v", version)
+	logger.Infof(ctx, "source: %v", source)
+	logger.Infof(ctx, "go: %v", runtime.Version())
+	logger.Infof(ctx, "auth: %v", authPath)
 }
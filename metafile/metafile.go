@@ -0,0 +1,376 @@
+// Package metafile reads and writes metadata key values as .env, JSON, YAML
+// and shell export files.
+package metafile
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FormatEnv is a .env file of KEY=value lines as read by docker-compose.
+	FormatEnv = "env"
+
+	// FormatJSON is a JSON object of string values.
+	FormatJSON = "json"
+
+	// FormatYAML is a YAML mapping of string values.
+	FormatYAML = "yaml"
+
+	// FormatShell is a script of export KEY='value' lines.
+	FormatShell = "shell"
+)
+
+// Formats lists the supported formats.
+var Formats = []string{FormatEnv, FormatJSON, FormatYAML, FormatShell}
+
+// name matches keys usable as environment variable names.
+var name = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// plain matches values written to .env files without quotes.
+var plain = regexp.MustCompile(`^[A-Za-z0-9_./:,@+-]*$`)
+
+// FormatOf returns format, or the format implied by the extension of
+// filename when format is blank.
+func FormatOf(format, filename string) (string, error) {
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(filename)) {
+		case ".env":
+			format = FormatEnv
+		case ".json":
+			format = FormatJSON
+		case ".yaml", ".yml":
+			format = FormatYAML
+		case ".sh":
+			format = FormatShell
+		default:
+			if filepath.Base(filename) == ".env" {
+				format = FormatEnv
+			}
+		}
+	}
+
+	for _, f := range Formats {
+		if f == format {
+			return format, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown format %q for %v, want one of %v", format, filename, Formats)
+}
+
+// Select returns the values whose key matches one of globs, or every value
+// when globs is empty. When prefix is not blank only keys starting with it
+// are kept and it is stripped from them.
+func Select(values map[string]string, globs []string, prefix string) map[string]string {
+	selected := make(map[string]string)
+	for k, v := range values {
+		if !matchAny(globs, k) || !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		selected[strings.TrimPrefix(k, prefix)] = v
+	}
+
+	return selected
+}
+
+// AddPrefix returns values with prefix added to each key, the reverse of
+// stripping it with Select.
+func AddPrefix(values map[string]string, prefix string) map[string]string {
+	prefixed := make(map[string]string)
+	for k, v := range values {
+		prefixed[prefix+k] = v
+	}
+
+	return prefixed
+}
+
+func matchAny(globs []string, key string) bool {
+	if len(globs) == 0 {
+		return true
+	}
+
+	for _, g := range globs {
+		ok, _ := path.Match(g, key)
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Encode writes values to w in format sorted by key. The env and shell
+// formats cannot hold keys that are not variable names, they are returned
+// as skipped rather than written.
+func Encode(w io.Writer, format string, values map[string]string) (skipped []string, err error) {
+	switch format {
+	case FormatJSON:
+		b, err := json.MarshalIndent(values, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return nil, err
+	case FormatYAML:
+		b, err := yaml.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		_, err = w.Write(b)
+		return nil, err
+	case FormatEnv, FormatShell:
+	default:
+		return nil, fmt.Errorf("unknown format %q, want one of %v", format, Formats)
+	}
+
+	var keys []string
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	bw := bufio.NewWriter(w)
+	for _, k := range keys {
+		if !name.MatchString(k) {
+			skipped = append(skipped, k)
+			continue
+		}
+
+		if format == FormatShell {
+			fmt.Fprintf(bw, "export %s=%s\n", k, shellQuote(values[k]))
+		} else {
+			fmt.Fprintf(bw, "%s=%s\n", k, envQuote(values[k]))
+		}
+	}
+
+	return skipped, bw.Flush()
+}
+
+// shellQuote single quotes v for a POSIX shell.
+func shellQuote(v string) string {
+	return "'" + strings.Replace(v, "'", `'\''`, -1) + "'"
+}
+
+// envQuote double quotes v with escapes unless it only has plain characters.
+func envQuote(v string) string {
+	if plain.MatchString(v) {
+		return v
+	}
+
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return `"` + r.Replace(v) + `"`
+}
+
+// Decode reads the values in format from r.
+func Decode(r io.Reader, format string) (map[string]string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	switch format {
+	case FormatJSON:
+		err = json.Unmarshal(b, &values)
+	case FormatYAML:
+		err = yaml.Unmarshal(b, &values)
+	case FormatEnv, FormatShell:
+		values, err = parseAssignments(string(b))
+	default:
+		err = fmt.Errorf("unknown format %q, want one of %v", format, Formats)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// parseAssignments reads KEY=value lines optionally prefixed by export.
+// Values may be unquoted, single quoted or double quoted with backslash
+// escapes, quoted values may span lines. Blank lines and # comments are
+// ignored.
+func parseAssignments(s string) (map[string]string, error) {
+	values := make(map[string]string)
+	p := &parser{s: s, line: 1}
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return values, nil
+		}
+
+		if p.peek() == '#' {
+			p.skipLine()
+			continue
+		}
+
+		key := p.until("= \t\n")
+		if key == "export" && (p.peek() == ' ' || p.peek() == '\t') {
+			p.skipBlank()
+			key = p.until("= \t\n")
+		}
+
+		if p.eof() || p.peek() != '=' || !name.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=value", p.line)
+		}
+		p.pos++
+
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		values[key] = value
+	}
+}
+
+type parser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (p *parser) eof() bool  { return p.pos >= len(p.s) }
+func (p *parser) peek() byte { return p.s[p.pos] }
+
+func (p *parser) next() byte {
+	c := p.s[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+
+	return c
+}
+
+// skipSpace skips whitespace including newlines.
+func (p *parser) skipSpace() {
+	for !p.eof() && strings.IndexByte(" \t\r\n", p.peek()) >= 0 {
+		p.next()
+	}
+}
+
+// skipBlank skips spaces and tabs.
+func (p *parser) skipBlank() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.next()
+	}
+}
+
+func (p *parser) skipLine() {
+	for !p.eof() && p.next() != '\n' {
+	}
+}
+
+// until returns the text up to the first of stop.
+func (p *parser) until(stop string) string {
+	start := p.pos
+	for !p.eof() && strings.IndexByte(stop, p.peek()) < 0 {
+		p.next()
+	}
+
+	return p.s[start:p.pos]
+}
+
+// value reads quoted and unquoted segments up to the end of the line.
+// Unquoted text is trimmed and a # after a space or tab starts a comment.
+func (p *parser) value() (string, error) {
+	p.skipBlank()
+
+	var b strings.Builder
+	var unquoted strings.Builder
+	quoted := false
+	for !p.eof() && p.peek() != '\n' {
+		c := p.next()
+		switch c {
+		case '\'':
+			quoted = true
+			b.WriteString(unquoted.String())
+			unquoted.Reset()
+			start := p.pos
+			for !p.eof() && p.peek() != '\'' {
+				p.next()
+			}
+			if p.eof() {
+				return "", fmt.Errorf("line %d: unterminated single quote", p.line)
+			}
+			b.WriteString(p.s[start:p.pos])
+			p.next()
+		case '"':
+			quoted = true
+			b.WriteString(unquoted.String())
+			unquoted.Reset()
+			err := p.doubleQuoted(&b)
+			if err != nil {
+				return "", err
+			}
+		case '\\':
+			if !p.eof() {
+				unquoted.WriteByte(p.next())
+			}
+		case '#':
+			prev := p.s[p.pos-2]
+			if prev == ' ' || prev == '\t' {
+				p.skipLine()
+				return b.String() + strings.TrimSpace(unquoted.String()), nil
+			}
+			unquoted.WriteByte(c)
+		default:
+			unquoted.WriteByte(c)
+		}
+	}
+
+	rest := unquoted.String()
+	if quoted {
+		rest = strings.TrimRight(rest, " \t\r")
+	} else {
+		rest = strings.TrimSpace(rest)
+	}
+
+	return b.String() + rest, nil
+}
+
+// doubleQuoted reads a double quoted segment after its opening quote.
+func (p *parser) doubleQuoted(b *strings.Builder) error {
+	for !p.eof() {
+		c := p.next()
+		switch c {
+		case '"':
+			return nil
+		case '\\':
+			if p.eof() {
+				continue
+			}
+			e := p.next()
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\', '$', '`':
+				b.WriteByte(e)
+			case '\n':
+				// a line continuation.
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+
+	return fmt.Errorf("line %d: unterminated double quote", p.line)
+}
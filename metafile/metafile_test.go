@@ -0,0 +1,142 @@
+package metafile
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// tricky holds values that need quoting or escaping in some format.
+var tricky = map[string]string{
+	"PLAIN":        "1.2.3",
+	"BLANK":        "",
+	"SPACES":       "  padded value  ",
+	"MULTI_LINE":   "line one\nline two\n",
+	"CRLF":         "a\r\nb",
+	"TAB":          "a\tb",
+	"SINGLE":       "it's",
+	"DOUBLE":       `say "hi"`,
+	"BOTH":         `'single' and "double"`,
+	"DOLLAR":       "$HOME and ${PATH} and $(id)",
+	"HASH":         "a #not a comment",
+	"HASH_START":   "#hash",
+	"BACKSLASH":    `C:\path\n`,
+	"BACKTICK":     "`id`",
+	"END_SLASH":    `a\`,
+	"SPACE_HASH":   " #x",
+	"ONLY_QUOTE":   "'",
+	"EQUALS":       "a=b=c",
+	"EXPORT":       "export X=1",
+	"YAML_LIKE":    "yes",
+	"NUMBER":       "012",
+	"NULL":         "null",
+	"JSON_LIKE":    `{"a": [1, 2]}`,
+	"UNICODE":      "héllo wörld ✓",
+	"LEADING_DASH": "- item",
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range Formats {
+		var b bytes.Buffer
+		skipped, err := Encode(&b, format, tricky)
+		if err != nil {
+			t.Fatalf("Encode %v: %v", format, err)
+		}
+		if len(skipped) > 0 {
+			t.Errorf("Encode %v skipped %v", format, skipped)
+		}
+
+		got, err := Decode(strings.NewReader(b.String()), format)
+		if err != nil {
+			t.Fatalf("Decode %v: %v\n%s", format, err, b.String())
+		}
+
+		for k, want := range tricky {
+			if got[k] != want {
+				t.Errorf("%v round trip of %v = %q, want %q\n%s", format, k, got[k], want, b.String())
+			}
+		}
+		if len(got) != len(tricky) {
+			t.Errorf("%v round trip has %d keys, want %d", format, len(got), len(tricky))
+		}
+	}
+}
+
+func TestEncodeSkipsInvalidNames(t *testing.T) {
+	values := map[string]string{"ok": "1", "app-version": "2", "9lives": "3"}
+	for _, format := range []string{FormatEnv, FormatShell} {
+		var b bytes.Buffer
+		skipped, err := Encode(&b, format, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if want := []string{"9lives", "app-version"}; !reflect.DeepEqual(skipped, want) {
+			t.Errorf("Encode %v skipped %v, want %v", format, skipped, want)
+		}
+		if strings.Contains(b.String(), "app-version") || strings.Contains(b.String(), "9lives") {
+			t.Errorf("Encode %v wrote skipped keys:\n%s", format, b.String())
+		}
+	}
+}
+
+func TestDecodeAssignments(t *testing.T) {
+	tests := []struct {
+		in   string
+		want map[string]string
+	}{
+		{"A=1\nB=2\n", map[string]string{"A": "1", "B": "2"}},
+		{"export A=1\nexport\tB='2'\n", map[string]string{"A": "1", "B": "2"}},
+		{"export=1\n", map[string]string{"export": "1"}},
+		{"A=\nB=   \nC=''\nD=\"\"\n", map[string]string{"A": "", "B": "", "C": "", "D": ""}},
+		{"A= # only a comment\n", map[string]string{"A": ""}},
+		{"# comment\n\n  A=1 # trailing comment\n", map[string]string{"A": "1"}},
+		{"A=a#b\n", map[string]string{"A": "a#b"}},
+		{"A='a # b' # comment\n", map[string]string{"A": "a # b"}},
+		{"A=\"a # b\"\t# comment\n", map[string]string{"A": "a # b"}},
+		{"A=  spaced out  \n", map[string]string{"A": "spaced out"}},
+		{"A=\"multi\nline\"\nB=2\n", map[string]string{"A": "multi\nline", "B": "2"}},
+		{"A='multi\nline'\n", map[string]string{"A": "multi\nline"}},
+		{`A="say \"hi\" \$HOME \\ \n"` + "\n", map[string]string{"A": "say \"hi\" $HOME \\ \n"}},
+		{`A='it'\''s'` + "\n", map[string]string{"A": "it's"}},
+		{`A="it's"` + "\n", map[string]string{"A": "it's"}},
+		{`A='say "hi"'` + "\n", map[string]string{"A": `say "hi"`}},
+		{"A=$HOME\n", map[string]string{"A": "$HOME"}},
+		{"A=1\r\nB='2'\r\n", map[string]string{"A": "1", "B": "2"}},
+		{"A=1", map[string]string{"A": "1"}},
+	}
+
+	for _, tt := range tests {
+		for _, format := range []string{FormatEnv, FormatShell} {
+			got, err := Decode(strings.NewReader(tt.in), format)
+			if err != nil {
+				t.Errorf("Decode(%q, %v): %v", tt.in, format, err)
+				continue
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Decode(%q, %v) = %q, want %q", tt.in, format, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestDecodeAssignmentsInvalid(t *testing.T) {
+	tests := []string{
+		"A\n",
+		"A B=1\n",
+		"9A=1\n",
+		"app-version=1\n",
+		"A='unterminated\n",
+		"A=\"unterminated\n",
+		"export\n",
+	}
+
+	for _, in := range tests {
+		_, err := Decode(strings.NewReader(in), FormatEnv)
+		if err == nil {
+			t.Errorf("Decode(%q) succeeded, want an error", in)
+		}
+	}
+}
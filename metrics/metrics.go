@@ -0,0 +1,317 @@
+// Package metrics implements labelled counters, gauges and histograms written
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram upper bounds in seconds suited to deploys.
+var DefaultBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600}
+
+// Default is the registry used by the deploy instrumentation.
+var Default = NewRegistry()
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// Registry holds a set of metrics.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+}
+
+// Update runs f while no metrics are being written, so the changes f makes
+// to several metrics are scraped together.
+func (r *Registry) Update(f func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	f()
+}
+
+// WriteText writes every metric in the text exposition format.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	for _, m := range r.metrics {
+		m.write(bw)
+	}
+
+	return bw.Flush()
+}
+
+// ServeHTTP implements http.Handler serving the metrics for scraping.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteText(w)
+}
+
+// vec holds the label names and per label set values common to every type.
+type vec struct {
+	mu     sync.Mutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+	keys   map[string][]string
+}
+
+func newVec(name, help, kind string, labels []string) vec {
+	return vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		keys:   make(map[string][]string),
+	}
+}
+
+// key returns the map key for values, panicking on a label count mismatch as
+// that is a programming error.
+func (v *vec) key(values []string) string {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %v got %d label values, want %d", v.name, len(values), len(v.labels)))
+	}
+
+	k := strings.Join(values, "\xff")
+	if _, ok := v.keys[k]; !ok {
+		v.keys[k] = append([]string(nil), values...)
+	}
+
+	return k
+}
+
+func (v *vec) sortedKeys() []string {
+	var keys []string
+	for k := range v.keys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func (v *vec) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %v %v\n", v.name, v.help)
+	fmt.Fprintf(w, "# TYPE %v %v\n", v.name, v.kind)
+}
+
+func (v *vec) labelString(k string, extra ...string) string {
+	values := v.keys[k]
+	var pairs []string
+	for i, name := range v.labels {
+		pairs = append(pairs, fmt.Sprintf("%v=%q", name, printable(values[i])))
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%v=%q", extra[i], extra[i+1]))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// printable drops control characters other than newline so quoting with %q
+// only produces the backslash, quote and newline escapes of the exposition
+// format.
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' && r != '\n' {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// CounterVec is a counter partitioned by labels.
+type CounterVec struct {
+	vec
+	values map[string]float64
+}
+
+// NewCounter registers a counter with the label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(name, help, "counter", labels), values: make(map[string]float64)}
+	r.register(c)
+	return c
+}
+
+// Inc adds one to the counter for the label values.
+func (c *CounterVec) Inc(values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[c.key(values)]++
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.header(w)
+	for _, k := range c.sortedKeys() {
+		fmt.Fprintf(w, "%v%v %v\n", c.name, c.labelString(k), formatFloat(c.values[k]))
+	}
+}
+
+// GaugeVec is a gauge partitioned by labels.
+type GaugeVec struct {
+	vec
+	values map[string]float64
+}
+
+// NewGauge registers a gauge with the label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(name, help, "gauge", labels), values: make(map[string]float64)}
+	r.register(g)
+	return g
+}
+
+// Set sets the gauge for the label values.
+func (g *GaugeVec) Set(v float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.values[g.key(values)] = v
+}
+
+// Reset removes every label set so series that no longer exist are dropped.
+func (g *GaugeVec) Reset() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.keys = make(map[string][]string)
+	g.values = make(map[string]float64)
+}
+
+// GaugeSet holds the values of a GaugeVec built before replacing every
+// series at once, see GaugeVec.Replace.
+type GaugeSet struct {
+	vec
+	values map[string]float64
+}
+
+// NewSet returns an empty set of values with the labels of g.
+func (g *GaugeVec) NewSet() *GaugeSet {
+	return &GaugeSet{vec: newVec(g.name, g.help, g.kind, g.labels), values: make(map[string]float64)}
+}
+
+// Set sets the gauge for the label values.
+func (s *GaugeSet) Set(v float64, values ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[s.key(values)] = v
+}
+
+// Replace drops every label set of g and sets those of s instead in a single
+// step, so a scrape sees either the previous or the new series.
+func (g *GaugeVec) Replace(s *GaugeSet) {
+	s.mu.Lock()
+	keys, values := s.keys, s.values
+	s.keys, s.values = make(map[string][]string), make(map[string]float64)
+	s.mu.Unlock()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.keys = keys
+	g.values = values
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.header(w)
+	for _, k := range g.sortedKeys() {
+		fmt.Fprintf(w, "%v%v %v\n", g.name, g.labelString(k), formatFloat(g.values[k]))
+	}
+}
+
+// HistogramVec is a histogram partitioned by labels.
+type HistogramVec struct {
+	vec
+	buckets []float64
+	counts  map[string][]uint64
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+// NewHistogram registers a histogram with the bucket upper bounds and label
+// names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	h := &HistogramVec{
+		vec:     newVec(name, help, "histogram", labels),
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]uint64),
+	}
+	r.register(h)
+	return h
+}
+
+// Observe adds v to the histogram for the label values.
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	k := h.key(values)
+	counts, ok := h.counts[k]
+	if !ok {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[k] = counts
+	}
+
+	for i, b := range h.buckets {
+		if v <= b {
+			counts[i]++
+		}
+	}
+	h.sums[k] += v
+	h.totals[k]++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.header(w)
+	for _, k := range h.sortedKeys() {
+		for i, b := range h.buckets {
+			fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, h.labelString(k, "le", formatFloat(b)), h.counts[k][i])
+		}
+		fmt.Fprintf(w, "%v_bucket%v %v\n", h.name, h.labelString(k, "le", "+Inf"), h.totals[k])
+		fmt.Fprintf(w, "%v_sum%v %v\n", h.name, h.labelString(k), formatFloat(h.sums[k]))
+		fmt.Fprintf(w, "%v_count%v %v\n", h.name, h.labelString(k), h.totals[k])
+	}
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGaugeReplace(t *testing.T) {
+	r := NewRegistry()
+	g := r.NewGauge("size", "Size.", "group")
+	g.Set(3, "api")
+	g.Set(2, "web")
+
+	s := g.NewSet()
+	s.Set(4, "api")
+	s.Set(1, "worker")
+	g.Replace(s)
+
+	var b bytes.Buffer
+	err := r.WriteText(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# HELP size Size.\n# TYPE size gauge\nsize{group=\"api\"} 4\nsize{group=\"worker\"} 1\n"
+	if b.String() != want {
+		t.Errorf("WriteText = %q, want %q", b.String(), want)
+	}
+
+	// a set is emptied once it replaces the gauge.
+	s.Set(5, "api")
+	b.Reset()
+	r.WriteText(&b)
+	if !strings.Contains(b.String(), `size{group="api"} 4`) {
+		t.Errorf("set changed the gauge after Replace: %q", b.String())
+	}
+}
+
+func TestGaugeReplaceScrape(t *testing.T) {
+	r := NewRegistry()
+	a := r.NewGauge("a", "A.", "n")
+	b := r.NewGauge("b", "B.", "n")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			sa, sb := a.NewSet(), b.NewSet()
+			for n := 0; n < 10; n++ {
+				sa.Set(float64(i), fmt.Sprint(n))
+				sb.Set(float64(i), fmt.Sprint(n))
+			}
+			r.Update(func() {
+				a.Replace(sa)
+				b.Replace(sb)
+			})
+		}
+	}()
+
+	for i := 0; i < 200; i++ {
+		var buf bytes.Buffer
+		r.WriteText(&buf)
+
+		// every series of both gauges comes from the same collection.
+		values := make(map[string]bool)
+		series := 0
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			series++
+			values[line[strings.LastIndex(line, " ")+1:]] = true
+		}
+		if series != 0 && (series != 20 || len(values) != 1) {
+			t.Fatalf("scraped %d series with values %v, want 20 from one collection", series, values)
+		}
+	}
+	wg.Wait()
+}
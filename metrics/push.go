@@ -0,0 +1,46 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Push replaces the metrics of the job's group on a Pushgateway compatible
+// endpoint at gateway. grouping holds additional label pairs identifying the
+// group.
+func (r *Registry) Push(gateway, job string, grouping ...string) error {
+	var b bytes.Buffer
+	err := r.WriteText(&b)
+	if err != nil {
+		return err
+	}
+
+	path := []string{strings.TrimRight(gateway, "/"), "metrics", "job", url.PathEscape(job)}
+	for i := 0; i+1 < len(grouping); i += 2 {
+		path = append(path, url.PathEscape(grouping[i]), url.PathEscape(grouping[i+1]))
+	}
+
+	req, err := http.NewRequest(http.MethodPut, strings.Join(path, "/"), &b)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("push %v got status %v, want 2xx", gateway, resp.Status)
+	}
+
+	return nil
+}
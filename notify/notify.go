@@ -0,0 +1,84 @@
+// Package notify sends rollout events to webhooks, chat and email.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/fresh8/rollerderby/diff"
+	"github.com/fresh8/rollerderby/logger"
+)
+
+const (
+	// EventStart is sent before the metadata update.
+	EventStart = "start"
+
+	// EventProgress is sent as each rollout milestone is reached.
+	EventProgress = "progress"
+
+	// EventSuccess is sent when the rollout completes.
+	EventSuccess = "success"
+
+	// EventFailure is sent when the rollout returns an error.
+	EventFailure = "failure"
+
+	// EventRollback is sent when a failed rollout is reverted.
+	EventRollback = "rollback"
+
+	// EventChange is sent for each key changed while watching a project.
+	EventChange = "change"
+)
+
+// Event describes a rollout event.
+type Event struct {
+	Type     string        `json:"type"`
+	Time     time.Time     `json:"time"`
+	Project  string        `json:"project"`
+	Group    string        `json:"group,omitempty"`
+	Key      string        `json:"key"`
+	OldValue string        `json:"old_value,omitempty"`
+	NewValue string        `json:"new_value"`
+	Duration time.Duration `json:"duration"`
+	Operator string        `json:"operator"`
+	Message  string        `json:"message,omitempty"`
+}
+
+// Text returns a one line human readable summary of the event.
+func (e Event) Text() string {
+	target := e.Project
+	if e.Group != "" {
+		target += "/" + e.Group
+	}
+
+	old := diff.Summary(e.OldValue)
+	if old == "" {
+		old = "<EMPTY>"
+	}
+
+	s := fmt.Sprintf("rollerderby %v: %v %v %v → %v by %v after %v", e.Type, target, e.Key, old, diff.Summary(e.NewValue), e.Operator, e.Duration.Round(time.Second))
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+
+	return s
+}
+
+// Sink delivers an event.
+type Sink interface {
+	Notify(e Event) error
+}
+
+// Notifier sends events to every sink it contains.
+type Notifier []Sink
+
+// Notify sends e to all sinks. Sink failures are logged and never returned
+// so they cannot fail a deploy.
+func (n Notifier) Notify(ctx context.Context, e Event) {
+	for _, s := range n {
+		err := s.Notify(e)
+		if err != nil {
+			logger.Warnf(ctx, "%v notification failed: %v", e.Type, err)
+		}
+	}
+}
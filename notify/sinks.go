@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// timeout bounds each delivery so a slow sink cannot hang a deploy.
+const timeout = 10 * time.Second
+
+var client = &http.Client{Timeout: timeout}
+
+// Webhook posts the event as JSON to URL.
+type Webhook struct {
+	URL string
+}
+
+// Notify implements Sink.
+func (w *Webhook) Notify(e Event) error {
+	return post(w.URL, e)
+}
+
+// Slack posts the event text to a Slack compatible incoming webhook URL.
+type Slack struct {
+	URL string
+}
+
+// Notify implements Sink.
+func (s *Slack) Notify(e Event) error {
+	return post(s.URL, struct {
+		Text string `json:"text"`
+	}{e.Text()})
+}
+
+func post(url string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("post %v got status %v, want 2xx", url, resp.Status)
+	}
+
+	return nil
+}
+
+// Email sends the event through an SMTP server.
+type Email struct {
+	// Addr is the host:port of the SMTP server.
+	Addr     string
+	From     string
+	To       []string
+	Username string
+	Password string
+}
+
+// Notify implements Sink.
+func (m *Email) Notify(e Event) error {
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	target := e.Project
+	if e.Group != "" {
+		target += "/" + e.Group
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %v\r\n", m.From)
+	fmt.Fprintf(&b, "To: %v\r\n", strings.Join(m.To, ", "))
+	fmt.Fprintf(&b, "Subject: [rollerderby] %v %v %v\r\n", e.Type, target, e.Key)
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&b, "%v\r\n", e.Text())
+
+	return m.send(host, auth, b.Bytes())
+}
+
+// send delivers msg like smtp.SendMail with the whole exchange bounded by
+// timeout.
+func (m *Email) send(host string, auth smtp.Auth, msg []byte) error {
+	conn, err := net.DialTimeout("tcp", m.Addr, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+
+	if auth != nil {
+		err = c.Auth(auth)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(m.From)
+	if err != nil {
+		return err
+	}
+	for _, to := range m.To {
+		err = c.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,258 @@
+// Package redact masks metadata values that look like secrets so they are
+// not printed to terminals and CI logs.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// DefaultKeys are the key globs redacted when none are configured.
+var DefaultKeys = []string{"*_token", "*password*", "*secret*"}
+
+// formats match values that are secrets whatever key holds them.
+var formats = []*regexp.Regexp{
+	// PEM private keys.
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	// JSON web tokens.
+	regexp.MustCompile(`^eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+$`),
+	// AWS access key IDs.
+	regexp.MustCompile(`^(AKIA|ASIA)[0-9A-Z]{16}$`),
+	// GitHub tokens.
+	regexp.MustCompile(`^(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})$`),
+	// Slack tokens.
+	regexp.MustCompile(`^xox[abprs]-[A-Za-z0-9-]+$`),
+	// Google API keys.
+	regexp.MustCompile(`^AIza[0-9A-Za-z_-]{35}$`),
+	// URLs with a password.
+	regexp.MustCompile(`://[^/\s:@]+:[^/\s@]+@`),
+}
+
+// token matches values made only of base64 and base64url characters, which
+// excludes URLs, image references and prose.
+var token = regexp.MustCompile(`^[A-Za-z0-9+/_=-]+$`)
+
+const (
+	// minEntropyLength is the shortest value checked for randomness.
+	minEntropyLength = 20
+
+	// minEntropy is the Shannon entropy in bits per character above which a
+	// value is considered random. Hex digests such as commit hashes stay
+	// below it.
+	minEntropy = 4.0
+)
+
+// Redactor decides which values are masked.
+type Redactor struct {
+	// Keys lists globs of keys whose values are always masked, matched
+	// ignoring case.
+	Keys []string
+
+	// Allow lists globs of keys that are never masked, for values the
+	// heuristics mistake for secrets.
+	Allow []string
+
+	// Reveal disables masking.
+	Reveal bool
+
+	// HashKey keys the hash in each mask so a mask cannot be checked against
+	// guessed values without it, see LoadKey. A random key for the process
+	// is used when it is empty.
+	HashKey []byte
+}
+
+// New returns a Redactor masking keys, or DefaultKeys when keys is nil, and
+// secret looking values of any key not in allow.
+func New(keys, allow []string) Redactor {
+	if keys == nil {
+		keys = DefaultKeys
+	}
+
+	return Redactor{Keys: keys, Allow: allow}
+}
+
+// Secret reports whether value of key is masked.
+func (r Redactor) Secret(key, value string) bool {
+	if r.Reveal || value == "" || match(r.Allow, key) {
+		return false
+	}
+
+	return match(r.Keys, key) || LooksSecret(value)
+}
+
+// Value returns value, or its mask when it is a secret.
+func (r Redactor) Value(key, value string) string {
+	if !r.Secret(key, value) {
+		return value
+	}
+
+	return r.Mask(value)
+}
+
+// maskPrefix starts every masked value, the hash follows it.
+const maskPrefix = "****** "
+
+// Mask returns a placeholder with a short hash of value so equal secrets can
+// be recognised without showing them.
+func (r Redactor) Mask(value string) string {
+	return maskPrefix + "hmac:" + r.Hash(value)
+}
+
+// IsMask reports whether value is a mask rather than a real value, for
+// example in a file exported without revealing secrets.
+func IsMask(value string) bool {
+	return strings.HasPrefix(value, maskPrefix)
+}
+
+// Hash returns the first 8 hex digits of the HMAC-SHA256 of value keyed by
+// r.HashKey.
+func (r Redactor) Hash(value string) string {
+	key := r.HashKey
+	if len(key) == 0 {
+		key = processKey()
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil)[:4])
+}
+
+var (
+	processKeyOnce sync.Once
+	processKeyData []byte
+)
+
+// processKey returns a random key generated once per process.
+func processKey() []byte {
+	processKeyOnce.Do(func() {
+		processKeyData = make([]byte, keySize)
+		rand.Read(processKeyData)
+	})
+
+	return processKeyData
+}
+
+// keySize is the number of random bytes in a generated key.
+const keySize = 32
+
+// LoadKey returns the hex encoded key stored in the file name, generating
+// and storing a random key readable only by the owner when the file does not
+// exist.
+func LoadKey(name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(name)
+	if os.IsNotExist(err) {
+		return createKey(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("redact key %v is not a hex encoded key", name)
+	}
+
+	return key, nil
+}
+
+// createKey stores a new key in the file name, reading the existing key
+// instead when another process created it first.
+func createKey(name string) ([]byte, error) {
+	key := make([]byte, keySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(name), 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return LoadKey(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = f.WriteString(hex.EncodeToString(key) + "\n")
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// LooksSecret reports whether value has the format of a known credential or
+// is a long random looking string.
+func LooksSecret(value string) bool {
+	for _, re := range formats {
+		if re.MatchString(value) {
+			return true
+		}
+	}
+
+	if len(value) < minEntropyLength || !token.MatchString(value) {
+		return false
+	}
+
+	return mixed(value) && entropy(value) >= minEntropy
+}
+
+// match reports whether key matches any of globs ignoring case.
+func match(globs []string, key string) bool {
+	key = strings.ToLower(key)
+	for _, g := range globs {
+		ok, _ := path.Match(strings.ToLower(g), key)
+		if ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mixed reports whether s has upper case, lower case and digits like
+// generated keys, unlike paths and names.
+func mixed(s string) bool {
+	return strings.IndexFunc(s, unicode.IsUpper) >= 0 &&
+		strings.IndexFunc(s, unicode.IsLower) >= 0 &&
+		strings.IndexFunc(s, unicode.IsDigit) >= 0
+}
+
+// entropy returns the Shannon entropy of s in bits per character.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	n := 0
+	for _, c := range s {
+		counts[c]++
+		n++
+	}
+
+	var h float64
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		h -= p * math.Log2(p)
+	}
+
+	return h
+}
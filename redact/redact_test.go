@@ -0,0 +1,103 @@
+package redact
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	a := Redactor{HashKey: []byte("key-a")}
+	b := Redactor{HashKey: []byte("key-b")}
+
+	if a.Mask("hunter2") != a.Mask("hunter2") {
+		t.Errorf("Mask differs for the same value and key")
+	}
+	if a.Mask("hunter2") == a.Mask("hunter3") {
+		t.Errorf("Mask is equal for different values")
+	}
+	if a.Mask("hunter2") == b.Mask("hunter2") {
+		t.Errorf("Mask is equal for different keys")
+	}
+
+	sum := sha256.Sum256([]byte("hunter2"))
+	if strings.Contains(a.Mask("hunter2"), hex.EncodeToString(sum[:4])) {
+		t.Errorf("Mask %v contains the unkeyed SHA-256 of the value", a.Mask("hunter2"))
+	}
+
+	var none Redactor
+	if none.Mask("hunter2") != none.Mask("hunter2") {
+		t.Errorf("Mask without a key differs within the process")
+	}
+	if !IsMask(a.Mask("hunter2")) || !IsMask("****** sha256:f52fbd32") || IsMask("hunter2") {
+		t.Errorf("IsMask does not recognise masks")
+	}
+}
+
+func TestValue(t *testing.T) {
+	r := New(nil, []string{"build_id"})
+
+	tests := []struct {
+		key    string
+		value  string
+		masked bool
+	}{
+		{"app_version", "1.0.1", false},
+		{"api_token", "abc", true},
+		{"DB_PASSWORD", "hunter2", true},
+		{"dsn", "postgres://app:hunter2@db/app", true},
+		{"build_id", "Zx8kQ2mN4pR7tV1wY3bC6dF9", false},
+		{"random", "Zx8kQ2mN4pR7tV1wY3bC6dF9", true},
+		{"api_token", "", false},
+	}
+
+	for _, tt := range tests {
+		got := r.Value(tt.key, tt.value)
+		if masked := got != tt.value; masked != tt.masked {
+			t.Errorf("Value(%v, %q) = %q, want masked %v", tt.key, tt.value, got, tt.masked)
+		}
+	}
+
+	r.Reveal = true
+	if got := r.Value("api_token", "abc"); got != "abc" {
+		t.Errorf("Value with Reveal = %q, want abc", got)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "rollerderby", "redact.key")
+
+	key, err := LoadKey(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != keySize {
+		t.Errorf("generated a %d byte key, want %d", len(key), keySize)
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	again, err := LoadKey(name)
+	if err != nil || string(again) != string(key) {
+		t.Errorf("LoadKey again = %x %v, want the stored key %x", again, err, key)
+	}
+
+	err = ioutil.WriteFile(name, []byte("not hex\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = LoadKey(name)
+	if err == nil {
+		t.Errorf("LoadKey of an invalid file error = nil, want an error")
+	}
+}
@@ -0,0 +1,188 @@
+// Package schema validates metadata values against per key rules.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fresh8/rollerderby/semver"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// FormatJSON requires a well formed JSON document.
+	FormatJSON = "json"
+
+	// FormatYAML requires a well formed YAML document.
+	FormatYAML = "yaml"
+)
+
+// Rule constrains the values of a key. Every set field must be satisfied.
+type Rule struct {
+	// Regex must match the whole value.
+	Regex string
+
+	// Semver requires a semantic version such as 1.2.3 or v1.2.3-rc.1.
+	Semver bool
+
+	// Enum lists the allowed values.
+	Enum []string
+
+	// MaxLength is the maximum length in bytes, 0 is unlimited.
+	MaxLength int
+
+	// Format is FormatJSON or FormatYAML.
+	Format string
+}
+
+// Schemas maps a key, or a glob such as *_version, to its rule. An exact key
+// takes precedence over globs. When several globs match a key the one with
+// the longest literal prefix before its first wildcard wins, so app_* beats
+// a*, and globs with equally long prefixes are tried in lexical order.
+type Schemas map[string]Rule
+
+// ValidationError lists the rules a value breaks.
+type ValidationError struct {
+	Key      string
+	Value    string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v=%q is invalid: %v", e.Key, e.Value, strings.Join(e.Problems, ", "))
+}
+
+// Check returns an error for any rule that cannot be used.
+func (s Schemas) Check() error {
+	for key, rule := range s {
+		_, err := path.Match(key, "")
+		if err != nil {
+			return fmt.Errorf("key schema %q: %v", key, err)
+		}
+
+		if rule.Regex != "" {
+			_, err = regexp.Compile(rule.Regex)
+			if err != nil {
+				return fmt.Errorf("key schema %q regex: %v", key, err)
+			}
+		}
+
+		if rule.Format != "" && rule.Format != FormatJSON && rule.Format != FormatYAML {
+			return fmt.Errorf("key schema %q format %q, want %v or %v", key, rule.Format, FormatJSON, FormatYAML)
+		}
+	}
+
+	return nil
+}
+
+// Rule returns the rule for key, see Schemas for the precedence of globs.
+func (s Schemas) Rule(key string) (Rule, bool) {
+	rule, ok := s[key]
+	if ok {
+		return rule, true
+	}
+
+	for _, pattern := range s.patterns() {
+		matched, _ := path.Match(pattern, key)
+		if matched {
+			return s[pattern], true
+		}
+	}
+
+	return Rule{}, false
+}
+
+// patterns returns the keys of s most specific first.
+func (s Schemas) patterns() []string {
+	var patterns []string
+	for pattern := range s {
+		patterns = append(patterns, pattern)
+	}
+
+	sort.Slice(patterns, func(i, j int) bool {
+		a, b := literalPrefix(patterns[i]), literalPrefix(patterns[j])
+		if a != b {
+			return a > b
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	return patterns
+}
+
+// literalPrefix returns the length of pattern before its first wildcard.
+func literalPrefix(pattern string) int {
+	i := strings.IndexAny(pattern, `*?[\`)
+	if i < 0 {
+		return len(pattern)
+	}
+
+	return i
+}
+
+// Validate returns a *ValidationError when value breaks the rule for key.
+// Keys without a rule accept any value.
+func (s Schemas) Validate(key, value string) error {
+	rule, ok := s.Rule(key)
+	if !ok {
+		return nil
+	}
+
+	var problems []string
+	if rule.Regex != "" {
+		re, err := regexp.Compile("^(?:" + rule.Regex + ")$")
+		if err != nil {
+			problems = append(problems, err.Error())
+		} else if !re.MatchString(value) {
+			problems = append(problems, fmt.Sprintf("does not match %v", rule.Regex))
+		}
+	}
+
+	if rule.Semver {
+		_, err := semver.Parse(value)
+		if err != nil {
+			problems = append(problems, "not a semantic version")
+		}
+	}
+
+	if len(rule.Enum) > 0 && !contains(rule.Enum, value) {
+		problems = append(problems, fmt.Sprintf("not one of %v", strings.Join(rule.Enum, ", ")))
+	}
+
+	if rule.MaxLength > 0 && len(value) > rule.MaxLength {
+		problems = append(problems, fmt.Sprintf("longer than %d bytes", rule.MaxLength))
+	}
+
+	switch rule.Format {
+	case FormatJSON:
+		if !json.Valid([]byte(value)) {
+			problems = append(problems, "not well formed JSON")
+		}
+	case FormatYAML:
+		var v interface{}
+		err := yaml.Unmarshal([]byte(value), &v)
+		if err != nil {
+			problems = append(problems, "not well formed YAML: "+err.Error())
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Key: key, Value: value, Problems: problems}
+	}
+
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,53 @@
+package schema
+
+import "testing"
+
+func TestRulePrecedence(t *testing.T) {
+	s := Schemas{
+		"app_version": {MaxLength: 1},
+		"app_*":       {MaxLength: 2},
+		"a*":          {MaxLength: 3},
+		"*_version":   {MaxLength: 4},
+		"ab*":         {MaxLength: 5},
+		"ab?":         {MaxLength: 6},
+	}
+
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"app_version", 1},
+		{"app_name", 2},
+		{"api_version", 3},
+		{"db_version", 4},
+		{"abc", 5},
+		{"other", 0},
+	}
+
+	// map iteration order varies so check the choice is stable.
+	for i := 0; i < 20; i++ {
+		for _, tt := range tests {
+			rule, ok := s.Rule(tt.key)
+			if ok != (tt.want != 0) || rule.MaxLength != tt.want {
+				t.Fatalf("Rule(%q) = %v, %v, want max length %d", tt.key, rule, ok, tt.want)
+			}
+		}
+	}
+}
+
+func TestLiteralPrefix(t *testing.T) {
+	tests := map[string]int{
+		"app_version": 11,
+		"app_*":       4,
+		"*_version":   0,
+		"ab?":         2,
+		"a[bc]":       1,
+		`a\*`:         1,
+	}
+
+	for pattern, want := range tests {
+		if got := literalPrefix(pattern); got != want {
+			t.Errorf("literalPrefix(%q) = %d, want %d", pattern, got, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package semver parses semantic versions held in metadata values.
+package semver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pattern is the semver.org 2.0.0 grammar with an optional v prefix.
+var pattern = regexp.MustCompile(`^(v?)(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)` +
+	`(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?` +
+	`(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+
+// Version is a parsed semantic version.
+type Version struct {
+	// Prefix is "v" when the version was written as v1.2.3.
+	Prefix string
+
+	Major int64
+	Minor int64
+	Patch int64
+
+	// Pre holds the dot separated pre-release identifiers.
+	Pre []string
+
+	Build string
+}
+
+// Parse returns the version in s.
+func Parse(s string) (Version, error) {
+	m := pattern.FindStringSubmatch(s)
+	if m == nil {
+		return Version{}, fmt.Errorf("%q is not a semantic version", s)
+	}
+
+	v := Version{Prefix: m[1], Build: m[6]}
+	v.Major, _ = strconv.ParseInt(m[2], 10, 64)
+	v.Minor, _ = strconv.ParseInt(m[3], 10, 64)
+	v.Patch, _ = strconv.ParseInt(m[4], 10, 64)
+	if m[5] != "" {
+		v.Pre = strings.Split(m[5], ".")
+	}
+
+	return v, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%v%d.%d.%d", v.Prefix, v.Major, v.Minor, v.Patch)
+	if len(v.Pre) > 0 {
+		s += "-" + strings.Join(v.Pre, ".")
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+
+	return s
+}
+
+// Compare returns -1, 0 or 1 when a has lower, equal or higher precedence
+// than b. Build metadata and the v prefix are ignored.
+func Compare(a, b Version) int {
+	for _, d := range []int64{a.Major - b.Major, a.Minor - b.Minor, a.Patch - b.Patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+
+	// a release has higher precedence than its pre-releases.
+	switch {
+	case len(a.Pre) == 0 && len(b.Pre) == 0:
+		return 0
+	case len(a.Pre) == 0:
+		return 1
+	case len(b.Pre) == 0:
+		return -1
+	}
+
+	for i := 0; i < len(a.Pre) && i < len(b.Pre); i++ {
+		c := compareIdentifier(a.Pre[i], b.Pre[i])
+		if c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(a.Pre) < len(b.Pre):
+		return -1
+	case len(a.Pre) > len(b.Pre):
+		return 1
+	}
+
+	return 0
+}
+
+// compareIdentifier compares pre-release identifiers, numeric identifiers
+// have lower precedence than alphanumeric ones.
+func compareIdentifier(a, b string) int {
+	an, aErr := strconv.ParseInt(a, 10, 64)
+	bn, bErr := strconv.ParseInt(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		if an < bn {
+			return -1
+		}
+		if an > bn {
+			return 1
+		}
+		return 0
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+const (
+	// BumpMajor increases the major version.
+	BumpMajor = "major"
+
+	// BumpMinor increases the minor version.
+	BumpMinor = "minor"
+
+	// BumpPatch increases the patch version.
+	BumpPatch = "patch"
+
+	// BumpPrerelease keeps the version number and moves to a later
+	// pre-release or from a pre-release to the release.
+	BumpPrerelease = "prerelease"
+
+	// BumpNone has equal precedence.
+	BumpNone = "none"
+
+	// BumpDowngrade moves to a lower precedence.
+	BumpDowngrade = "downgrade"
+)
+
+// Bump classifies the change from old to new.
+func Bump(old, new Version) string {
+	switch Compare(old, new) {
+	case 1:
+		return BumpDowngrade
+	case 0:
+		return BumpNone
+	}
+
+	switch {
+	case new.Major != old.Major:
+		return BumpMajor
+	case new.Minor != old.Minor:
+		return BumpMinor
+	case new.Patch != old.Patch:
+		return BumpPatch
+	}
+
+	return BumpPrerelease
+}
+
+// Increment returns v with part, one of BumpMajor, BumpMinor, BumpPatch or
+// BumpPrerelease, incremented. A pre-release is released by the smallest
+// part that reaches it, so 2.0.0-rc.1 becomes 2.0.0 for a major bump.
+// BumpPrerelease increments the last numeric identifier of the pre-release
+// or starts preID.0 on the next patch. A preID different to the current one
+// starts preID.0 on the same version. Build metadata is dropped.
+func Increment(v Version, part, preID string) (Version, error) {
+	next := Version{Prefix: v.Prefix, Major: v.Major, Minor: v.Minor, Patch: v.Patch}
+	pre := len(v.Pre) > 0
+	switch part {
+	case BumpMajor:
+		if !pre || v.Minor != 0 || v.Patch != 0 {
+			next.Major++
+		}
+		next.Minor = 0
+		next.Patch = 0
+	case BumpMinor:
+		if !pre || v.Patch != 0 {
+			next.Minor++
+		}
+		next.Patch = 0
+	case BumpPatch:
+		if !pre {
+			next.Patch++
+		}
+	case BumpPrerelease:
+		next.Pre = incrementPre(v, preID)
+		if !pre {
+			next.Patch++
+		}
+	default:
+		return v, fmt.Errorf("unknown version part %q, want %v, %v, %v or %v", part, BumpMajor, BumpMinor, BumpPatch, BumpPrerelease)
+	}
+
+	return next, nil
+}
+
+func incrementPre(v Version, preID string) []string {
+	if len(v.Pre) == 0 || (preID != "" && v.Pre[0] != preID) {
+		if preID == "" {
+			return []string{"0"}
+		}
+		return []string{preID, "0"}
+	}
+
+	pre := append([]string(nil), v.Pre...)
+	for i := len(pre) - 1; i >= 0; i-- {
+		n, err := strconv.ParseInt(pre[i], 10, 64)
+		if err == nil {
+			pre[i] = strconv.FormatInt(n+1, 10)
+			return pre
+		}
+	}
+
+	return append(pre, "0")
+}
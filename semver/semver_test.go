@@ -0,0 +1,215 @@
+package semver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Version
+		err  bool
+	}{
+		{s: "1.2.3", want: Version{Major: 1, Minor: 2, Patch: 3}},
+		{s: "v1.2.3", want: Version{Prefix: "v", Major: 1, Minor: 2, Patch: 3}},
+		{s: "1.0.0-rc.1", want: Version{Major: 1, Pre: []string{"rc", "1"}}},
+		{s: "1.0.0-alpha-1.x-y", want: Version{Major: 1, Pre: []string{"alpha-1", "x-y"}}},
+		{s: "1.0.0+build.5", want: Version{Major: 1, Build: "build.5"}},
+		{s: "1.0.0-beta+exp.sha.5114f85", want: Version{Major: 1, Pre: []string{"beta"}, Build: "exp.sha.5114f85"}},
+		{s: "1.2", err: true},
+		{s: "01.2.3", err: true},
+		{s: "1.2.3-01", err: true},
+		{s: "1.2.3-", err: true},
+		{s: "1.2.3+", err: true},
+		{s: "V1.2.3", err: true},
+		{s: " 1.2.3", err: true},
+		{s: "latest", err: true},
+		{s: "", err: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.s)
+		if (err != nil) != tt.err {
+			t.Errorf("Parse(%q) error %v, want error %v", tt.s, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Parse(%q) = %#v, want %#v", tt.s, got, tt.want)
+		}
+		if got.String() != tt.s {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.s, got.String(), tt.s)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.0", "2.0.0", -1},
+		{"2.1.0", "2.0.9", 1},
+		{"2.1.1", "2.1.10", -1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0", "1.0.0-rc.1", 1},
+		{"1.0.0-rc.10", "1.0.0-rc.2", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"1.0.0-alpha.beta", "1.0.0-beta", -1},
+		{"1.0.0-beta.11", "1.0.0-rc.1", -1},
+		{"1.0.0+build.1", "1.0.0+build.2", 0},
+		{"1.0.0-rc.1+a", "1.0.0-rc.1+b", 0},
+		{"v1.2.3", "1.2.3", 0},
+	}
+
+	for _, tt := range tests {
+		a, err := Parse(tt.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Parse(tt.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := Compare(a, b); got != tt.want {
+			t.Errorf("Compare(%v, %v) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestBump(t *testing.T) {
+	tests := []struct {
+		old, new string
+		want     string
+	}{
+		{"1.2.3", "2.0.0", BumpMajor},
+		{"1.2.3", "1.3.0", BumpMinor},
+		{"1.2.3", "1.2.4", BumpPatch},
+		{"1.2.3", "v1.2.4", BumpPatch},
+		{"1.2.3-rc.1", "1.2.3-rc.2", BumpPrerelease},
+		{"1.2.3-rc.1", "1.2.3", BumpPrerelease},
+		{"1.2.3-rc.1", "1.3.0", BumpMinor},
+		{"1.9.0", "2.0.0-rc.1", BumpMajor},
+		{"1.2.3", "1.2.3", BumpNone},
+		{"1.2.3+a", "1.2.3+b", BumpNone},
+		{"1.2.3", "1.2.2", BumpDowngrade},
+		{"2.0.0", "1.9.9", BumpDowngrade},
+		{"1.2.3", "1.2.3-rc.1", BumpDowngrade},
+		{"1.0.0-rc.10", "1.0.0-rc.2", BumpDowngrade},
+	}
+
+	for _, tt := range tests {
+		old, err := Parse(tt.old)
+		if err != nil {
+			t.Fatal(err)
+		}
+		new, err := Parse(tt.new)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := Bump(old, new); got != tt.want {
+			t.Errorf("Bump(%v, %v) = %v, want %v", tt.old, tt.new, got, tt.want)
+		}
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	tests := []struct {
+		v     string
+		part  string
+		preID string
+		want  string
+		err   bool
+
+		// lower is set when the increment sorts lower, as a new preID starts
+		// over on the same version.
+		lower bool
+	}{
+		{v: "1.2.3", part: BumpMajor, want: "2.0.0"},
+		{v: "1.2.3", part: BumpMinor, want: "1.3.0"},
+		{v: "1.2.3", part: BumpPatch, want: "1.2.4"},
+		{v: "v1.2.3", part: BumpPatch, want: "v1.2.4"},
+		{v: "v1.2.3", part: BumpMajor, want: "v2.0.0"},
+		{v: "1.2.3+build.7", part: BumpPatch, want: "1.2.4"},
+		{v: "2.0.0-rc.1", part: BumpMajor, want: "2.0.0"},
+		{v: "2.1.0-rc.1", part: BumpMajor, want: "3.0.0"},
+		{v: "1.3.0-rc.1", part: BumpMinor, want: "1.3.0"},
+		{v: "1.3.1-rc.1", part: BumpMinor, want: "1.4.0"},
+		{v: "1.2.4-rc.1", part: BumpPatch, want: "1.2.4"},
+		{v: "1.2.3", part: BumpPrerelease, want: "1.2.4-0"},
+		{v: "1.2.3", part: BumpPrerelease, preID: "rc", want: "1.2.4-rc.0"},
+		{v: "v1.2.4-rc.1", part: BumpPrerelease, want: "v1.2.4-rc.2"},
+		{v: "1.2.4-rc.9", part: BumpPrerelease, preID: "rc", want: "1.2.4-rc.10"},
+		{v: "1.2.4-rc.1", part: BumpPrerelease, preID: "beta", want: "1.2.4-beta.0", lower: true},
+		{v: "1.2.4-beta.3", part: BumpPrerelease, preID: "rc", want: "1.2.4-rc.0"},
+		{v: "1.2.4-rc.1.build", part: BumpPrerelease, want: "1.2.4-rc.2.build"},
+		{v: "1.2.4-rc", part: BumpPrerelease, want: "1.2.4-rc.0"},
+		{v: "1.2.3", part: "build", err: true},
+	}
+
+	for _, tt := range tests {
+		v, err := Parse(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := Increment(v, tt.part, tt.preID)
+		if (err != nil) != tt.err {
+			t.Errorf("Increment(%v, %v, %q) error %v, want error %v", tt.v, tt.part, tt.preID, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if got.String() != tt.want {
+			t.Errorf("Increment(%v, %v, %q) = %v, want %v", tt.v, tt.part, tt.preID, got, tt.want)
+		}
+		if higher := Compare(got, v) > 0; higher == tt.lower {
+			t.Errorf("Increment(%v, %v, %q) = %v, higher precedence %v, want %v", tt.v, tt.part, tt.preID, got, higher, !tt.lower)
+		}
+	}
+}
+
+func TestIncrementPre(t *testing.T) {
+	tests := []struct {
+		v     string
+		preID string
+		want  []string
+	}{
+		{"1.0.0", "", []string{"0"}},
+		{"1.0.0", "alpha", []string{"alpha", "0"}},
+		{"1.0.0-0", "", []string{"1"}},
+		{"1.0.0-alpha.1", "", []string{"alpha", "2"}},
+		{"1.0.0-alpha.1", "alpha", []string{"alpha", "2"}},
+		{"1.0.0-alpha.1", "beta", []string{"beta", "0"}},
+		{"1.0.0-alpha.1.x", "", []string{"alpha", "2", "x"}},
+		{"1.0.0-alpha", "", []string{"alpha", "0"}},
+	}
+
+	for _, tt := range tests {
+		v, err := Parse(tt.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := incrementPre(v, tt.preID); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("incrementPre(%v, %q) = %q, want %q", tt.v, tt.preID, got, tt.want)
+		}
+	}
+
+	// the pre-release of v is copied rather than changed in place.
+	v, _ := Parse("1.0.0-rc.1")
+	incrementPre(v, "")
+	if v.String() != "1.0.0-rc.1" {
+		t.Errorf("incrementPre changed its version to %v", v)
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fresh8/rollerderby/compute"
+	v1 "google.golang.org/api/compute/v1"
+)
+
+// fakeCompute serves the project metadata and instance group calls of the
+// Compute API from memory. Metadata writes are rejected with 412 when the
+// fingerprint does not match like the real API.
+type fakeCompute struct {
+	mu           sync.Mutex
+	metadata     map[string]map[string]string
+	fingerprints map[string]int
+
+	// writes holds the metadata of each project after each write in order.
+	writes map[string][]map[string]string
+
+	// patched holds the zone/group of each patched instance group in order.
+	patched []string
+
+	// writeDelay holds each metadata write before checking the fingerprint
+	// so concurrent writes overlap.
+	writeDelay time.Duration
+}
+
+// newFakeCompute serves metadata, a project name to its keys, and points
+// compute.Endpoint at the fake until the test ends.
+func newFakeCompute(t *testing.T, metadata map[string]map[string]string) *fakeCompute {
+	f := &fakeCompute{
+		metadata:     metadata,
+		fingerprints: make(map[string]int),
+		writes:       make(map[string][]map[string]string),
+	}
+
+	srv := httptest.NewServer(f)
+	endpoint := compute.Endpoint
+	compute.Endpoint = srv.URL
+	t.Cleanup(func() {
+		compute.Endpoint = endpoint
+		srv.Close()
+	})
+
+	return f
+}
+
+func (f *fakeCompute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// /compute/{version}/projects/{project}/{rest}
+	parts := strings.SplitN(r.URL.Path, "/", 6)
+	if len(parts) < 5 || parts[1] != "compute" || parts[3] != "projects" {
+		f.error(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+		return
+	}
+	project, rest := parts[4], ""
+	if len(parts) == 6 {
+		rest = parts[5]
+	}
+
+	f.mu.Lock()
+	_, ok := f.metadata[project]
+	f.mu.Unlock()
+	if !ok {
+		f.error(w, http.StatusNotFound, "project "+project+" not found")
+		return
+	}
+
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		f.getProject(w, project)
+	case rest == "setCommonInstanceMetadata" && r.Method == http.MethodPost:
+		f.setMetadata(w, r, project)
+	case strings.HasPrefix(rest, "global/operations/"):
+		f.writeJSON(w, map[string]string{"name": "op", "status": "DONE"})
+	case strings.Contains(rest, "/instanceGroupManagers/"):
+		f.group(w, r, rest)
+	default:
+		f.error(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+	}
+}
+
+func (f *fakeCompute) getProject(w http.ResponseWriter, project string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	meta := &v1.Metadata{Fingerprint: fmt.Sprint(f.fingerprints[project])}
+	for k, v := range f.metadata[project] {
+		v := v
+		meta.Items = append(meta.Items, &v1.MetadataItems{Key: k, Value: &v})
+	}
+	sort.Slice(meta.Items, func(i, j int) bool { return meta.Items[i].Key < meta.Items[j].Key })
+
+	f.writeJSON(w, v1.Project{Name: project, CommonInstanceMetadata: meta})
+}
+
+func (f *fakeCompute) setMetadata(w http.ResponseWriter, r *http.Request, project string) {
+	var meta v1.Metadata
+	err := json.NewDecoder(r.Body).Decode(&meta)
+	if err != nil {
+		f.error(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	time.Sleep(f.writeDelay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if meta.Fingerprint != fmt.Sprint(f.fingerprints[project]) {
+		f.error(w, http.StatusPreconditionFailed, "Supplied fingerprint does not match current metadata fingerprint.")
+		return
+	}
+
+	values := make(map[string]string)
+	for _, item := range meta.Items {
+		if item.Value != nil {
+			values[item.Key] = *item.Value
+		}
+	}
+	f.metadata[project] = values
+	f.fingerprints[project]++
+	f.writes[project] = append(f.writes[project], values)
+
+	f.writeJSON(w, v1.Operation{Name: "op", Status: "DONE"})
+}
+
+func (f *fakeCompute) group(w http.ResponseWriter, r *http.Request, rest string) {
+	// zones/{zone}/instanceGroupManagers/{group}
+	parts := strings.Split(rest, "/")
+	zone, group := parts[1], parts[3]
+
+	switch r.Method {
+	case http.MethodGet:
+		f.writeJSON(w, map[string]interface{}{
+			"name":             group,
+			"zone":             zone,
+			"instanceTemplate": "template",
+			"versions":         []map[string]string{{"name": "v1", "instanceTemplate": "template"}},
+			"updatePolicy":     map[string]string{},
+		})
+	case http.MethodPatch:
+		f.mu.Lock()
+		f.patched = append(f.patched, zone+"/"+group)
+		f.mu.Unlock()
+		f.writeJSON(w, map[string]string{"name": "op", "status": "DONE"})
+	default:
+		f.error(w, http.StatusMethodNotAllowed, r.Method)
+	}
+}
+
+// patchedGroups returns the zone/group of each patched instance group.
+func (f *fakeCompute) patchedGroups() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.patched...)
+}
+
+// written returns the values of key after each write to project.
+func (f *fakeCompute) written(project, key string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var values []string
+	for _, w := range f.writes[project] {
+		if v, ok := w[key]; ok && (len(values) == 0 || values[len(values)-1] != v) {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+func (f *fakeCompute) writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeCompute) error(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]interface{}{"code": status, "message": msg}})
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fresh8/rollerderby/compute"
+	"github.com/fresh8/rollerderby/deploy"
+	"github.com/fresh8/rollerderby/verify"
+)
+
+const (
+	// StatusQueued is a rollout waiting behind another to the same group.
+	StatusQueued = "queued"
+
+	// StatusRunning is a rollout in progress.
+	StatusRunning = "running"
+
+	// StatusSucceeded is a rollout that completed.
+	StatusSucceeded = "succeeded"
+
+	// StatusFailed is a rollout that stopped with an error.
+	StatusFailed = "failed"
+)
+
+// RolloutRequest starts a rollout. Blank fields take the same defaults as
+// the command line flags, durations are strings such as "5m".
+type RolloutRequest struct {
+	Project        string `json:"project"`
+	Zone           string `json:"zone"`
+	Group          string `json:"group"`
+	Key            string `json:"key"`
+	Value          string `json:"value"`
+	MinReadySec    int64  `json:"min_ready_sec"`
+	VersionName    string `json:"version_name"`
+	HealthTimeout  string `json:"health_timeout"`
+	WaitTimeout    string `json:"wait_timeout"`
+	Rollback       bool   `json:"rollback"`
+	Force          bool   `json:"force"`
+	AllowDowngrade bool   `json:"allow_downgrade"`
+	AllowProtected bool   `json:"allow_protected"`
+
+	Verify *VerifyRequest `json:"verify"`
+}
+
+// VerifyRequest configures probing the new instances, see verify.New.
+type VerifyRequest struct {
+	URL      string `json:"url"`
+	Expect   string `json:"expect"`
+	Match    string `json:"match"`
+	Retries  int    `json:"retries"`
+	Interval string `json:"interval"`
+}
+
+// Deployment validates the request and returns it with defaults applied.
+func (req RolloutRequest) Deployment() (deploy.Deployment, error) {
+	if req.Project == "" || req.Key == "" || req.Value == "" {
+		return deploy.Deployment{}, fmt.Errorf("project, key and value are required")
+	}
+
+	dep := deploy.Deployment{
+		Project:         req.Project,
+		Zone:            req.Zone,
+		Group:           req.Group,
+		Key:             req.Key,
+		Value:           req.Value,
+		MinReadySec:     req.MinReadySec,
+		VersionTemplate: req.VersionName,
+		WaitTimeout:     30 * time.Minute,
+		Rollback:        req.Rollback,
+		Force:           req.Force,
+		AllowDowngrade:  req.AllowDowngrade,
+		AllowProtected:  req.AllowProtected,
+	}
+	if dep.Zone == "" {
+		dep.Zone = "europe-west1-d"
+	}
+	if dep.MinReadySec == 0 {
+		dep.MinReadySec = 90
+	}
+	if dep.VersionTemplate == "" {
+		dep.VersionTemplate = compute.DefaultVersionTemplate
+	}
+
+	var err error
+	if req.HealthTimeout != "" {
+		dep.HealthTimeout, err = time.ParseDuration(req.HealthTimeout)
+		if err != nil {
+			return dep, fmt.Errorf("health_timeout %v", err)
+		}
+	}
+	if req.WaitTimeout != "" {
+		dep.WaitTimeout, err = time.ParseDuration(req.WaitTimeout)
+		if err != nil {
+			return dep, fmt.Errorf("wait_timeout %v", err)
+		}
+	}
+
+	if req.Verify != nil {
+		v := *req.Verify
+		if v.Expect == "" {
+			v.Expect = "{{.Value}}"
+		}
+		if v.Match == "" {
+			v.Match = verify.MatchExact
+		}
+		if v.Retries == 0 {
+			v.Retries = 5
+		}
+		interval := 5 * time.Second
+		if v.Interval != "" {
+			interval, err = time.ParseDuration(v.Interval)
+			if err != nil {
+				return dep, fmt.Errorf("verify.interval %v", err)
+			}
+		}
+
+		dep.Probe, err = verify.New(v.URL, v.Expect, v.Match, v.Retries, interval)
+		if err != nil {
+			return dep, err
+		}
+	}
+
+	// check the template now rather than once the metadata is written.
+	_, err = compute.VersionName(dep.VersionTemplate, dep.Group, dep.Key, dep.Value, time.Now())
+	if err != nil {
+		return dep, err
+	}
+
+	return dep, nil
+}
+
+// Rollout is the state of a requested rollout.
+type Rollout struct {
+	ID       string         `json:"id"`
+	Status   string         `json:"status"`
+	Request  RolloutRequest `json:"request"`
+	User     string         `json:"user"`
+	Created  time.Time      `json:"created"`
+	Started  *time.Time     `json:"started,omitempty"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+// lane returns the queue a deployment waits in. Rollouts to a group run one
+// at a time, metadata only updates are serialised per project.
+func lane(dep deploy.Deployment) string {
+	if dep.Group == "" {
+		return dep.Project
+	}
+
+	return dep.Project + "/" + dep.Zone + "/" + dep.Group
+}
+
+// queue runs jobs in order per lane with lanes running concurrently.
+type queue struct {
+	mu sync.Mutex
+
+	// pending holds the jobs of each lane, the first is running.
+	pending map[string][]func()
+}
+
+func (q *queue) push(lane string, job func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	idle := len(q.pending[lane]) == 0
+	q.pending[lane] = append(q.pending[lane], job)
+	if idle {
+		go q.run(lane)
+	}
+}
+
+func (q *queue) run(lane string) {
+	for {
+		q.mu.Lock()
+		job := q.pending[lane][0]
+		q.mu.Unlock()
+
+		job()
+
+		q.mu.Lock()
+		q.pending[lane] = q.pending[lane][1:]
+		if len(q.pending[lane]) == 0 {
+			delete(q.pending, lane)
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+	}
+}
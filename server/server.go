@@ -0,0 +1,432 @@
+// Package server exposes metadata, groups and rollouts over a REST API so a
+// single service can own the Compute credentials.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fresh8/rollerderby/compute"
+	"github.com/fresh8/rollerderby/deploy"
+	"github.com/fresh8/rollerderby/logger"
+	"github.com/fresh8/rollerderby/metrics"
+	"github.com/fresh8/rollerderby/redact"
+	"github.com/fresh8/rollerderby/tracing"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// rolloutTTL is how long a finished rollout is kept for status requests.
+	rolloutTTL = 24 * time.Hour
+
+	// maxRollouts caps the rollouts kept, the oldest finished ones are
+	// evicted first.
+	maxRollouts = 1000
+
+	// flushInterval is how often the spans of API requests are exported,
+	// rollouts export theirs when they finish.
+	flushInterval = 10 * time.Second
+)
+
+// Server handles API requests.
+type Server struct {
+	// Tokens maps each accepted bearer token to the caller name recorded as
+	// the audit user of its rollouts.
+	Tokens map[string]string
+
+	// Deployer returns the Deployer used for rollouts to projectID.
+	Deployer func(projectID string) (*deploy.Deployer, error)
+
+	// Webhooks starts rollouts from Git pushes.
+	Webhooks Webhooks
+
+	// Redactor masks secret looking values in metadata and compare
+	// responses unless the request sets reveal=true.
+	Redactor redact.Redactor
+
+	mu       sync.Mutex
+	rollouts map[string]*Rollout
+	queue    queue
+}
+
+// New returns a Server accepting tokens that runs rollouts with deployer.
+func New(tokens map[string]string, deployer func(projectID string) (*deploy.Deployer, error)) *Server {
+	return &Server{
+		Tokens:   tokens,
+		Deployer: deployer,
+		rollouts: make(map[string]*Rollout),
+		queue:    queue{pending: make(map[string][]func())},
+	}
+}
+
+// ListenAndServe serves the API on addr, exporting spans every
+// flushInterval.
+func (s *Server) ListenAndServe(addr string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tracing.FlushEvery(ctx, flushInterval, func(err error) {
+		logger.Warnf(ctx, "unable to export traces: %v", err)
+	})
+
+	logger.Infof(ctx, "serving API on %v", addr)
+	return http.ListenAndServe(addr, s.Handler())
+}
+
+// Handler returns the API routes. /metrics is served without
+// authentication, webhooks are verified by their secret and every /v1/ route
+// requires a bearer token.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default)
+	mux.HandleFunc("/webhooks/github", s.github)
+	mux.HandleFunc("/webhooks/gitlab", s.gitlab)
+	mux.Handle("/v1/projects/", s.auth(s.projects))
+	mux.Handle("/v1/compare", s.auth(s.compare))
+	mux.Handle("/v1/rollouts", s.auth(s.rolloutList))
+	mux.Handle("/v1/rollouts/", s.auth(s.rolloutGet))
+
+	return mux
+}
+
+type callerKey struct{}
+
+// auth rejects requests without a known bearer token and stores the caller
+// name in the request context.
+func (s *Server) auth(h http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		for t, name := range s.Tokens {
+			if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				ctx := context.WithValue(r.Context(), callerKey{}, name)
+				h(w, r.WithContext(ctx))
+				return
+			}
+		}
+
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or unknown bearer token"))
+	})
+}
+
+// projects routes /v1/projects/{project}/metadata[/{key}] and
+// /v1/projects/{project}/groups.
+func (s *Server) projects(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/projects/"), "/")
+	ctx := logger.WithFields(r.Context(), "project", parts[0])
+	switch {
+	case len(parts) == 2 && parts[1] == "metadata":
+		s.metadata(ctx, w, r, parts[0], "")
+	case len(parts) == 3 && parts[1] == "metadata":
+		s.metadata(ctx, w, r, parts[0], parts[2])
+	case len(parts) == 2 && parts[1] == "groups":
+		groups, err := compute.Fleet(ctx, parts[0])
+		if err != nil {
+			writeBackendError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, groups)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown path %v", r.URL.Path))
+	}
+}
+
+// metadata writes the common metadata of projectID as a key value object, or
+// the single value of key when it is not blank.
+func (s *Server) metadata(ctx context.Context, w http.ResponseWriter, r *http.Request, projectID, key string) {
+	rd, err := s.redactor(ctx, r, projectID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	items, err := compute.Items(ctx, projectID)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+
+	values := make(map[string]string)
+	for _, item := range items {
+		if item.Value != nil {
+			values[item.Key] = rd.Value(item.Key, *item.Value)
+		}
+	}
+
+	if key == "" {
+		writeJSON(w, http.StatusOK, values)
+		return
+	}
+
+	v, ok := values[key]
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("key %v not found in %v", key, projectID))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"key": key, "value": v})
+}
+
+// Comparison is a key present in either compared project.
+type Comparison struct {
+	Key   string `json:"key"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+	Equal bool   `json:"equal"`
+}
+
+// compare handles /v1/compare?a={project}&b={project}.
+func (s *Server) compare(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+		return
+	}
+
+	a, b := r.URL.Query().Get("a"), r.URL.Query().Get("b")
+	if a == "" || b == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("query parameters a and b are required"))
+		return
+	}
+
+	rd, err := s.redactor(r.Context(), r, a, b)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	keys, err := compute.CompareProjects(r.Context(), a, b)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+
+	result := []Comparison{}
+	for k, v := range keys {
+		result = append(result, Comparison{Key: k, A: rd.Value(k, v.A), B: rd.Value(k, v.B), Equal: v.A == v.B})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Key < result[j].Key })
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// redactor returns the Redactor for r. When r sets reveal=true the reveal is
+// audited as the caller for each of projects before masking is disabled.
+func (s *Server) redactor(ctx context.Context, r *http.Request, projects ...string) (redact.Redactor, error) {
+	rd := s.Redactor
+	if r.URL.Query().Get("reveal") != "true" {
+		return rd, nil
+	}
+
+	for _, p := range projects {
+		d, err := s.Deployer(p)
+		if err != nil {
+			return rd, err
+		}
+		d.User = r.Context().Value(callerKey{}).(string)
+
+		err = d.Reveal(ctx, p)
+		if err != nil {
+			return rd, err
+		}
+	}
+	rd.Reveal = true
+
+	return rd, nil
+}
+
+// rolloutList handles POST /v1/rollouts to start a rollout and GET to list
+// them.
+func (s *Server) rolloutList(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.Lock()
+		result := []Rollout{}
+		for _, ro := range s.rollouts {
+			result = append(result, *ro)
+		}
+		s.mu.Unlock()
+		sort.Slice(result, func(i, j int) bool { return result[i].Created.Before(result[j].Created) })
+		writeJSON(w, http.StatusOK, result)
+	case http.MethodPost:
+		var req RolloutRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		ro, err := s.start(r.Context().Value(callerKey{}).(string), req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Location", "/v1/rollouts/"+ro.ID)
+		writeJSON(w, http.StatusAccepted, ro)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+	}
+}
+
+// rolloutGet handles GET /v1/rollouts/{id}.
+func (s *Server) rolloutGet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/v1/rollouts/")
+	s.mu.Lock()
+	ro, ok := s.rollouts[id]
+	var copied Rollout
+	if ok {
+		copied = *ro
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("rollout %v not found", id))
+		return
+	}
+	writeJSON(w, http.StatusOK, copied)
+}
+
+// start validates req and queues it behind any other rollout to the same
+// group.
+func (s *Server) start(caller string, req RolloutRequest) (Rollout, error) {
+	dep, err := req.Deployment()
+	if err != nil {
+		return Rollout{}, err
+	}
+
+	d, err := s.Deployer(dep.Project)
+	if err != nil {
+		return Rollout{}, err
+	}
+	d.User = caller
+
+	// reject invalid values now rather than once the rollout is queued.
+	if !dep.Force {
+		err = d.Schemas.Validate(dep.Key, dep.Value)
+		if err != nil {
+			return Rollout{}, err
+		}
+	}
+
+	ctx, span := tracing.Start(context.Background(), "api.rollout", "caller", caller)
+	ctx = logger.WithFields(ctx, "project", dep.Project, "group", dep.Group, "key", dep.Key)
+
+	ro := &Rollout{
+		ID:      span.TraceID.String(),
+		Status:  StatusQueued,
+		Request: req,
+		User:    caller,
+		Created: time.Now().UTC(),
+	}
+
+	// the queued state is returned as the rollout may start before push
+	// returns.
+	s.mu.Lock()
+	s.evict(ro.Created)
+	s.rollouts[ro.ID] = ro
+	queued := *ro
+	s.mu.Unlock()
+
+	logger.Infof(ctx, "queued rollout %v for %v", ro.ID, caller)
+	s.queue.push(lane(dep), func() {
+		s.update(ro, func(ro *Rollout) {
+			now := time.Now().UTC()
+			ro.Status = StatusRunning
+			ro.Started = &now
+		})
+
+		err := d.Run(ctx, dep)
+		span.Finish(err)
+
+		s.update(ro, func(ro *Rollout) {
+			now := time.Now().UTC()
+			ro.Status = StatusSucceeded
+			ro.Finished = &now
+			if err != nil {
+				ro.Status = StatusFailed
+				ro.Error = err.Error()
+			}
+		})
+
+		if err != nil {
+			logger.Errorf(ctx, "rollout %v failed: %v", ro.ID, err)
+		} else {
+			logger.Infof(ctx, "rollout %v succeeded", ro.ID)
+		}
+
+		err = tracing.Flush()
+		if err != nil {
+			logger.Warnf(ctx, "unable to export traces: %v", err)
+		}
+	})
+
+	return queued, nil
+}
+
+// evict drops rollouts that finished more than rolloutTTL before now
+// and then the oldest finished rollouts until there is room for another.
+// Queued and running rollouts are always kept. s.mu must be held.
+func (s *Server) evict(now time.Time) {
+	var finished []*Rollout
+	for id, ro := range s.rollouts {
+		if ro.Finished == nil {
+			continue
+		}
+		if now.Sub(*ro.Finished) > rolloutTTL {
+			delete(s.rollouts, id)
+			continue
+		}
+		finished = append(finished, ro)
+	}
+
+	sort.Slice(finished, func(i, j int) bool { return finished[i].Finished.Before(*finished[j].Finished) })
+	for _, ro := range finished {
+		if len(s.rollouts) < maxRollouts {
+			return
+		}
+		delete(s.rollouts, ro.ID)
+	}
+}
+
+// update applies f to ro while holding the lock.
+func (s *Server) update(ro *Rollout, f func(*Rollout)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f(ro)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeBackendError passes through the status of Compute API errors and
+// reports anything else as a bad gateway.
+func writeBackendError(w http.ResponseWriter, err error) {
+	status := http.StatusBadGateway
+	apiErr, ok := err.(*googleapi.Error)
+	if ok && apiErr.Code >= 400 && apiErr.Code < 500 {
+		status = apiErr.Code
+	}
+
+	writeError(w, status, err)
+}
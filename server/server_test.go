@@ -0,0 +1,419 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fresh8/rollerderby/backup"
+	"github.com/fresh8/rollerderby/deploy"
+	"github.com/fresh8/rollerderby/redact"
+)
+
+// newServer returns a Server accepting the token "secret" for the caller
+// "ci" whose rollouts back up to a temporary directory.
+func newServer(t *testing.T) *Server {
+	dir := t.TempDir()
+	s := New(map[string]string{"secret": "ci"}, func(projectID string) (*deploy.Deployer, error) {
+		return &deploy.Deployer{Backups: &backup.Backups{Store: &backup.Dir{Path: dir}}}, nil
+	})
+	s.Redactor = redact.New(nil, nil)
+
+	return s
+}
+
+// serve serves the routes of s until the test ends.
+func serve(t *testing.T, s *Server) *httptest.Server {
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// newTestServer serves a newServer.
+func newTestServer(t *testing.T) *httptest.Server {
+	return serve(t, newServer(t))
+}
+
+// request sends an authenticated request decoding the JSON response into v
+// when it is not nil, and returns the response status.
+func request(t *testing.T, srv *httptest.Server, method, path, body string, v interface{}) int {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if v != nil {
+		err = json.NewDecoder(resp.Body).Decode(v)
+		if err != nil {
+			t.Fatalf("%v %v decoding response: %v", method, path, err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+// waitRollout polls the rollout id until it is finished.
+func waitRollout(t *testing.T, srv *httptest.Server, id string) Rollout {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var ro Rollout
+		status := request(t, srv, http.MethodGet, "/v1/rollouts/"+id, "", &ro)
+		if status != http.StatusOK {
+			t.Fatalf("GET rollout %v status = %v, want %v", id, status, http.StatusOK)
+		}
+		if ro.Status == StatusSucceeded || ro.Status == StatusFailed {
+			return ro
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rollout %v still %v", id, ro.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	newFakeCompute(t, map[string]map[string]string{"project-a": {}})
+	srv := newTestServer(t)
+
+	tests := []struct {
+		header string
+		status int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer", http.StatusUnauthorized},
+		{"Bearer ", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic secret", http.StatusUnauthorized},
+		{"Bearer secret", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/projects/project-a/metadata", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("Authorization %q status = %v, want %v", tt.header, resp.StatusCode, tt.status)
+		}
+		if tt.status == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("Authorization %q WWW-Authenticate = %q, want Bearer", tt.header, resp.Header.Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	newFakeCompute(t, map[string]map[string]string{
+		"project-a": {"app_version": "1.0.0", "db_password": "hunter2"},
+	})
+	srv := newTestServer(t)
+
+	var values map[string]string
+	status := request(t, srv, http.MethodGet, "/v1/projects/project-a/metadata", "", &values)
+	if status != http.StatusOK {
+		t.Fatalf("GET metadata status = %v, want %v", status, http.StatusOK)
+	}
+	if values["app_version"] != "1.0.0" {
+		t.Errorf("app_version = %q, want 1.0.0", values["app_version"])
+	}
+	if values["db_password"] == "hunter2" || values["db_password"] == "" {
+		t.Errorf("db_password = %q, want it masked", values["db_password"])
+	}
+
+	var value map[string]string
+	status = request(t, srv, http.MethodGet, "/v1/projects/project-a/metadata/app_version", "", &value)
+	if status != http.StatusOK || value["value"] != "1.0.0" {
+		t.Errorf("GET app_version = %v %v, want %v 1.0.0", status, value, http.StatusOK)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/v1/projects/project-a/metadata/missing", http.StatusNotFound},
+		{http.MethodGet, "/v1/projects/project-b/metadata", http.StatusNotFound},
+		{http.MethodGet, "/v1/projects/project-a/unknown", http.StatusNotFound},
+		{http.MethodPost, "/v1/projects/project-a/metadata", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		status := request(t, srv, tt.method, tt.path, "", nil)
+		if status != tt.status {
+			t.Errorf("%v %v status = %v, want %v", tt.method, tt.path, status, tt.status)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	newFakeCompute(t, map[string]map[string]string{
+		"project-a": {"app_version": "1.0.0", "region": "eu", "only_a": "x"},
+		"project-b": {"app_version": "1.0.1", "region": "eu"},
+	})
+	srv := newTestServer(t)
+
+	var result []Comparison
+	status := request(t, srv, http.MethodGet, "/v1/compare?a=project-a&b=project-b", "", &result)
+	if status != http.StatusOK {
+		t.Fatalf("GET compare status = %v, want %v", status, http.StatusOK)
+	}
+
+	want := []Comparison{
+		{Key: "app_version", A: "1.0.0", B: "1.0.1"},
+		{Key: "only_a", A: "x"},
+		{Key: "region", A: "eu", B: "eu", Equal: true},
+	}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("compare = %+v, want %+v", result, want)
+	}
+
+	status = request(t, srv, http.MethodGet, "/v1/compare?a=project-a", "", nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("GET compare without b status = %v, want %v", status, http.StatusBadRequest)
+	}
+}
+
+func TestRollout(t *testing.T) {
+	f := newFakeCompute(t, map[string]map[string]string{"project-a": {"app_version": "1.0.0"}})
+	srv := newTestServer(t)
+
+	var ro Rollout
+	status := request(t, srv, http.MethodPost, "/v1/rollouts", `{"project":"project-a","zone":"europe-west1-d","group":"app-group","key":"app_version","value":"1.0.1"}`, &ro)
+	if status != http.StatusAccepted {
+		t.Fatalf("POST rollout status = %v, want %v", status, http.StatusAccepted)
+	}
+	if ro.ID == "" || ro.User != "ci" {
+		t.Errorf("rollout = %+v, want an ID and user ci", ro)
+	}
+
+	ro = waitRollout(t, srv, ro.ID)
+	if ro.Status != StatusSucceeded {
+		t.Fatalf("rollout %v: %v", ro.Status, ro.Error)
+	}
+	if got := f.written("project-a", "app_version"); !reflect.DeepEqual(got, []string{"1.0.1"}) {
+		t.Errorf("wrote app_version %v, want [1.0.1]", got)
+	}
+	if got := f.patchedGroups(); !reflect.DeepEqual(got, []string{"europe-west1-d/app-group"}) {
+		t.Errorf("patched %v, want [europe-west1-d/app-group]", got)
+	}
+
+	var list []Rollout
+	status = request(t, srv, http.MethodGet, "/v1/rollouts", "", &list)
+	if status != http.StatusOK || len(list) != 1 || list[0].ID != ro.ID {
+		t.Errorf("GET rollouts = %v %+v, want %v", status, list, ro.ID)
+	}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{`, http.StatusBadRequest},
+		{`{"project":"project-a","key":"app_version"}`, http.StatusBadRequest},
+		{`{"project":"project-a","key":"app_version","value":"1.0.2","health_timeout":"soon"}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		status := request(t, srv, http.MethodPost, "/v1/rollouts", tt.body, nil)
+		if status != tt.status {
+			t.Errorf("POST rollout %v status = %v, want %v", tt.body, status, tt.status)
+		}
+	}
+
+	status = request(t, srv, http.MethodGet, "/v1/rollouts/unknown", "", nil)
+	if status != http.StatusNotFound {
+		t.Errorf("GET unknown rollout status = %v, want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestRolloutOrder(t *testing.T) {
+	f := newFakeCompute(t, map[string]map[string]string{"project-a": {"app_version": "1.0.0"}})
+	f.writeDelay = 20 * time.Millisecond
+	srv := newTestServer(t)
+
+	var ids []string
+	for i := 1; i <= 3; i++ {
+		var ro Rollout
+		body := fmt.Sprintf(`{"project":"project-a","zone":"europe-west1-d","group":"app-group","key":"app_version","value":"1.0.%d"}`, i)
+		status := request(t, srv, http.MethodPost, "/v1/rollouts", body, &ro)
+		if status != http.StatusAccepted {
+			t.Fatalf("POST rollout status = %v, want %v", status, http.StatusAccepted)
+		}
+		ids = append(ids, ro.ID)
+	}
+
+	for _, id := range ids {
+		ro := waitRollout(t, srv, id)
+		if ro.Status != StatusSucceeded {
+			t.Errorf("rollout %v %v: %v", ro.Request.Value, ro.Status, ro.Error)
+		}
+	}
+
+	want := []string{"1.0.1", "1.0.2", "1.0.3"}
+	if got := f.written("project-a", "app_version"); !reflect.DeepEqual(got, want) {
+		t.Errorf("wrote app_version %v, want %v in request order", got, want)
+	}
+}
+
+func TestRolloutsConcurrentGroups(t *testing.T) {
+	f := newFakeCompute(t, map[string]map[string]string{"project-a": {}})
+	f.writeDelay = 20 * time.Millisecond
+	srv := newTestServer(t)
+
+	var ids []string
+	for _, group := range []string{"api-group", "web-group", "worker-group"} {
+		var ro Rollout
+		body := fmt.Sprintf(`{"project":"project-a","zone":"europe-west1-d","group":%q,"key":%q,"value":"1.0.1"}`, group, group+"_version")
+		status := request(t, srv, http.MethodPost, "/v1/rollouts", body, &ro)
+		if status != http.StatusAccepted {
+			t.Fatalf("POST rollout status = %v, want %v", status, http.StatusAccepted)
+		}
+		ids = append(ids, ro.ID)
+	}
+
+	for _, id := range ids {
+		ro := waitRollout(t, srv, id)
+		if ro.Status != StatusSucceeded {
+			t.Errorf("rollout to %v %v: %v", ro.Request.Group, ro.Status, ro.Error)
+		}
+	}
+
+	for _, group := range []string{"api-group", "web-group", "worker-group"} {
+		if got := f.written("project-a", group+"_version"); !reflect.DeepEqual(got, []string{"1.0.1"}) {
+			t.Errorf("wrote %v_version %v, want [1.0.1]", group, got)
+		}
+	}
+}
+
+func TestLane(t *testing.T) {
+	tests := []struct {
+		dep  deploy.Deployment
+		want string
+	}{
+		{deploy.Deployment{Project: "project-a", Zone: "europe-west1-d"}, "project-a"},
+		{deploy.Deployment{Project: "project-a", Zone: "europe-west1-d", Group: "app-group"}, "project-a/europe-west1-d/app-group"},
+		{deploy.Deployment{Project: "project-a", Zone: "europe-west1-b", Group: "app-group"}, "project-a/europe-west1-b/app-group"},
+	}
+
+	for _, tt := range tests {
+		if got := lane(tt.dep); got != tt.want {
+			t.Errorf("lane(%+v) = %v, want %v", tt.dep, got, tt.want)
+		}
+	}
+}
+
+func TestQueue(t *testing.T) {
+	q := queue{pending: make(map[string][]func())}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	order := make(map[string][]int)
+	running := make(map[string]bool)
+	overlapped := false
+	started := make(chan string, 6)
+	release := make(chan struct{})
+
+	for i := 0; i < 3; i++ {
+		for _, lane := range []string{"a", "b"} {
+			i, lane := i, lane
+			wg.Add(1)
+			q.push(lane, func() {
+				defer wg.Done()
+
+				mu.Lock()
+				if running[lane] {
+					overlapped = true
+				}
+				running[lane] = true
+				order[lane] = append(order[lane], i)
+				mu.Unlock()
+
+				started <- lane
+				<-release
+
+				mu.Lock()
+				running[lane] = false
+				mu.Unlock()
+			})
+		}
+	}
+
+	// the first job of each lane runs without waiting for the other lane.
+	first := map[string]bool{<-started: true, <-started: true}
+	if !first["a"] || !first["b"] {
+		t.Errorf("first jobs started in lanes %v, want a and b", first)
+	}
+	close(release)
+	wg.Wait()
+
+	if overlapped {
+		t.Errorf("jobs of a lane ran at the same time")
+	}
+	for _, lane := range []string{"a", "b"} {
+		if !reflect.DeepEqual(order[lane], []int{0, 1, 2}) {
+			t.Errorf("lane %v ran jobs %v, want [0 1 2]", lane, order[lane])
+		}
+	}
+}
+
+func TestEvict(t *testing.T) {
+	now := time.Now()
+	finished := func(ago time.Duration) *time.Time {
+		t := now.Add(-ago)
+		return &t
+	}
+
+	s := New(nil, nil)
+	s.rollouts["old"] = &Rollout{ID: "old", Finished: finished(rolloutTTL + time.Minute)}
+	s.rollouts["recent"] = &Rollout{ID: "recent", Finished: finished(time.Minute)}
+	s.rollouts["running"] = &Rollout{ID: "running", Status: StatusRunning}
+	s.evict(now)
+
+	for id, kept := range map[string]bool{"old": false, "recent": true, "running": true} {
+		if _, ok := s.rollouts[id]; ok != kept {
+			t.Errorf("rollout %v kept = %v, want %v", id, ok, kept)
+		}
+	}
+
+	s.rollouts = make(map[string]*Rollout)
+	for i := 0; i < maxRollouts; i++ {
+		id := fmt.Sprint(i)
+		s.rollouts[id] = &Rollout{ID: id, Finished: finished(time.Duration(maxRollouts-i) * time.Second)}
+	}
+	s.rollouts["running"] = &Rollout{ID: "running", Status: StatusRunning}
+	s.evict(now)
+
+	if len(s.rollouts) != maxRollouts-1 {
+		t.Errorf("kept %d rollouts, want %d", len(s.rollouts), maxRollouts-1)
+	}
+	for _, id := range []string{"0", "1"} {
+		if _, ok := s.rollouts[id]; ok {
+			t.Errorf("oldest rollout %v kept", id)
+		}
+	}
+	if _, ok := s.rollouts["running"]; !ok {
+		t.Errorf("running rollout evicted")
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/fresh8/rollerderby/logger"
+)
+
+// Webhooks configures the Git webhook receivers.
+type Webhooks struct {
+	// GitHubSecret verifies the X-Hub-Signature-256 HMAC of GitHub
+	// deliveries, blank disables the GitHub receiver.
+	GitHubSecret string
+
+	// GitLabToken is compared to the X-Gitlab-Token of GitLab deliveries,
+	// blank disables the GitLab receiver.
+	GitLabToken string
+
+	Rules []Rule
+}
+
+// Rule starts Rollout when a push to Repository has a ref matching Ref.
+type Rule struct {
+	// Repository is the owner/name or group/project path of the repository.
+	Repository string
+
+	// Ref is a path.Match pattern such as refs/tags/v* matched against the
+	// full pushed ref.
+	Ref string
+
+	// Rollout is the rollout started with its value set to the tag name, or
+	// the pushed commit for branches.
+	Rollout RolloutRequest
+}
+
+// PushResult is the outcome of a rule matching a push, the started rollout
+// or the error it was refused with.
+type PushResult struct {
+	Project string   `json:"project"`
+	Zone    string   `json:"zone,omitempty"`
+	Group   string   `json:"group,omitempty"`
+	Key     string   `json:"key"`
+	Rollout *Rollout `json:"rollout,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// push is a push or tag push common to both providers.
+type push struct {
+	Repository string
+	Ref        string
+	Commit     string
+	Sender     string
+	Deleted    bool
+}
+
+// value returns the tag name for tags and the commit for anything else.
+func (p push) value() string {
+	if strings.HasPrefix(p.Ref, "refs/tags/") {
+		return strings.TrimPrefix(p.Ref, "refs/tags/")
+	}
+
+	return p.Commit
+}
+
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+// github handles POST /webhooks/github.
+func (s *Server) github(w http.ResponseWriter, r *http.Request) {
+	body, ok := s.webhookBody(w, r, s.Webhooks.GitHubSecret)
+	if !ok {
+		return
+	}
+
+	sig := strings.TrimPrefix(r.Header.Get("X-Hub-Signature-256"), "sha256=")
+	mac := hmac.New(sha256.New, []byte(s.Webhooks.GitHubSecret))
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid X-Hub-Signature-256"))
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event != "push" {
+		writeJSON(w, http.StatusOK, map[string]string{"ignored": "event " + event})
+		return
+	}
+
+	var payload struct {
+		Ref        string `json:"ref"`
+		After      string `json:"after"`
+		Deleted    bool   `json:"deleted"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		Sender struct {
+			Login string `json:"login"`
+		} `json:"sender"`
+	}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.deployPush(w, push{
+		Repository: payload.Repository.FullName,
+		Ref:        payload.Ref,
+		Commit:     payload.After,
+		Sender:     "github:" + payload.Sender.Login,
+		Deleted:    payload.Deleted,
+	})
+}
+
+// gitlab handles POST /webhooks/gitlab.
+func (s *Server) gitlab(w http.ResponseWriter, r *http.Request) {
+	body, ok := s.webhookBody(w, r, s.Webhooks.GitLabToken)
+	if !ok {
+		return
+	}
+
+	token := r.Header.Get("X-Gitlab-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.Webhooks.GitLabToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("invalid X-Gitlab-Token"))
+		return
+	}
+
+	event := r.Header.Get("X-Gitlab-Event")
+	if event != "Push Hook" && event != "Tag Push Hook" {
+		writeJSON(w, http.StatusOK, map[string]string{"ignored": "event " + event})
+		return
+	}
+
+	var payload struct {
+		Ref          string `json:"ref"`
+		After        string `json:"after"`
+		UserUsername string `json:"user_username"`
+		Project      struct {
+			PathWithNamespace string `json:"path_with_namespace"`
+		} `json:"project"`
+	}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.deployPush(w, push{
+		Repository: payload.Project.PathWithNamespace,
+		Ref:        payload.Ref,
+		Commit:     payload.After,
+		Sender:     "gitlab:" + payload.UserUsername,
+		Deleted:    payload.After == zeroCommit,
+	})
+}
+
+// webhookBody reads the request body of an enabled receiver writing an error
+// when the receiver is disabled or the request is not a POST.
+func (s *Server) webhookBody(w http.ResponseWriter, r *http.Request, secret string) ([]byte, bool) {
+	if secret == "" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("webhook receiver %v is not configured", r.URL.Path))
+		return nil, false
+	}
+
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v not allowed", r.Method))
+		return nil, false
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 5<<20))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+
+	return body, true
+}
+
+// deployPush starts the rollout of every rule matching p and writes the
+// result of each. The status is 202 when every rollout started, 207 when any
+// was refused and 200 when no rule matched, a refused rollout does not stop
+// the others.
+func (s *Server) deployPush(w http.ResponseWriter, p push) {
+	results := []PushResult{}
+	if p.Deleted {
+		writeJSON(w, http.StatusOK, results)
+		return
+	}
+
+	failed := false
+	for _, rule := range s.Webhooks.Rules {
+		matched, err := path.Match(rule.Ref, p.Ref)
+		if err != nil || !matched || rule.Repository != p.Repository {
+			continue
+		}
+
+		req := rule.Rollout
+		req.Value = p.value()
+		result := PushResult{Project: req.Project, Zone: req.Zone, Group: req.Group, Key: req.Key}
+		ctx := logger.WithFields(context.Background(), "project", req.Project, "group", req.Group, "key", req.Key)
+		ro, err := s.start(p.Sender, req)
+		if err != nil {
+			logger.Errorf(ctx, "webhook rollout of %v %v: %v", p.Repository, p.Ref, err)
+			result.Error = err.Error()
+			failed = true
+		} else {
+			logger.Infof(ctx, "webhook push of %v %v started rollout %v", p.Repository, p.Ref, ro.ID)
+			result.Rollout = &ro
+		}
+		results = append(results, result)
+	}
+
+	status := http.StatusOK
+	switch {
+	case failed:
+		status = http.StatusMultiStatus
+	case len(results) > 0:
+		status = http.StatusAccepted
+	}
+	writeJSON(w, status, results)
+}
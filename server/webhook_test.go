@@ -0,0 +1,188 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// webhookServer serves a newServer deploying tags of fresh8/app to the
+// groups api-group and web-group, the rollout to web-group is refused.
+func webhookServer(t *testing.T) *httptest.Server {
+	s := newServer(t)
+	s.Webhooks = Webhooks{
+		GitHubSecret: "github-secret",
+		GitLabToken:  "gitlab-token",
+		Rules: []Rule{
+			{
+				Repository: "fresh8/app",
+				Ref:        "refs/tags/v*",
+				Rollout:    RolloutRequest{Project: "project-a", Zone: "europe-west1-d", Group: "api-group", Key: "app_version"},
+			},
+			{
+				Repository: "fresh8/app",
+				Ref:        "refs/tags/v*",
+				Rollout:    RolloutRequest{Project: "project-a", Zone: "europe-west1-d", Group: "web-group", Key: "app_version", HealthTimeout: "soon"},
+			},
+			{
+				Repository: "fresh8/app",
+				Ref:        "refs/heads/main",
+				Rollout:    RolloutRequest{Project: "project-a", Key: "app_commit"},
+			},
+		},
+	}
+
+	return serve(t, s)
+}
+
+// deliver posts body to the receiver at path with headers returning the
+// status and the results, none when the response is not a list.
+func deliver(t *testing.T, srv *httptest.Server, path, body string, headers map[string]string) (int, []PushResult) {
+	req, err := http.NewRequest(http.MethodPost, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var results []PushResult
+	json.NewDecoder(resp.Body).Decode(&results)
+
+	return resp.StatusCode, results
+}
+
+// githubHeaders returns the headers of a GitHub event signed with secret.
+func githubHeaders(event, body, secret string) map[string]string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+
+	return map[string]string{
+		"X-GitHub-Event":      event,
+		"X-Hub-Signature-256": "sha256=" + hex.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+// githubPush returns a push of ref to fresh8/app by octocat.
+func githubPush(ref string, deleted bool) string {
+	b, _ := json.Marshal(map[string]interface{}{
+		"ref":        ref,
+		"after":      "2f3b1c9",
+		"deleted":    deleted,
+		"repository": map[string]string{"full_name": "fresh8/app"},
+		"sender":     map[string]string{"login": "octocat"},
+	})
+
+	return string(b)
+}
+
+func TestGitHubPartialFailure(t *testing.T) {
+	newFakeCompute(t, map[string]map[string]string{"project-a": {}})
+	srv := webhookServer(t)
+
+	body := githubPush("refs/tags/v1.2.3", false)
+	status, results := deliver(t, srv, "/webhooks/github", body, githubHeaders("push", body, "github-secret"))
+	if status != http.StatusMultiStatus {
+		t.Fatalf("status = %v, want %v", status, http.StatusMultiStatus)
+	}
+	if len(results) != 2 {
+		t.Fatalf("results = %+v, want 2", results)
+	}
+
+	api, web := results[0], results[1]
+	if api.Group != "api-group" || api.Rollout == nil || api.Error != "" {
+		t.Errorf("api-group result = %+v, want a started rollout", api)
+	}
+	if api.Rollout != nil && (api.Rollout.Request.Value != "v1.2.3" || api.Rollout.User != "github:octocat") {
+		t.Errorf("api-group rollout = %+v, want v1.2.3 by github:octocat", api.Rollout)
+	}
+	if web.Group != "web-group" || web.Rollout != nil || !strings.Contains(web.Error, "health_timeout") {
+		t.Errorf("web-group result = %+v, want the health_timeout error", web)
+	}
+
+	if api.Rollout != nil {
+		ro := waitRollout(t, srv, api.Rollout.ID)
+		if ro.Status != StatusSucceeded {
+			t.Errorf("api-group rollout %v: %v", ro.Status, ro.Error)
+		}
+	}
+}
+
+func TestGitHubDeliveries(t *testing.T) {
+	newFakeCompute(t, map[string]map[string]string{"project-a": {}})
+	srv := webhookServer(t)
+
+	branch := githubPush("refs/heads/main", false)
+	other := githubPush("refs/heads/feature", false)
+	deleted := githubPush("refs/tags/v1.2.3", true)
+
+	tests := []struct {
+		name    string
+		body    string
+		headers map[string]string
+		status  int
+		results int
+	}{
+		{"started", branch, githubHeaders("push", branch, "github-secret"), http.StatusAccepted, 1},
+		{"unmatched", other, githubHeaders("push", other, "github-secret"), http.StatusOK, 0},
+		{"deleted", deleted, githubHeaders("push", deleted, "github-secret"), http.StatusOK, 0},
+		{"other event", branch, githubHeaders("issues", branch, "github-secret"), http.StatusOK, 0},
+		{"bad signature", branch, githubHeaders("push", branch, "wrong"), http.StatusUnauthorized, 0},
+		{"unsigned", branch, map[string]string{"X-GitHub-Event": "push"}, http.StatusUnauthorized, 0},
+		{"malformed", `{"ref":`, githubHeaders("push", `{"ref":`, "github-secret"), http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		status, results := deliver(t, srv, "/webhooks/github", tt.body, tt.headers)
+		if status != tt.status {
+			t.Errorf("%v status = %v, want %v", tt.name, status, tt.status)
+		}
+		if len(results) != tt.results {
+			t.Errorf("%v results = %+v, want %d", tt.name, results, tt.results)
+		}
+		for _, r := range results {
+			if r.Rollout != nil {
+				waitRollout(t, srv, r.Rollout.ID)
+			}
+		}
+	}
+}
+
+func TestGitLab(t *testing.T) {
+	newFakeCompute(t, map[string]map[string]string{"project-a": {}})
+	srv := webhookServer(t)
+
+	b, _ := json.Marshal(map[string]interface{}{
+		"ref":           "refs/heads/main",
+		"after":         "2f3b1c9",
+		"user_username": "jdoe",
+		"project":       map[string]string{"path_with_namespace": "fresh8/app"},
+	})
+	body := string(b)
+
+	status, results := deliver(t, srv, "/webhooks/gitlab", body, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "gitlab-token"})
+	if status != http.StatusAccepted || len(results) != 1 || results[0].Rollout == nil {
+		t.Fatalf("push = %v %+v, want %v with a started rollout", status, results, http.StatusAccepted)
+	}
+	if results[0].Rollout.User != "gitlab:jdoe" || results[0].Rollout.Request.Value != "2f3b1c9" {
+		t.Errorf("rollout = %+v, want 2f3b1c9 by gitlab:jdoe", results[0].Rollout)
+	}
+
+	status, _ = deliver(t, srv, "/webhooks/gitlab", body, map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"})
+	if status != http.StatusUnauthorized {
+		t.Errorf("wrong token status = %v, want %v", status, http.StatusUnauthorized)
+	}
+
+	waitRollout(t, srv, results[0].Rollout.ID)
+}
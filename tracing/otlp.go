@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	statusOK    = 1
+	statusError = 2
+)
+
+// OTLP exports spans as JSON to an OTLP/HTTP collector.
+type OTLP struct {
+	// Endpoint is the collector base URL, spans are posted to
+	// Endpoint/v1/traces.
+	Endpoint    string
+	Headers     map[string]string
+	ServiceName string
+	Client      *http.Client
+}
+
+// NewOTLP returns an exporter for the collector at endpoint.
+func NewOTLP(endpoint, serviceName string, headers map[string]string) *OTLP {
+	return &OTLP{
+		Endpoint:    endpoint,
+		Headers:     headers,
+		ServiceName: serviceName,
+		Client:      &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+// Export implements Exporter.
+func (o *OTLP) Export(spans []*Span) error {
+	var out []otlpSpan
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Status:            otlpStatus{Code: statusOK},
+		}
+		if !s.ParentID.IsZero() {
+			span.ParentSpanID = s.ParentID.String()
+		}
+		for k, v := range s.Attrs {
+			span.Attributes = append(span.Attributes, otlpAttr{Key: k, Value: otlpValue{v}})
+		}
+		if s.Err != nil {
+			span.Status = otlpStatus{Code: statusError, Message: s.Err.Error()}
+		}
+		out = append(out, span)
+	}
+
+	body := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttr{{Key: "service.name", Value: otlpValue{o.ServiceName}}},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]string{"name": "rollerderby"},
+						"spans": out,
+					},
+				},
+			},
+		},
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimRight(o.Endpoint, "/") + "/v1/traces"
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := o.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP export to %v got status %v, want 2xx", url, resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+// Package tracing records spans around API calls and rollout phases and
+// exports them over OTLP.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MaxBuffered is the number of finished spans held until the next Flush, the
+// oldest are dropped beyond it so a long running process that flushes late
+// does not grow without bound.
+const MaxBuffered = 4096
+
+const (
+	// KindInternal marks a span for an operation within rollerderby.
+	KindInternal = 1
+
+	// KindClient marks a span for a call to a remote API.
+	KindClient = 3
+)
+
+// TraceID identifies a trace.
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span within a trace.
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsZero reports whether the id is unset.
+func (id SpanID) IsZero() bool {
+	return id == SpanID{}
+}
+
+// Span is a timed operation.
+type Span struct {
+	TraceID  TraceID
+	SpanID   SpanID
+	ParentID SpanID
+	Name     string
+	Kind     int
+	Start    time.Time
+	End      time.Time
+	Attrs    map[string]string
+	Err      error
+}
+
+// Exporter sends finished spans to a tracing backend.
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+var (
+	mu       sync.Mutex
+	exporter Exporter
+	finished []*Span
+
+	// dropped counts the spans dropped since the last flush.
+	dropped int
+)
+
+// SetExporter sets the exporter used by Flush, nil discards spans.
+func SetExporter(e Exporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	exporter = e
+}
+
+// Flush exports the spans finished since the last flush. An error is also
+// returned when spans were dropped beyond MaxBuffered.
+func Flush() error {
+	mu.Lock()
+	spans := finished
+	finished = nil
+	n := dropped
+	dropped = 0
+	e := exporter
+	mu.Unlock()
+
+	if e == nil || len(spans) == 0 {
+		return nil
+	}
+
+	err := e.Export(spans)
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		return fmt.Errorf("dropped %d spans finished beyond the buffer of %d", n, MaxBuffered)
+	}
+
+	return nil
+}
+
+// FlushEvery flushes every interval until ctx is done, reporting each error
+// to onErr.
+func FlushEvery(ctx context.Context, interval time.Duration, onErr func(error)) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		err := Flush()
+		if err != nil {
+			onErr(err)
+		}
+	}
+}
+
+type spanKey struct{}
+
+// Start begins a span named name as a child of the span in ctx, or a new
+// trace when ctx has none. attrs are key value pairs.
+func Start(ctx context.Context, name string, attrs ...string) (context.Context, *Span) {
+	s := &Span{
+		Name:  name,
+		Kind:  KindInternal,
+		Start: time.Now(),
+		Attrs: make(map[string]string),
+	}
+
+	parent, ok := ctx.Value(spanKey{}).(*Span)
+	if ok {
+		s.TraceID = parent.TraceID
+		s.ParentID = parent.SpanID
+	} else {
+		rand.Read(s.TraceID[:])
+	}
+	rand.Read(s.SpanID[:])
+
+	for i := 0; i+1 < len(attrs); i += 2 {
+		s.Attrs[attrs[i]] = attrs[i+1]
+	}
+
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Finish ends the span recording err as its status.
+func (s *Span) Finish(err error) {
+	s.End = time.Now()
+	s.Err = err
+
+	mu.Lock()
+	defer mu.Unlock()
+	if exporter == nil {
+		return
+	}
+
+	if len(finished) >= MaxBuffered {
+		n := len(finished) - MaxBuffered + 1
+		finished = append(finished[:0], finished[n:]...)
+		dropped += n
+	}
+	finished = append(finished, s)
+}
+
+// FromContext returns the span in ctx or nil.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// TraceIDFromContext returns the hex trace ID of the span in ctx or blank.
+func TraceIDFromContext(ctx context.Context) string {
+	s := FromContext(ctx)
+	if s == nil {
+		return ""
+	}
+
+	return s.TraceID.String()
+}
@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// recorder is an Exporter keeping the exported spans.
+type recorder struct {
+	spans []*Span
+}
+
+func (r *recorder) Export(spans []*Span) error {
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+func TestFlushDropsOldest(t *testing.T) {
+	r := &recorder{}
+	SetExporter(r)
+	defer SetExporter(nil)
+
+	for i := 0; i < MaxBuffered+10; i++ {
+		_, s := Start(context.Background(), "span", "i", fmt.Sprint(i))
+		s.Finish(nil)
+	}
+
+	err := Flush()
+	if err == nil || !strings.Contains(err.Error(), "dropped 10 spans") {
+		t.Errorf("Flush error = %v, want 10 spans dropped", err)
+	}
+	if len(r.spans) != MaxBuffered {
+		t.Errorf("exported %d spans, want %d", len(r.spans), MaxBuffered)
+	}
+	if r.spans[0].Attrs["i"] != "10" {
+		t.Errorf("first exported span i = %v, want the oldest kept", r.spans[0].Attrs["i"])
+	}
+
+	_, s := Start(context.Background(), "span")
+	s.Finish(nil)
+	err = Flush()
+	if err != nil || len(r.spans) != MaxBuffered+1 {
+		t.Errorf("second Flush = %v with %d spans, want no error and 1 more span", err, len(r.spans))
+	}
+}
+
+func TestFinishWithoutExporter(t *testing.T) {
+	SetExporter(nil)
+	_, s := Start(context.Background(), "span")
+	s.Finish(nil)
+
+	mu.Lock()
+	n := len(finished)
+	mu.Unlock()
+	if n != 0 {
+		t.Errorf("buffered %d spans without an exporter, want 0", n)
+	}
+}
@@ -0,0 +1,260 @@
+// Package verify probes instances over HTTP to confirm they run the deployed
+// value.
+package verify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// MatchExact passes when the trimmed body equals the expected value.
+	MatchExact = "exact"
+
+	// MatchContains passes when the body contains the expected value.
+	MatchContains = "contains"
+
+	// MatchRegex passes when the body matches the expected regular expression.
+	MatchRegex = "regex"
+)
+
+// maxBody is the number of body bytes read from each probe response.
+const maxBody = 64 * 1024
+
+// Target is an instance to probe, its fields are available to the URL and
+// expected body templates.
+type Target struct {
+	Name       string
+	Zone       string
+	InternalIP string
+	ExternalIP string
+	Value      string
+}
+
+// quoted returns t with every field quoted for use in a regular expression.
+func (t Target) quoted() Target {
+	return Target{
+		Name:       regexp.QuoteMeta(t.Name),
+		Zone:       regexp.QuoteMeta(t.Zone),
+		InternalIP: regexp.QuoteMeta(t.InternalIP),
+		ExternalIP: regexp.QuoteMeta(t.ExternalIP),
+		Value:      regexp.QuoteMeta(t.Value),
+	}
+}
+
+// Result is the outcome of probing a single target.
+type Result struct {
+	Target   Target
+	URL      string
+	Body     string
+	Attempts int
+	Err      error
+}
+
+// Passed reports whether the target returned the expected body.
+func (r Result) Passed() bool {
+	return r.Err == nil
+}
+
+// Probe requests a URL from each target and compares the response body to an
+// expected value.
+type Probe struct {
+	URL      *template.Template
+	Expect   *template.Template
+	Match    string
+	Retries  int
+	Interval time.Duration
+	Client   *http.Client
+}
+
+// New returns a Probe from URL and expected body templates, match is one of
+// MatchExact, MatchContains or MatchRegex.
+func New(url, expect, match string, retries int, interval time.Duration) (*Probe, error) {
+	u, err := template.New("url").Option("missingkey=error").Parse(url)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := template.New("expect").Option("missingkey=error").Parse(expect)
+	if err != nil {
+		return nil, err
+	}
+
+	switch match {
+	case MatchExact, MatchContains, MatchRegex:
+	default:
+		return nil, fmt.Errorf("verify.New unknown match %q", match)
+	}
+
+	return &Probe{
+		URL:      u,
+		Expect:   e,
+		Match:    match,
+		Retries:  retries,
+		Interval: interval,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// FailedError is returned by Run when one or more targets do not pass.
+type FailedError struct {
+	Failed []Result
+}
+
+func (e *FailedError) Error() string {
+	var s []string
+	for _, r := range e.Failed {
+		s = append(s, fmt.Sprintf("%v: %v", r.Target.Name, r.Err))
+	}
+
+	return fmt.Sprintf("%d instances failed verification: %v", len(e.Failed), strings.Join(s, "; "))
+}
+
+// Run probes every target returning the result for each. A *FailedError is
+// returned when any target fails all of its attempts, or the error of ctx
+// when it is done first.
+func (p *Probe) Run(ctx context.Context, targets []Target) ([]Result, error) {
+	var results []Result
+	var failed []Result
+	for _, t := range targets {
+		r := p.probe(ctx, t)
+		if ctx.Err() != nil {
+			return append(results, r), ctx.Err()
+		}
+		if !r.Passed() {
+			failed = append(failed, r)
+		}
+		results = append(results, r)
+	}
+
+	if len(failed) > 0 {
+		return results, &FailedError{Failed: failed}
+	}
+
+	return results, nil
+}
+
+func (p *Probe) probe(ctx context.Context, t Target) Result {
+	r := Result{Target: t}
+
+	url, err := render(p.URL, t)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.URL = url
+
+	// a regex substitutes the fields literally so a value such as 1.0.1
+	// cannot match 1x0y1.
+	expectTarget := t
+	if p.Match == MatchRegex {
+		expectTarget = t.quoted()
+	}
+	expect, err := render(p.Expect, expectTarget)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+
+	for r.Attempts = 1; ; r.Attempts++ {
+		r.Body, r.Err = p.get(ctx, url)
+		if r.Err == nil {
+			r.Err = p.match(r.Body, expect)
+		}
+
+		if r.Err == nil || r.Attempts > p.Retries {
+			return r
+		}
+
+		t := time.NewTimer(p.Interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return r
+		case <-t.C:
+		}
+	}
+}
+
+func (p *Probe) get(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := p.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return string(b), fmt.Errorf("got status %v, want %v", resp.StatusCode, http.StatusOK)
+	}
+
+	return string(b), nil
+}
+
+func (p *Probe) match(body, expect string) error {
+	switch p.Match {
+	case MatchContains:
+		if strings.Contains(body, expect) {
+			return nil
+		}
+	case MatchRegex:
+		re, err := regexp.Compile(expect)
+		if err != nil {
+			return err
+		}
+		if re.MatchString(body) {
+			return nil
+		}
+	default:
+		if strings.TrimSpace(body) == strings.TrimSpace(expect) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("got body %q, want %v %q", truncate(body, 80), p.Match, expect)
+}
+
+func render(t *template.Template, target Target) (string, error) {
+	var b bytes.Buffer
+	err := t.Execute(&b, target)
+	return b.String(), err
+}
+
+func truncate(s string, n int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= n {
+		return s
+	}
+
+	return s[:n] + "..."
+}
+
+// Print outputs a table of results.
+func Print(results []Result) {
+	fmt.Printf("%-30.30s | %-15.15s | %-6.6s | %-8.8s | %s\n", "instance", "ip", "passed", "attempts", "detail")
+	fmt.Printf("%s\n", strings.Repeat("=", 30+15+6+8+20+4*3))
+	for _, r := range results {
+		detail := truncate(r.Body, 40)
+		if r.Err != nil {
+			detail = r.Err.Error()
+		}
+		fmt.Printf("%-30.30s | %-15.15s | %-6v | %-8d | %s\n", r.Target.Name, r.Target.InternalIP, r.Passed(), r.Attempts, detail)
+	}
+}
@@ -0,0 +1,244 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProbeMatch(t *testing.T) {
+	tests := []struct {
+		match  string
+		body   string
+		expect string
+		passed bool
+	}{
+		{MatchExact, "1.0.1\n", "{{.Value}}", true},
+		{MatchExact, "1.0.10", "{{.Value}}", false},
+		{MatchContains, `{"version":"1.0.1"}`, `"version":"{{.Value}}"`, true},
+		{MatchContains, `{"version":"1.0.0"}`, `"version":"{{.Value}}"`, false},
+		{MatchRegex, "version 1.0.1 (abc)", `^version 1\.0\.\d+ `, true},
+		{MatchRegex, "version 2.0.0 (abc)", `^version 1\.0\.\d+ `, false},
+		{MatchRegex, "version 1.0.1 (abc)", `^version {{.Value}} \(\w+\)$`, true},
+		{MatchRegex, "version 1x0y1 (abc)", `^version {{.Value}} `, false},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, tt.body)
+		}))
+
+		p, err := New(srv.URL+"/version", tt.expect, tt.match, 0, time.Millisecond)
+		if err != nil {
+			t.Fatalf("New(%q, %q) error: %v", tt.expect, tt.match, err)
+		}
+
+		results, err := p.Run(context.Background(), []Target{{Name: "i-1", Value: "1.0.1"}})
+		srv.Close()
+		if len(results) != 1 {
+			t.Fatalf("Run(%v %q) returned %d results, want 1", tt.match, tt.body, len(results))
+		}
+		if results[0].Passed() != tt.passed {
+			t.Errorf("Run(%v %q) passed = %v, want %v: %v", tt.match, tt.body, results[0].Passed(), tt.passed, results[0].Err)
+		}
+		if _, ok := err.(*FailedError); ok == tt.passed {
+			t.Errorf("Run(%v %q) error = %v, want a *FailedError %v", tt.match, tt.body, err, !tt.passed)
+		}
+	}
+}
+
+func TestProbeRetries(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			fmt.Fprint(w, "1.0.0")
+			return
+		}
+		fmt.Fprint(w, "1.0.1")
+	}))
+	defer srv.Close()
+
+	p, err := New(srv.URL, "{{.Value}}", MatchExact, 5, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := p.Run(context.Background(), []Target{{Name: "i-1", Value: "1.0.1"}})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	if results[0].Attempts != 3 {
+		t.Errorf("Attempts = %d, want 3", results[0].Attempts)
+	}
+}
+
+func TestProbeRetriesExhausted(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		fmt.Fprint(w, "1.0.0")
+	}))
+	defer srv.Close()
+
+	p, err := New(srv.URL, "{{.Value}}", MatchExact, 2, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := p.Run(context.Background(), []Target{{Name: "i-1", Value: "1.0.1"}})
+	failed, ok := err.(*FailedError)
+	if !ok || len(failed.Failed) != 1 {
+		t.Fatalf("Run error = %v, want a *FailedError for 1 target", err)
+	}
+	if results[0].Attempts != 3 || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("Attempts = %d with %d requests, want 3", results[0].Attempts, calls)
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "1.0.0")
+	}))
+	defer srv.Close()
+
+	p, err := New(srv.URL, "{{.Value}}", MatchExact, 1000, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	results, err := p.Run(ctx, []Target{{Name: "i-1", Value: "1.0.1"}, {Name: "i-2", Value: "1.0.1"}})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Run error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(results) != 1 || results[0].Passed() {
+		t.Errorf("Run returned %+v, want 1 failed result", results)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run took %v after the context was done", elapsed)
+	}
+}
+
+func TestProbeStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "1.0.1")
+	}))
+	defer srv.Close()
+
+	p, err := New(srv.URL, "{{.Value}}", MatchExact, 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := p.Run(context.Background(), []Target{{Name: "i-1", Value: "1.0.1"}})
+	if _, ok := err.(*FailedError); !ok {
+		t.Fatalf("Run error = %v, want a *FailedError", err)
+	}
+	if results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "503") {
+		t.Errorf("result error = %v, want the 503 status", results[0].Err)
+	}
+}
+
+func TestProbeRegexQuotesFields(t *testing.T) {
+	tests := []struct {
+		body   string
+		value  string
+		passed bool
+	}{
+		{"build 1.0.0+meta.1 (c++)", "1.0.0+meta.1 (c++)", true},
+		{"build 1.0.0meta.1 (c)", "1.0.0+meta.1 (c++)", false},
+		{"build a|b", "a|b", true},
+		{"build b", "a|b", false},
+		{"build [x]", "[x]", true},
+		{"build x", "[x]", false},
+		{"build $HOME^", "$HOME^", true},
+		{`build \d`, `\d`, true},
+		{"build 7", `\d`, false},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, tt.body)
+		}))
+
+		p, err := New(srv.URL, `^build {{.Value}}$`, MatchRegex, 0, time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		results, _ := p.Run(context.Background(), []Target{{Name: "i-1", Value: tt.value}})
+		srv.Close()
+		if len(results) != 1 {
+			t.Fatalf("Run returned %d results, want 1", len(results))
+		}
+		if results[0].Passed() != tt.passed {
+			t.Errorf("regex value %q body %q passed %v, want %v", tt.value, tt.body, results[0].Passed(), tt.passed)
+		}
+	}
+}
+
+func TestProbeURL(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.URL.String())
+		fmt.Fprint(w, "ok")
+	}))
+	defer srv.Close()
+
+	p, err := New(srv.URL+"/{{.Zone}}/{{.Name}}?ip={{.InternalIP}}", "ok", MatchExact, 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := p.Run(context.Background(), []Target{{Name: "i-1", Zone: "europe-west1-d", InternalIP: "10.0.0.2"}})
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+
+	want := "/europe-west1-d/i-1?ip=10.0.0.2"
+	if len(got) != 1 || got[0] != want {
+		t.Errorf("requested %v, want %v", got, want)
+	}
+	if results[0].URL != srv.URL+want {
+		t.Errorf("result URL = %v, want %v", results[0].URL, srv.URL+want)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		url    string
+		expect string
+		match  string
+	}{
+		{"http://{{.IP}", "ok", MatchExact},
+		{"http://x", "{{", MatchExact},
+		{"http://x", "ok", "prefix"},
+	}
+
+	for _, tt := range tests {
+		if _, err := New(tt.url, tt.expect, tt.match, 0, 0); err == nil {
+			t.Errorf("New(%q, %q, %q) error = nil, want an error", tt.url, tt.expect, tt.match)
+		}
+	}
+}
+
+func TestProbeMissingField(t *testing.T) {
+	p, err := New("http://{{.Missing}}/", "ok", MatchExact, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := p.Run(context.Background(), []Target{{Name: "i-1"}})
+	if _, ok := err.(*FailedError); !ok || results[0].Attempts != 0 {
+		t.Errorf("Run error = %v after %d attempts, want a *FailedError before any request", err, results[0].Attempts)
+	}
+}